@@ -0,0 +1,36 @@
+// Package assets embeds game resources, so the game and the dedicated server share them
+package assets
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed stages/*
+var stages embed.FS
+
+// Stages are stage files like 1.stage and arena/1.stage
+var Stages, _ = fs.Sub(stages, "stages")
+
+//go:embed sfx/*
+var sfx embed.FS
+
+// Sfx are sound files like Shoot.wav
+var Sfx, _ = fs.Sub(sfx, "sfx")
+
+//go:embed music/*
+var music embed.FS
+
+// Music are tune files like stage.tune
+var Music, _ = fs.Sub(music, "music")
+
+//go:embed spritesheet.png
+var Spritesheet []byte
+
+// Atlas names sprites and animations of Spritesheet
+//
+//go:embed atlas.json
+var Atlas []byte
+
+//go:embed PressStart.ttf
+var Font []byte
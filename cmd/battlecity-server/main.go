@@ -0,0 +1,53 @@
+// Battlecity-server runs the game without a window. Clients play and spectate it over UDP:
+//
+//	go run ./cmd/battlecity-server -port 7777 -players 2
+//	go run . -connect host:7777
+//	go run . -spectate host:7777
+package main
+
+import (
+	"battlecity/assets"
+	"battlecity/game/headless"
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	port := flag.Int("port", netplay.DefaultPort, "UDP port")
+	players := flag.Int("players", netplay.Players, "number of players, the rest of clients spectate")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "game seed")
+	flag.Parse()
+	if *players < 1 || *players > 2 {
+		log.Fatalf("battlecity-server: %d players aren't supported", *players)
+	}
+	if err := sim.CheckStages(assets.Stages); err != nil {
+		log.Fatalf("battlecity-server: stages: %v", err)
+	}
+
+	server, err := netplay.Listen(*port, *players)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+
+	g := headless.NewServer(sim.Config{
+		StagesConfigs: assets.Stages,
+		Seed:          *seed,
+		Players:       *players,
+	}, server)
+
+	done := make(chan struct{})
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		close(done)
+	}()
+	log.Printf("listening on %v, %d players, seed %d", server.Addr(), *players, *seed)
+	g.Run(done)
+}
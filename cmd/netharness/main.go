@@ -0,0 +1,161 @@
+// Netharness plays a two players network game between two local peers with scripted inputs.
+// Packets are delayed and dropped to emulate a bad network, the run fails if peers desync.
+//
+// Usage:
+//
+//	go run ./cmd/netharness -mode rollback -latency 60ms -jitter 20ms -loss 0.05 -duration 1m
+package main
+
+import (
+	"battlecity/assets"
+	"battlecity/game"
+	"battlecity/game/atlas"
+	"battlecity/game/explosions"
+	"battlecity/game/netplay"
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
+	"flag"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"golang.org/x/image/font/basicfont"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+)
+
+type result struct {
+	name   string
+	ticks  uint32
+	stats  game.RollbackStats
+	err    error
+	passed time.Duration
+}
+
+// randomController presses random directions and fires from time to time
+type randomController struct {
+	rnd   *rand.Rand
+	input sim.Input
+}
+
+func (c *randomController) Poll(_ *pixelgl.Window) {}
+
+func (c *randomController) Read() sim.Input {
+	directions := []sim.Input{0, sim.InputUp, sim.InputRight, sim.InputDown, sim.InputLeft}
+	if c.rnd.Intn(30) == 0 {
+		c.input = directions[c.rnd.Intn(len(directions))]
+	}
+	input := c.input
+	if c.rnd.Intn(20) == 0 {
+		input |= sim.InputFire
+	}
+	return input
+}
+
+func main() {
+	mode := flag.String("mode", "rollback", "netcode: lockstep or rollback")
+	latency := flag.Duration("latency", 50*time.Millisecond, "one way packet delay")
+	jitter := flag.Duration("jitter", 10*time.Millisecond, "random extra packet delay")
+	loss := flag.Float64("loss", 0.05, "packet loss probability")
+	duration := flag.Duration("duration", 30*time.Second, "game duration")
+	port := flag.Int("port", netplay.DefaultPort+10000, "host UDP port")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "game seed")
+	flag.Parse()
+
+	netMode, inputDelay := netplay.Lockstep, netplay.DefaultInputDelay
+	switch *mode {
+	case "lockstep":
+	case "rollback":
+		netMode, inputDelay = netplay.Rollback, netplay.DefaultRollbackInputDelay
+	default:
+		log.Fatalf("netharness: unknown mode %q", *mode)
+	}
+	conditions := netplay.Conditions{Latency: *latency, Jitter: *jitter, Loss: *loss}
+
+	if err := sfx.Init(assets.Sfx, new(sfx.NullSink)); err != nil {
+		log.Fatal(err)
+	}
+	sprites, err := atlas.Load(pixel.MakePictureData(pixel.R(0, 0, 400, 256)), assets.Atlas)
+	if err != nil {
+		log.Fatal(err)
+	}
+	explosions.InnitExplosionFrames(sprites)
+	config := game.StateConfig{
+		Sprites:       sprites,
+		DefaultFont:   basicfont.Face7x13,
+		StagesConfigs: assets.Stages,
+		ScreenBounds:  game.ScreenBounds,
+		Players:       netplay.Players,
+	}
+
+	host, err := netplay.Host(*port, *seed, inputDelay, netMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := netplay.Join(fmt.Sprintf("127.0.0.1:%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	host.SetConditions(conditions)
+	client.SetConditions(conditions)
+
+	log.Printf("seed %d, %s netcode, latency %v±%v, loss %.0f%%", *seed, netMode, *latency, *jitter, *loss*100)
+	results := make(chan result)
+	go runPeer("host", host, config, *seed+1, *duration, results)
+	go runPeer("client", client, config, *seed+2, *duration, results)
+	failed := false
+	for i := 0; i < 2; i++ {
+		r := <-results
+		log.Printf("%s: %d ticks in %v, %d rollbacks (%d ticks resimulated, max depth %d), %d stalled frames",
+			r.name, r.ticks, r.passed.Round(time.Millisecond), r.stats.Rollbacks, r.stats.ResimulatedTicks,
+			r.stats.MaxDepth, r.stats.Stalls)
+		if r.err != nil {
+			log.Printf("%s: %v", r.name, r.err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runPeer plays the game at 60 frames per second until duration passes or the session breaks
+func runPeer(name string, session *netplay.Session, config game.StateConfig, inputsSeed int64,
+	duration time.Duration, results chan<- result) {
+	r := result{name: name}
+	defer func() {
+		session.Close()
+		results <- r
+	}()
+	for !session.Ready() {
+		if r.err = session.Err(); r.err != nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	config.Session = session
+	config.Seed = session.Seed()
+	config.Controllers = []game.Controller{&randomController{rnd: rand.New(rand.NewSource(inputsSeed))}}
+
+	var state game.State = game.NewPlaygroundState(config, 1, nil)
+	frame := time.NewTicker(time.Second / 60)
+	defer frame.Stop()
+	start, last := time.Now(), time.Now()
+	for r.passed < duration {
+		<-frame.C
+		dt := time.Since(last).Seconds()
+		last = time.Now()
+		if playground, ok := state.(*game.PlaygroundState); ok {
+			r.stats, _ = playground.RollbackStats()
+		}
+		if next := state.(game.HeadlessState).Step(dt); next != nil {
+			state = next
+		}
+		r.ticks = session.Tick()
+		r.passed = time.Since(start)
+		if r.err = session.Err(); r.err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package atlas names sprites and animations of the spritesheet, so their regions aren't scattered in code
+package atlas
+
+import (
+	"battlecity/game/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faiface/pixel"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Atlas is loaded from a JSON manifest like
+//
+//	{
+//		"sprites": {"bonus": [256, 128, 272, 144]},
+//		"animations": {"bonus": {"frames": ["bonus", ""], "duration": "150ms"}}
+//	}
+//
+// A sprite is the region [minX, minY, maxX, maxY] of the spritesheet. Frames of an animation are sprites shown
+// for the duration each, "" shows nothing. An animation plays loops times, forever if loops is 0.
+// Animations of bots carrying a bonus aren't listed, see bonusAnimation
+type Atlas struct {
+	picture    pixel.Picture
+	sprites    map[string]*pixel.Sprite
+	animations map[string]animation
+}
+
+type manifest struct {
+	Sprites    map[string][4]float64 `json:"sprites"`
+	Animations map[string]animation  `json:"animations"`
+}
+
+type animation struct {
+	Frames   []string `json:"frames"`
+	Duration duration `json:"duration"`
+	Loops    int      `json:"loops"`
+}
+
+// duration is a time.Duration written like "40ms"
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	*d = duration(value)
+	return err
+}
+
+// Load reads the manifest of sprites of picture
+func Load(picture pixel.Picture, data []byte) (*Atlas, error) {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Sprites) == 0 {
+		return nil, errors.New("no sprites")
+	}
+	a := &Atlas{picture: picture, sprites: make(map[string]*pixel.Sprite), animations: m.Animations}
+	for name, r := range m.Sprites {
+		frame := region(r)
+		if !contains(picture.Bounds(), frame.Norm()) {
+			return nil, fmt.Errorf("sprite %s %v is out of the spritesheet %v", name, r, picture.Bounds())
+		}
+		a.sprites[name] = pixel.NewSprite(picture, frame)
+	}
+	if err := a.checkAnimations(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Theme returns the atlas of a texture pack. The manifest names regions of the pack picture, nil takes regions of a.
+// Sprites and animations missing in the pack are the ones of a. Sprites of the pack which size differs from ones of a
+// are scaled to their size, so a pack drawn at double resolution is drawn at the resolution of a, not with more detail.
+// The picture of a must be *pixel.PictureData. Both pictures are joined into one, so batches can draw all sprites
+func (a *Atlas) Theme(picture pixel.Picture, data []byte) (*Atlas, error) {
+	base, ok := a.picture.(*pixel.PictureData)
+	if !ok {
+		return nil, errors.New("the default spritesheet isn't picture data")
+	}
+	pack := pixel.PictureDataFromPicture(picture)
+	m := manifest{Sprites: make(map[string][4]float64)}
+	if data == nil {
+		for name, sprite := range a.sprites {
+			f := sprite.Frame()
+			m.Sprites[name] = [4]float64{f.Min.X, f.Min.Y, f.Max.X, f.Max.Y}
+		}
+	} else if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(m.Sprites))
+	for name, r := range m.Sprites {
+		frame := region(r)
+		sprite, ok := a.sprites[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("unknown sprite %s", name)
+		case !contains(pack.Bounds(), frame.Norm()):
+			return nil, fmt.Errorf("sprite %s %v is out of the spritesheet %v", name, r, pack.Bounds())
+		case frame.Norm().Area() == 0:
+			return nil, fmt.Errorf("sprite %s %v is empty", name, r)
+		}
+		if frame.Norm().Size() != sprite.Frame().Norm().Size() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names) // the same pack is always joined the same way
+	// scaled sprites are stacked in a column
+	var scaledSize pixel.Vec
+	for _, name := range names {
+		size := a.sprites[name].Frame().Norm().Size()
+		scaledSize = pixel.V(math.Max(scaledSize.X, size.X), scaledSize.Y+size.Y)
+	}
+
+	// the pack is placed to the right of the default spritesheet, scaled sprites to the right of the pack
+	joined := pixel.MakePictureData(pixel.R(0, 0,
+		base.Rect.W()+pack.Rect.W()+scaledSize.X, math.Max(math.Max(base.Rect.H(), pack.Rect.H()), scaledSize.Y)))
+	baseOffset := base.Rect.Min.Scaled(-1)
+	packOffset := pixel.V(base.Rect.W(), 0).Sub(pack.Rect.Min)
+	copyPixels(joined, base, baseOffset)
+	copyPixels(joined, pack, packOffset)
+	scaledFrames := make(map[string]pixel.Rect)
+	scaledPos := pixel.V(base.Rect.W()+pack.Rect.W(), 0)
+	for _, name := range names {
+		frame := region(m.Sprites[name])
+		size := a.sprites[name].Frame().Norm().Size()
+		to := pixel.Rect{Min: scaledPos, Max: scaledPos.Add(size)}
+		scalePixels(joined, pack, frame.Norm(), to)
+		scaledPos.Y += size.Y
+		// a region with swapped corners is drawn flipped
+		if frame.Min.X > frame.Max.X {
+			to.Min.X, to.Max.X = to.Max.X, to.Min.X
+		}
+		if frame.Min.Y > frame.Max.Y {
+			to.Min.Y, to.Max.Y = to.Max.Y, to.Min.Y
+		}
+		scaledFrames[name] = to
+	}
+
+	t := &Atlas{picture: joined, sprites: make(map[string]*pixel.Sprite), animations: make(map[string]animation)}
+	for name, sprite := range a.sprites {
+		t.sprites[name] = pixel.NewSprite(joined, sprite.Frame().Moved(baseOffset))
+	}
+	for name, anim := range a.animations {
+		t.animations[name] = anim
+	}
+	for name, r := range m.Sprites {
+		if frame, ok := scaledFrames[name]; ok {
+			t.sprites[name] = pixel.NewSprite(joined, frame)
+		} else {
+			t.sprites[name] = pixel.NewSprite(joined, region(r).Moved(packOffset))
+		}
+	}
+	for name, anim := range m.Animations {
+		t.animations[name] = anim
+	}
+	if err := t.checkAnimations(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkAnimations reports an animation without frames or a duration, or with an unknown sprite
+func (a *Atlas) checkAnimations() error {
+	for name, anim := range a.animations {
+		if len(anim.Frames) == 0 || anim.Duration <= 0 {
+			return fmt.Errorf("animation %s needs frames and a duration", name)
+		}
+		for _, frame := range anim.Frames {
+			if _, ok := a.sprites[frame]; !ok && frame != "" {
+				return fmt.Errorf("animation %s: no sprite %q", name, frame)
+			}
+		}
+	}
+	return nil
+}
+
+// region returns the rectangle of [minX, minY, maxX, maxY]
+func region(r [4]float64) pixel.Rect {
+	return pixel.R(r[0], r[1], r[2], r[3])
+}
+
+// copyPixels copies all pixels of src to dst, moved by offset
+func copyPixels(dst, src *pixel.PictureData, offset pixel.Vec) {
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			at := pixel.V(x, y)
+			dst.Pix[dst.Index(at.Add(offset))] = src.Pix[src.Index(at)]
+		}
+	}
+}
+
+// scalePixels copies pixels of the region from of src to the region to of dst, the nearest pixel is taken
+func scalePixels(dst, src *pixel.PictureData, from, to pixel.Rect) {
+	ratio := pixel.V(from.W()/to.W(), from.H()/to.H())
+	for y := to.Min.Y; y < to.Max.Y; y++ {
+		for x := to.Min.X; x < to.Max.X; x++ {
+			// the center of the pixel of dst in src
+			at := from.Min.Add(pixel.V(x-to.Min.X+0.5, y-to.Min.Y+0.5).ScaledXY(ratio))
+			dst.Pix[dst.Index(pixel.V(x, y))] = src.Pix[src.Index(at)]
+		}
+	}
+}
+
+func contains(outer, inner pixel.Rect) bool {
+	return outer.Contains(inner.Min) && outer.Contains(inner.Max)
+}
+
+// Picture returns the spritesheet, e.g. for batches of sprites
+func (a *Atlas) Picture() pixel.Picture {
+	return a.picture
+}
+
+// Sprite returns the named sprite, it panics if there is no such sprite
+func (a *Atlas) Sprite(name string) *pixel.Sprite {
+	sprite, ok := a.sprites[name]
+	if !ok {
+		panic(fmt.Sprintf("atlas: no sprite %q", name))
+	}
+	return sprite
+}
+
+// Animation returns a new instance of the named animation, it panics if there is no such animation
+func (a *Atlas) Animation(name string) *utils.Animation {
+	anim, ok := a.animations[name]
+	if !ok {
+		anim, ok = a.bonusAnimation(name)
+	}
+	if !ok {
+		panic(fmt.Sprintf("atlas: no animation %q", name))
+	}
+	frames := make([]utils.AnimationFrame, len(anim.Frames))
+	for i, frame := range anim.Frames {
+		frames[i] = utils.AnimationFrame{Frame: a.sprites[frame], Duration: time.Duration(anim.Duration)}
+	}
+	return utils.NewAnimation(frames, anim.Loops)
+}
+
+// bonusAnimation derives the animation of a bot carrying a bonus from the animation of the bot.
+// The bot of bot.armored.4.bonus blinks between frames of bot.armored.4 and sprites bot.armored.bonus.0 and
+// bot.armored.bonus.1 of the bonus color, the bot of bot.armored.4.bonus_paused between the first ones of both
+func (a *Atlas) bonusAnimation(name string) (animation, bool) {
+	base, paused := strings.TrimSuffix(name, ".bonus"), false
+	if base == name {
+		base, paused = strings.TrimSuffix(name, ".bonus_paused"), true
+	}
+	parts := strings.Split(base, ".")
+	anim, ok := a.animations[base]
+	if base == name || len(parts) < 2 || parts[0] != "bot" || !ok || len(anim.Frames) == 0 {
+		return animation{}, false
+	}
+	bonusSprite := parts[0] + "." + parts[1] + ".bonus."
+	for _, sprite := range []string{bonusSprite + "0", bonusSprite + "1"} {
+		if _, ok := a.sprites[sprite]; !ok {
+			return animation{}, false
+		}
+	}
+	frames := make([]string, 8)
+	for i := range frames {
+		n := i % 2
+		if paused {
+			n = 0
+		}
+		if i < len(frames)/2 {
+			frames[i] = anim.Frames[n%len(anim.Frames)]
+		} else {
+			frames[i] = bonusSprite + strconv.Itoa(n)
+		}
+	}
+	return animation{Frames: frames, Duration: anim.Duration, Loops: anim.Loops}, true
+}
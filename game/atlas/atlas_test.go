@@ -0,0 +1,113 @@
+package atlas
+
+import (
+	"battlecity/assets"
+	"fmt"
+	"github.com/faiface/pixel"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// solidPicture returns a picture of w x h pixels of the color
+func solidPicture(w, h float64, c color.RGBA) *pixel.PictureData {
+	picture := pixel.MakePictureData(pixel.R(0, 0, w, h))
+	for i := range picture.Pix {
+		picture.Pix[i] = c
+	}
+	return picture
+}
+
+func TestThemeScalesSprites(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	a, err := Load(solidPicture(32, 16, red), []byte(`{
+		"sprites": {"tank": [0, 0, 16, 16], "flag": [16, 0, 32, 16], "bullet": [16, 8, 20, 4]},
+		"animations": {"tank": {"frames": ["tank", ""], "duration": "100ms"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tank is drawn at double resolution, bullet at half, flag keeps its size
+	pack := solidPicture(64, 32, blue)
+	theme, err := a.Theme(pack, []byte(`{"sprites": {
+		"tank": [0, 0, 32, 32], "flag": [32, 0, 48, 16], "bullet": [48, 4, 50, 2]
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := theme.Picture().(*pixel.PictureData)
+	for _, name := range []string{"tank", "flag", "bullet"} {
+		frame := theme.Sprite(name).Frame()
+		want := a.Sprite(name).Frame()
+		if frame.W() != want.W() || frame.H() != want.H() {
+			t.Errorf("%s is %vx%v, want %vx%v", name, frame.W(), frame.H(), want.W(), want.H())
+		}
+		norm := frame.Norm()
+		for y := norm.Min.Y; y < norm.Max.Y; y++ {
+			for x := norm.Min.X; x < norm.Max.X; x++ {
+				if c := joined.Pix[joined.Index(pixel.V(x, y))]; c != blue {
+					t.Fatalf("%s has pixel %v at (%v, %v), want the one of the pack", name, c, x, y)
+				}
+			}
+		}
+	}
+	if _, err := a.Theme(pack, []byte(`{"sprites": {"tank": [0, 0, 0, 16]}}`)); err == nil {
+		t.Error("an empty sprite is taken")
+	}
+}
+
+func TestBonusAnimation(t *testing.T) {
+	a, err := Load(solidPicture(64, 16, color.RGBA{A: 255}), []byte(`{
+		"sprites": {
+			"bot.armored.green.0": [0, 0, 16, 16], "bot.armored.green.1": [16, 0, 32, 16],
+			"bot.armored.bonus.0": [32, 0, 48, 16], "bot.armored.bonus.1": [48, 0, 64, 16]
+		},
+		"animations": {
+			"bot.armored.4": {"frames": ["bot.armored.green.0", "bot.armored.green.1"], "duration": "50ms"},
+			"bot.armored.1.bonus": {"frames": ["bot.armored.bonus.0"], "duration": "10ms"},
+			"bonus": {"frames": ["bot.armored.green.0"], "duration": "10ms"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	green, bonus := "bot.armored.green.", "bot.armored.bonus."
+	tests := []struct {
+		name   string
+		frames []string // nil if there is no such animation
+	}{
+		{"bot.armored.4.bonus", []string{green + "0", green + "1", green + "0", green + "1", bonus + "0", bonus + "1", bonus + "0", bonus + "1"}},
+		{"bot.armored.4.bonus_paused", []string{green + "0", green + "0", green + "0", green + "0", bonus + "0", bonus + "0", bonus + "0", bonus + "0"}},
+		{"bot.armored.3.bonus", nil},
+		{"bonus.bonus", nil},
+		{"bot.armored.4", nil}, // not a bonus one
+	}
+	for _, test := range tests {
+		anim, ok := a.bonusAnimation(test.name)
+		if ok != (test.frames != nil) {
+			t.Errorf("%s is derived: %v", test.name, ok)
+			continue
+		}
+		if fmt.Sprint(anim.Frames) != fmt.Sprint(test.frames) {
+			t.Errorf("%s has frames %v, want %v", test.name, anim.Frames, test.frames)
+		}
+		if ok && anim.Duration != duration(50*time.Millisecond) {
+			t.Errorf("%s lasts %v, want the duration of the bot", test.name, time.Duration(anim.Duration))
+		}
+	}
+	// a listed animation is taken as is, there is no bot.armored.1 to derive it from
+	a.Animation("bot.armored.1.bonus")
+
+	builtIn, err := Load(pixel.MakePictureData(pixel.R(0, 0, 400, 256)), assets.Atlas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []string{"default", "rapid_movement", "rapid_shooting", "armored.1", "armored.2", "armored.3", "armored.4"} {
+		for _, suffix := range []string{".bonus", ".bonus_paused"} {
+			if _, ok := builtIn.bonusAnimation("bot." + model + suffix); !ok {
+				t.Errorf("no animation bot.%s%s", model, suffix)
+			}
+		}
+	}
+}
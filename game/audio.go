@@ -0,0 +1,84 @@
+package game
+
+import (
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
+	"github.com/faiface/pixel"
+)
+
+// panWidth keeps sounds at the edges of the board in both speakers a bit
+const panWidth = 0.8
+
+// subscribeAudio plays sounds of events of world, replayed events are silent. Only engines of localPlayers are heard
+func subscribeAudio(world *sim.World, localPlayers []int) {
+	events := world.Events()
+	events.Subscribe(func(event sim.Event) {
+		if events.Replaying() {
+			return
+		}
+		sfx.SetTick(int(world.Ticks()))
+		switch e := event.(type) {
+		case sim.BulletFired:
+			if e.Tank.Side() == sim.HumanSide {
+				sfx.PlayShoot(pan(e.Tank.Pos()))
+			}
+		case sim.BlockHit:
+			if e.Tank.Side() != sim.HumanSide {
+				break
+			}
+			if e.Steel {
+				sfx.PlaySteelHit(pan(e.Pos))
+			} else {
+				sfx.PlayBrickHit(pan(e.Pos))
+			}
+		case sim.TankHit:
+			if e.Tank.Side() == sim.BotSide && !e.Destroyed {
+				sfx.PlayArmorHit(pan(e.Pos))
+			}
+		case sim.TankDestroyed:
+			if e.Tank.Side() == sim.BotSide {
+				sfx.PlayBotDestroyed(pan(e.Pos))
+			} else {
+				sfx.PlayPlayerDestroyed(pan(e.Pos))
+			}
+		case sim.BonusSpawned:
+			sfx.PlayBonusAppeared()
+		case sim.BonusTaken:
+			if e.Type == sim.LifeBonus && e.Player != nil {
+				sfx.PlayBonusTakenLife()
+			} else {
+				sfx.PlayBonusTakenOther()
+			}
+		case sim.HQDestroyed:
+			sfx.PlayHQDestroyed(pan(e.Pos))
+		case sim.GameOver:
+			sfx.PlayGameOver()
+			sfx.PlayMusic(sfx.GameOverMusic)
+		case sim.PlayerMoved:
+			if containsInt(localPlayers, e.Player.Index()) {
+				sfx.SetEngine(e.Player.Index(), e.Moving, pan(e.Player.Pos()))
+			}
+		case sim.GamePaused:
+			if e.Paused {
+				sfx.PlayPause()
+			} else {
+				sfx.StopPause()
+			}
+		}
+	})
+}
+
+// pan returns the stereo position of a sound at pos, from the left (-1) to the right (1) edge of the board
+func pan(pos pixel.Vec) float64 {
+	width := float64(sim.StageColumns * sim.BlockSize)
+	return (pos.X/width*2 - 1) * panWidth
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faiface/pixel/pixelgl"
+	"os"
+	"path/filepath"
+)
+
+// Bindings are controls of local players, they're kept in a JSON file:
+//
+//	{
+//		"players": [{"up": "W", "right": "D", "down": "S", "left": "A", "fire": "Space", "gamepad": 1}, ...],
+//		"pause": "Escape",
+//		"mute": "M",
+//		"deadzone": 0.3
+//	}
+type Bindings struct {
+	Players  [2]PlayerBindings `json:"players"`
+	Pause    Key               `json:"pause"`
+	Mute     Key               `json:"mute"`     // toggles all sounds
+	Deadzone float64           `json:"deadzone"` // of gamepad sticks, from 0 to 1
+}
+
+// PlayerBindings are keys of a player and the gamepad assigned to the player.
+// Gamepads are played with the d-pad or the left stick, A or X fires
+type PlayerBindings struct {
+	Up      Key `json:"up"`
+	Right   Key `json:"right"`
+	Down    Key `json:"down"`
+	Left    Key `json:"left"`
+	Fire    Key `json:"fire"`
+	Gamepad int `json:"gamepad"` // number of the joystick from 1, 0 for none
+}
+
+// Key is a keyboard key, it's written by its pixelgl name like "W" or "Space"
+type Key pixelgl.Button
+
+func (k Key) String() string {
+	return pixelgl.Button(k).String()
+}
+
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Key) UnmarshalText(text []byte) error {
+	for b := pixelgl.KeySpace; b <= pixelgl.KeyLast; b++ {
+		if b.String() == string(text) {
+			*k = Key(b)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key %q", text)
+}
+
+// DefaultBindings are the original controls, WASD and Space for the first player, arrows and Enter for the second
+func DefaultBindings() *Bindings {
+	return &Bindings{
+		Players: [2]PlayerBindings{
+			{
+				Up: Key(pixelgl.KeyW), Right: Key(pixelgl.KeyD), Down: Key(pixelgl.KeyS), Left: Key(pixelgl.KeyA),
+				Fire: Key(pixelgl.KeySpace), Gamepad: 1,
+			},
+			{
+				Up: Key(pixelgl.KeyUp), Right: Key(pixelgl.KeyRight), Down: Key(pixelgl.KeyDown),
+				Left: Key(pixelgl.KeyLeft), Fire: Key(pixelgl.KeyEnter), Gamepad: 2,
+			},
+		},
+		Pause:    Key(pixelgl.KeyEscape),
+		Mute:     Key(pixelgl.KeyM),
+		Deadzone: 0.3,
+	}
+}
+
+// LoadBindings reads bindings from the file at path, default bindings are returned if there is no file
+func LoadBindings(path string) (*Bindings, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultBindings(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// missing entries keep default bindings, but json resets the rest of an array, so players are read one by one
+	var file struct {
+		Players []json.RawMessage `json:"players"`
+	}
+	b := DefaultBindings()
+	err = json.Unmarshal(data, &file)
+	if err == nil && len(file.Players) > len(b.Players) {
+		err = fmt.Errorf("%d players aren't supported", len(file.Players))
+	}
+	players := b.Players
+	if err == nil {
+		err = json.Unmarshal(data, b)
+	}
+	b.Players = players
+	for i, raw := range file.Players {
+		if err == nil {
+			err = json.Unmarshal(raw, &b.Players[i])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bindings %s: %w", path, err)
+	}
+	if err := b.validate(); err != nil {
+		return nil, fmt.Errorf("bindings %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// Save writes bindings to the file at path
+func (b *Bindings) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (b *Bindings) validate() error {
+	if b.Deadzone < 0 || b.Deadzone >= 1 {
+		return fmt.Errorf("deadzone %v isn't in [0, 1)", b.Deadzone)
+	}
+	for i, p := range b.Players {
+		if p.Gamepad < 0 || p.Gamepad > int(pixelgl.JoystickLast)+1 {
+			return fmt.Errorf("player %d: no gamepad %d", i+1, p.Gamepad)
+		}
+	}
+	return nil
+}
+
+// joystick returns the gamepad of the player
+func (p *PlayerBindings) joystick() (pixelgl.Joystick, bool) {
+	return pixelgl.Joystick1 + pixelgl.Joystick(p.Gamepad-1), p.Gamepad > 0
+}
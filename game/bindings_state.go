@@ -0,0 +1,204 @@
+package game
+
+import (
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"log"
+	"strings"
+)
+
+type bindingItem int
+
+const (
+	bindingPlayerItem bindingItem = iota
+	bindingUpItem
+	bindingRightItem
+	bindingDownItem
+	bindingLeftItem
+	bindingFireItem
+	bindingGamepadItem
+	bindingPauseItem
+	bindingMuteItem
+	bindingBackItem
+)
+
+var bindingItemTitles = []string{"PLAYER", "UP", "RIGHT", "DOWN", "LEFT", "FIRE", "GAMEPAD", "PAUSE", "MUTE", "BACK"}
+
+// BindingsState rebinds controls of local players, they're saved to StateConfig.BindingsPath on leaving
+type BindingsState struct {
+	config    StateConfig
+	back      State // returned on leaving
+	player    int   // whose bindings are shown
+	selected  bindingItem
+	waiting   bool // for a key to bind to the selected item
+	unplugged bool // the gamepad of the shown player isn't connected
+	txt       *text.Text
+}
+
+func NewBindingsState(config StateConfig, back State) *BindingsState {
+	s := new(BindingsState)
+	s.config = config
+	s.back = back
+	if s.config.Bindings == nil {
+		s.config.Bindings = DefaultBindings()
+	}
+	atlas := text.NewAtlas(s.config.DefaultFont, text.ASCII)
+	s.txt = text.New(pixel.V(0, 0), atlas)
+	s.txt.LineHeight = atlas.LineHeight() * 1.4
+	return s
+}
+
+func (s *BindingsState) Update(win *pixelgl.Window, _ float64) State {
+	js, ok := s.config.Bindings.Players[s.player].joystick()
+	s.unplugged = ok && !win.JoystickPresent(js)
+	if s.waiting {
+		s.waitKey(win)
+		return nil
+	}
+	action := ReadMenuAction(win)
+	switch action {
+	case MenuUp:
+		s.selected = (s.selected + bindingBackItem) % (bindingBackItem + 1)
+	case MenuDown:
+		s.selected = (s.selected + 1) % (bindingBackItem + 1)
+	case MenuLeft, MenuRight:
+		delta := 1
+		if action == MenuLeft {
+			delta = -1
+		}
+		switch s.selected {
+		case bindingPlayerItem:
+			s.player = (s.player + delta + 2) % 2
+		case bindingGamepadItem:
+			gamepads := int(pixelgl.JoystickLast) + 2 // and none
+			p := &s.config.Bindings.Players[s.player]
+			p.Gamepad = (p.Gamepad + delta + gamepads) % gamepads
+		}
+	case MenuSelect:
+		switch s.selected {
+		case bindingPlayerItem, bindingGamepadItem: // changed by left and right
+		case bindingBackItem:
+			return s.leave()
+		default:
+			s.waiting = true
+		}
+	case MenuBack:
+		return s.leave()
+	}
+	return nil
+}
+
+// waitKey binds the first pressed key to the selected item, Escape cancels rebinding of player's controls
+func (s *BindingsState) waitKey(win *pixelgl.Window) {
+	for b := pixelgl.KeySpace; b <= pixelgl.KeyLast; b++ {
+		if !win.JustPressed(b) {
+			continue
+		}
+		s.waiting = false
+		if b == pixelgl.KeyEscape && s.selected != bindingPauseItem {
+			return
+		}
+		key := s.key(s.selected)
+		// the key can't do two things, the previous one gets the replaced key
+		for _, other := range s.keys() {
+			if *other == Key(b) {
+				*other = *key
+			}
+		}
+		*key = Key(b)
+		return
+	}
+}
+
+// key returns the binding of the item
+func (s *BindingsState) key(item bindingItem) *Key {
+	p := &s.config.Bindings.Players[s.player]
+	switch item {
+	case bindingUpItem:
+		return &p.Up
+	case bindingRightItem:
+		return &p.Right
+	case bindingDownItem:
+		return &p.Down
+	case bindingLeftItem:
+		return &p.Left
+	case bindingFireItem:
+		return &p.Fire
+	case bindingMuteItem:
+		return &s.config.Bindings.Mute
+	}
+	return &s.config.Bindings.Pause
+}
+
+// keys returns all bound keys
+func (s *BindingsState) keys() []*Key {
+	keys := []*Key{&s.config.Bindings.Pause, &s.config.Bindings.Mute}
+	for i := range s.config.Bindings.Players {
+		p := &s.config.Bindings.Players[i]
+		keys = append(keys, &p.Up, &p.Right, &p.Down, &p.Left, &p.Fire)
+	}
+	return keys
+}
+
+// readsKeys is true while a key to bind is waited, it mustn't mute the game
+func (s *BindingsState) readsKeys() bool {
+	return s.waiting
+}
+
+func (s *BindingsState) leave() State {
+	if s.config.BindingsPath != "" {
+		if err := s.config.Bindings.Save(s.config.BindingsPath); err != nil {
+			log.Printf("bindings_state: %v", err)
+		}
+	}
+	return s.back
+}
+
+func (s *BindingsState) Draw(canvas *pixelgl.Canvas, _ float64) {
+	p := s.config.Bindings.Players[s.player]
+	lines := []string{"CONTROLS"}
+	for item := bindingPlayerItem; item <= bindingBackItem; item++ {
+		value := ""
+		switch item {
+		case bindingPlayerItem:
+			value = fmt.Sprintf("< %d >", s.player+1)
+		case bindingGamepadItem:
+			value = "< NONE >"
+			if p.Gamepad > 0 {
+				value = fmt.Sprintf("< %d >", p.Gamepad)
+				if s.unplugged {
+					value += " OFF"
+				}
+			}
+		case bindingBackItem:
+		default:
+			value = strings.ToUpper(s.key(item).String())
+			if s.waiting && item == s.selected {
+				value = "..."
+			}
+		}
+		lines = append(lines, strings.TrimSpace(bindingItemTitles[item]+"  "+value))
+	}
+
+	canvas.Clear(colornames.Black)
+	s.txt.Clear()
+	center := canvas.Bounds().Center()
+	s.txt.Orig = pixel.V(0, center.Y+s.txt.LineHeight*float64(len(lines))/2)
+	s.txt.Dot = s.txt.Orig
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			s.txt.Color = colornames.Firebrick
+		case bindingItem(i-1) == s.selected:
+			s.txt.Color = colornames.Gold
+		default:
+			s.txt.Color = colornames.White
+		}
+		s.txt.Dot.X = center.X - s.txt.BoundsOf(line).W()/2
+		_, _ = fmt.Fprintln(s.txt, line)
+	}
+	s.txt.Draw(canvas, pixel.IM)
+}
@@ -1,62 +1,46 @@
 package game
 
 import (
+	"battlecity/game/atlas"
+	"battlecity/game/sim"
 	"battlecity/game/utils"
 	"github.com/faiface/pixel"
-	"github.com/faiface/pixel/pixelgl"
-	"math/rand"
-	"time"
 )
 
-type BonusType int
+// bonusNames name sprites of bonuses in the atlas, by sim.BonusType
+var bonusNames = []string{"immunity", "time_stop", "hq_armor", "upgrade", "annihilation", "life", "ship"}
 
-const BonusSize = 16
-
-const (
-	ImmunityBonus BonusType = iota
-	TimeStopBonus
-	HQArmorBonus
-	UpgradeBonus
-	AnnihilationBonus
-	LifeBonus
-)
-
-type Bonus struct {
-	pos       pixel.Vec
-	bonusType BonusType
-	model     *utils.Animation
+// bonusView draws the bonus on the stage
+type bonusView struct {
+	bonus      *sim.Bonus
+	bonusType  sim.BonusType
+	pos        pixel.Vec
+	model      *utils.Animation
+	blinkModel *utils.Animation
 }
 
-func NewBonus(spritesheet pixel.Picture, blocks [stageColumns][stageRows]*Block) *Bonus {
-	bonus := new(Bonus)
-	bonus.bonusType = BonusType(rand.Intn(6))
-	var pos pixel.Vec
-	for {
-		row, column := rand.Intn(stageRows), rand.Intn(stageColumns)
-		block := blocks[row][column]
-		if block.bonus {
-			r := Rect(block.pos, BlockSize, BlockSize)
-			minMaxPoints := []pixel.Vec{r.Min, r.Max}
-			pos = minMaxPoints[rand.Intn(len(minMaxPoints))]
-			break
-		}
-	}
-	bonus.pos = pos
-	duration := time.Millisecond * 150
-	minXStart, maxXStart, frameW := 256, 272, 16
-	minX := float64(minXStart + frameW*int(bonus.bonusType))
-	maxX := float64(maxXStart + frameW*int(bonus.bonusType))
-	bonus.model = utils.NewAnimation([]utils.AnimationFrame{
-		{Frame: pixel.NewSprite(spritesheet, pixel.R(minX, 128, maxX, 144)), Duration: duration},
-		{Frame: nil, Duration: duration},
-	}, -1)
+func newBonusView(sprites *atlas.Atlas, bonus *sim.Bonus) *bonusView {
+	v := new(bonusView)
+	v.bonus = bonus
+	v.bonusType = bonus.Type()
+	v.pos = bonus.Pos()
+	v.model = sprites.Animation("bonus." + bonusNames[v.bonusType])
+	v.blinkModel = sprites.Animation("bonus." + bonusNames[v.bonusType] + ".blink")
+	return v
+}
 
-	return bonus
+// shows reports whether the view draws bonus, otherwise a new one is needed
+func (v *bonusView) shows(bonus *sim.Bonus) bool {
+	return v.bonus == bonus && v.bonusType == bonus.Type() && v.pos == bonus.Pos()
 }
 
-func (b *Bonus) Draw(win *pixelgl.Window, dt float64) {
-	frame := b.model.CurrentFrame(dt)
+func (v *bonusView) Draw(target pixel.Target, dt float64) {
+	model := v.model
+	if v.bonus.IsBlinking() { // blink faster when about to expire
+		model = v.blinkModel
+	}
+	frame := model.CurrentFrame(dt)
 	if frame != nil {
-		frame.Draw(win, pixel.IM.Moved(b.pos).Scaled(b.pos, Scale))
+		frame.Draw(target, pixel.IM.Moved(v.pos))
 	}
 }
@@ -0,0 +1,67 @@
+package game
+
+import (
+	"battlecity/game/explosions"
+	"battlecity/game/sim"
+	"github.com/faiface/pixel"
+)
+
+// explosionLayer shows explosions of events. Explosions aren't simulated,
+// but they're saved to snapshots, so explosions of undone ticks disappear
+type explosionLayer struct {
+	explosions []*explosions.Explosion
+}
+
+// explosionsSnapshot is a copy of explosions of explosionLayer
+type explosionsSnapshot []*explosions.Explosion
+
+func newExplosionLayer(events *sim.Events) *explosionLayer {
+	l := new(explosionLayer)
+	events.Subscribe(l.handle)
+	return l
+}
+
+func (l *explosionLayer) handle(event sim.Event) {
+	if kind, pos, ok := sim.Explosion(event); ok {
+		l.add(explosions.ExplosionType(kind), pos)
+	}
+}
+
+func (l *explosionLayer) add(explosionType explosions.ExplosionType, pos pixel.Vec) {
+	l.explosions = append(l.explosions, explosions.NewExplosion(explosionType, pos))
+}
+
+// addStates starts explosions received from the server which happened after the state with tick since
+func (l *explosionLayer) addStates(exps []sim.ExplosionState, since uint32) {
+	for _, e := range exps {
+		if e.Tick > since {
+			l.add(explosions.ExplosionType(e.Type), pixel.V(float64(e.X), float64(e.Y)))
+		}
+	}
+}
+
+// removeEnded removes explosions which are over
+func (l *explosionLayer) removeEnded() {
+	for i := 0; i < len(l.explosions); i++ {
+		exp := l.explosions[i]
+		if exp.IsEnded() {
+			l.explosions[i] = l.explosions[len(l.explosions)-1]
+			l.explosions = l.explosions[:len(l.explosions)-1]
+		}
+	}
+}
+
+func (l *explosionLayer) Draw(target pixel.Target, dt float64, isPaused bool) {
+	for _, explosion := range l.explosions {
+		explosion.Draw(target, dt, isPaused)
+	}
+}
+
+// Save copies explosions to snapshot, its memory is reused
+func (l *explosionLayer) Save(snapshot explosionsSnapshot) explosionsSnapshot {
+	return append(snapshot[:0], l.explosions...)
+}
+
+func (l *explosionLayer) Restore(snapshot explosionsSnapshot) {
+	l.explosions = append([]*explosions.Explosion(nil), snapshot...)
+}
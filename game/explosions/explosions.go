@@ -1,10 +1,9 @@
 package explosions
 
 import (
+	"battlecity/game/atlas"
 	"battlecity/game/utils"
 	"github.com/faiface/pixel"
-	"github.com/faiface/pixel/pixelgl"
-	"time"
 )
 
 type ExplosionType int
@@ -14,27 +13,13 @@ const (
 	TankExplosion
 )
 
-var (
-	scale           float64
-	explosionFrames [5]*pixel.Sprite
-	initialized     bool
-)
+var sprites *atlas.Atlas // of explosion animations
 
-func InnitExplosionFrames(spritesheet pixel.Picture, scl float64) {
-	if initialized {
+func InnitExplosionFrames(a *atlas.Atlas) {
+	if sprites != nil {
 		panic("explosions: already initialized")
 	}
-	scale = scl
-	explosionFrames = [5]*pixel.Sprite{
-		// 16x16
-		pixel.NewSprite(spritesheet, pixel.R(256, 112, 272, 128)),
-		pixel.NewSprite(spritesheet, pixel.R(272, 112, 288, 128)),
-		pixel.NewSprite(spritesheet, pixel.R(288, 112, 304, 128)),
-		// 32x32
-		pixel.NewSprite(spritesheet, pixel.R(304, 96, 336, 128)),
-		pixel.NewSprite(spritesheet, pixel.R(336, 96, 368, 128)),
-	}
-	initialized = true
+	sprites = a
 }
 
 type Explosion struct {
@@ -49,79 +34,27 @@ func NewExplosion(explosionType ExplosionType, pos pixel.Vec) *Explosion {
 	e.explosionType = explosionType
 	e.pos = pos
 	if e.explosionType == BulletExplosion {
-		duration := time.Millisecond * 25
-		e.model = utils.NewAnimation([]utils.AnimationFrame{
-			{
-				Frame:    explosionFrames[0],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[1],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[2],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[1],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[0],
-				Duration: duration,
-			},
-		}, 1)
+		e.model = sprites.Animation("explosion.bullet")
 	} else {
-		duration := time.Millisecond * 40
-		e.model = utils.NewAnimation([]utils.AnimationFrame{
-			{
-				Frame:    explosionFrames[0],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[1],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[2],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[3],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[4],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[3],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[2],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[1],
-				Duration: duration,
-			},
-			{
-				Frame:    explosionFrames[0],
-				Duration: duration,
-			},
-		}, 1)
+		e.model = sprites.Animation("explosion.tank")
 	}
 
 	return e
 }
 
+func (e *Explosion) Type() ExplosionType {
+	return e.explosionType
+}
+
+func (e *Explosion) Pos() pixel.Vec {
+	return e.pos
+}
+
 func (e *Explosion) IsEnded() bool {
 	return e.isEnded
 }
 
-func (e *Explosion) Draw(win *pixelgl.Window, dt float64, isPaused bool) {
+func (e *Explosion) Draw(target pixel.Target, dt float64, isPaused bool) {
 	if isPaused {
 		dt = 0
 	}
@@ -130,5 +63,5 @@ func (e *Explosion) Draw(win *pixelgl.Window, dt float64, isPaused bool) {
 		e.isEnded = true
 		return
 	}
-	frame.Draw(win, pixel.IM.Moved(e.pos).Scaled(e.pos, scale))
+	frame.Draw(target, pixel.IM.Moved(e.pos))
 }
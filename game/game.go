@@ -1,40 +1,170 @@
 package game
 
 import (
-	"embed"
+	"battlecity/game/atlas"
+	"battlecity/game/netplay"
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
+	"golang.org/x/image/colornames"
 	"golang.org/x/image/font"
+	"io/fs"
+	"log"
+	"math"
 )
 
 type State interface {
 	Update(win *pixelgl.Window, dt float64) State
-	Draw(win *pixelgl.Window, dt float64)
+	Draw(canvas *pixelgl.Canvas, dt float64)
+}
+
+// keyReader is a State which reads any keys at times, e.g. typed text. Hotkeys of Game are off meanwhile
+type keyReader interface {
+	readsKeys() bool
+}
+
+// HeadlessState is a State which can run without a window, e.g. in tests
+type HeadlessState interface {
+	State
+	Step(dt float64) State
 }
 
 type StateConfig struct {
-	Spritesheet   pixel.Picture
-	DefaultFont   font.Face
-	StagesConfigs embed.FS
-	WindowBounds  pixel.Rect
+	Sprites        *atlas.Atlas // named sprites of the spritesheet
+	DefaultFont    font.Face
+	StagesConfigs  fs.FS            // stage files like 1.stage and arena/1.stage
+	ScreenBounds   pixel.Rect       // of the screen the game is drawn on, it's scaled to the window
+	IntegerScaling bool             // the screen is scaled by whole multiples only, it keeps pixels sharp
+	Monitor        *pixelgl.Monitor // Alt+Enter and F11 toggle fullscreen on it, the primary monitor if nil
+	Rules          sim.Rules
+	Difficulty     sim.Difficulty
+	FirstStage     int // of a new game, 0 is the first stage
+	Seed           int64
+	Players        int              // number of players
+	Session        *netplay.Session // nil for offline game
+	Controllers    []Controller     // of local players, see Bindings if nil
+	Bindings       *Bindings        // shared by states, default bindings if nil
+	BindingsPath   string           // file of Bindings, empty disables saving
+	Match          *sim.Match       // versus match in progress, nil in co-op
+	SavePath       string           // file of the save slot, empty disables saving
+	SettingsPath   string           // file of Settings, options changed in the game are saved to it if not empty
+}
+
+// simConfig returns what the simulation depends on
+func (c StateConfig) simConfig() sim.Config {
+	return sim.Config{
+		StagesConfigs: c.StagesConfigs,
+		Rules:         c.Rules,
+		Difficulty:    c.Difficulty,
+		Seed:          c.Seed,
+		Players:       c.Players,
+		Match:         c.Match,
+	}
+}
+
+// withSimConfig returns c with the simulation config of a game, e.g. a loaded one
+func (c StateConfig) withSimConfig(config sim.Config) StateConfig {
+	c.StagesConfigs = config.StagesConfigs
+	c.Rules = config.Rules
+	c.Difficulty = config.Difficulty
+	c.Seed = config.Seed
+	c.Players = config.Players
+	c.Match = config.Match
+	return c
+}
+
+func (c StateConfig) firstStage() int {
+	if c.FirstStage == 0 {
+		return 1
+	}
+	return c.FirstStage
 }
 
 type Game struct {
-	currentState State
+	currentState   State
+	canvas         *pixelgl.Canvas // states are drawn on it at the original resolution
+	integerScaling bool
+	monitor        *pixelgl.Monitor
+	bindings       *Bindings
+	settingsPath   string
 }
 
 func NewGame(config StateConfig) *Game {
-	game := new(Game)
+	game := newGame(config)
 	game.currentState = NewMainMenuState(config)
 	return game
 }
 
+func newGame(config StateConfig) *Game {
+	game := new(Game)
+	game.canvas = pixelgl.NewCanvas(config.ScreenBounds)
+	game.integerScaling = config.IntegerScaling
+	game.monitor = config.Monitor
+	game.bindings = config.Bindings
+	if game.bindings == nil {
+		game.bindings = DefaultBindings()
+	}
+	game.settingsPath = config.SettingsPath
+	return game
+}
+
+// NewRemoteGame plays or spectates the game on the dedicated server at addr (host:port)
+func NewRemoteGame(config StateConfig, addr string, spectate bool) *Game {
+	game := newGame(config)
+	game.currentState = NewRemoteState(config, addr, spectate)
+	return game
+}
+
 func (g *Game) Run(win *pixelgl.Window, dt float64) {
-	newState := g.currentState.Update(win, dt)
-	if newState != nil {
-		g.currentState = newState
+	alt := win.Pressed(pixelgl.KeyLeftAlt) || win.Pressed(pixelgl.KeyRightAlt)
+	if alt && win.JustPressed(pixelgl.KeyEnter) || win.JustPressed(pixelgl.KeyF11) {
+		// states don't get this frame, otherwise Enter would select a menu item or fire
+		g.SetFullscreen(win, win.Monitor() == nil)
+	} else {
+		if g.hotkeysOn() && win.JustPressed(pixelgl.Button(g.bindings.Mute)) {
+			sfx.ToggleMute()
+			if err := saveAudio(g.settingsPath); err != nil {
+				log.Printf("game: %v", err)
+			}
+		}
+		if newState := g.currentState.Update(win, dt); newState != nil {
+			g.currentState = newState
+			return
+		}
+	}
+
+	g.currentState.Draw(g.canvas, dt)
+	// the window is laid out on every frame, so it can be resized or made fullscreen at any time
+	win.Clear(colornames.Black)
+	g.canvas.Draw(win, pixel.IM.Scaled(pixel.ZV, g.scale(win.Bounds())).Moved(win.Bounds().Center()))
+}
+
+// hotkeysOn reports whether the current state lets hotkeys work, see keyReader
+func (g *Game) hotkeysOn() bool {
+	reader, ok := g.currentState.(keyReader)
+	return !ok || !reader.readsKeys()
+}
+
+// SetFullscreen makes the window fullscreen on the monitor of the game or restores its previous size.
+// The window must be created windowed, otherwise pixelgl doesn't know the size to restore
+func (g *Game) SetFullscreen(win *pixelgl.Window, fullscreen bool) {
+	if !fullscreen {
+		win.SetMonitor(nil)
 		return
 	}
+	monitor := g.monitor
+	if monitor == nil {
+		monitor = pixelgl.PrimaryMonitor()
+	}
+	win.SetMonitor(monitor)
+}
 
-	g.currentState.Draw(win, dt)
+// scale returns the largest scale of the canvas which fits into the window bounds, the rest is letterboxed
+func (g *Game) scale(bounds pixel.Rect) float64 {
+	scale := math.Min(bounds.W()/g.canvas.Bounds().W(), bounds.H()/g.canvas.Bounds().H())
+	if g.integerScaling && scale >= 1 {
+		return math.Floor(scale)
+	}
+	return scale
 }
@@ -0,0 +1,157 @@
+// Package headless runs the game on the dedicated server. It needs neither a window nor audio,
+// clients draw the sent states, see game.RemoteState
+package headless
+
+import (
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"time"
+)
+
+// History is how many sent states are remembered to encode deltas and explosions against
+const History = 64
+
+// stageTitleTicks is how long clients show the title of the next stage, nothing is sent meanwhile
+const stageTitleTicks = 3 * 60
+
+// Server runs the game and sends its state to clients of netplay.Server
+type Server struct {
+	config     sim.Config
+	server     *netplay.Server
+	inputs     *inputSource
+	world      *sim.World // nil while the title of the next stage is shown
+	stageNum   int        // of the next stage while world is nil
+	players    []*sim.Player
+	wait       int                  // ticks left before the next stage starts
+	explosions []sim.ExplosionState // happened since the previous state
+	tick       uint32               // of the last sent state, 0 before the first one
+	history    [History]frame
+}
+
+// frame is what's needed of a sent state to encode next ones
+type frame struct {
+	tick       uint32
+	stageNum   int
+	stage      sim.StageSnapshot
+	explosions []sim.ExplosionState // happened since the previous state
+}
+
+// NewServer creates a game played by clients of server, config.Players is the number of players
+func NewServer(config sim.Config, server *netplay.Server) *Server {
+	s := &Server{config: config, server: server, inputs: newInputSource(server, config.Players)}
+	s.nextStage(1, nil)
+	return s
+}
+
+// Run simulates the game in real time until done is closed
+func (s *Server) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / 60) // sim.TickDt
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		s.Step()
+	}
+}
+
+// Step advances the game by sim.TickDt and sends the new state to clients
+func (s *Server) Step() {
+	if s.world == nil {
+		s.wait--
+		if s.wait <= 0 {
+			s.startStage()
+		}
+		return
+	}
+	if s.world.Tick(s.inputs.NextInputs()) {
+		s.nextStage(s.world.StageNum()+1, s.world.Players())
+		return
+	}
+	s.broadcast()
+}
+
+// nextStage shows the title of the stage stageNum before it starts, players come from the previous stage
+func (s *Server) nextStage(stageNum int, players []*sim.Player) {
+	s.world = nil
+	s.stageNum, s.players = stageNum, players
+	s.wait = stageTitleTicks
+}
+
+func (s *Server) startStage() {
+	s.world = sim.NewWorld(s.config, s.stageNum, s.players)
+	s.world.Events().Subscribe(func(event sim.Event) {
+		if kind, pos, ok := sim.Explosion(event); ok {
+			s.explosions = append(s.explosions, sim.ExplosionState{
+				Tick: s.tick + 1, Type: uint8(kind), X: float32(pos.X), Y: float32(pos.Y),
+			})
+		}
+	})
+}
+
+func (s *Server) broadcast() {
+	s.tick++
+	frame := &s.history[s.tick%History]
+	frame.tick = s.tick
+	frame.stageNum = s.world.StageNum()
+	s.world.Stage().Save(&frame.stage)
+	frame.explosions, s.explosions = s.explosions, nil
+
+	for _, peer := range s.server.Peers() {
+		var baseline *sim.StageSnapshot
+		var baselineTick uint32
+		since := s.tick - 1
+		if ack, ok := s.frame(peer.StateAck); ok {
+			since = ack.tick
+			if ack.stageNum == frame.stageNum {
+				baseline, baselineTick = &ack.stage, ack.tick
+			}
+		}
+		var exps []sim.ExplosionState
+		for tick := since + 1; tick <= s.tick; tick++ {
+			exps = append(exps, s.history[tick%History].explosions...)
+		}
+		const maxExplosions = 255
+		if len(exps) > maxExplosions {
+			exps = exps[len(exps)-maxExplosions:]
+		}
+		state := s.world.EncodeState(baseline, &frame.stage, exps)
+		s.server.SendState(peer, s.tick, baselineTick, state)
+	}
+}
+
+// frame returns the remembered state with tick, false if it's too old
+func (s *Server) frame(tick uint32) (*frame, bool) {
+	frame := &s.history[tick%History]
+	if tick == 0 || frame.tick != tick {
+		return nil, false
+	}
+	return frame, true
+}
+
+// inputSource takes inputs of players from their clients
+type inputSource struct {
+	server *netplay.Server
+	last   []sim.Input
+}
+
+func newInputSource(server *netplay.Server, players int) *inputSource {
+	return &inputSource{server: server, last: make([]sim.Input, players)}
+}
+
+// NextInputs never waits for clients, a late input is replaced with the previous one
+func (i *inputSource) NextInputs() []sim.Input {
+	inputs := make([]sim.Input, len(i.last))
+	for player := range inputs {
+		input, ok := i.server.NextInput(player)
+		if ok {
+			i.last[player] = sim.Input(input)
+			inputs[player] = sim.Input(input)
+		} else {
+			inputs[player] = i.last[player] &^ sim.InputFire // fire is a single press
+		}
+	}
+	return inputs
+}
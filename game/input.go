@@ -0,0 +1,263 @@
+package game
+
+import (
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"github.com/faiface/pixel/pixelgl"
+	"math"
+)
+
+// Controller reads Input of a local player
+type Controller interface {
+	// Poll is called every frame
+	Poll(win *pixelgl.Window)
+	// Read is called once per tick, it returns Input polled last
+	Read() sim.Input
+}
+
+// localControllers returns controllers of n local players, see StateConfig.Controllers and StateConfig.Bindings
+func localControllers(config StateConfig, n int) []Controller {
+	if config.Controllers != nil {
+		return config.Controllers
+	}
+	bindings := config.Bindings
+	if bindings == nil {
+		bindings = DefaultBindings()
+	}
+	controllers := make([]Controller, n)
+	for i := range controllers {
+		controllers[i] = NewPlayerInput(bindings, i)
+	}
+	return controllers
+}
+
+// PlayerInput reads player's Input from keyboard and the gamepad assigned to the player.
+// Bindings are read on every poll, so rebinding applies immediately
+type PlayerInput struct {
+	bindings  *Bindings
+	index     int
+	input     sim.Input
+	fireLatch bool
+}
+
+func NewPlayerInput(bindings *Bindings, playerIndex int) *PlayerInput {
+	return &PlayerInput{bindings: bindings, index: playerIndex}
+}
+
+// Poll must be called every frame, so fire presses between ticks aren't lost
+func (p *PlayerInput) Poll(win *pixelgl.Window) {
+	b := &p.bindings.Players[p.index]
+	p.input = 0
+	for _, key := range []struct {
+		key   Key
+		input sim.Input
+	}{{b.Up, sim.InputUp}, {b.Right, sim.InputRight}, {b.Down, sim.InputDown}, {b.Left, sim.InputLeft}} {
+		if win.Pressed(pixelgl.Button(key.key)) {
+			p.input |= key.input
+		}
+	}
+	if win.JustPressed(pixelgl.Button(b.Fire)) {
+		p.fireLatch = true
+	}
+	if js, ok := b.joystick(); ok && win.JoystickPresent(js) { // an unplugged gamepad is just ignored
+		p.pollGamepad(win, js)
+	}
+}
+
+func (p *PlayerInput) pollGamepad(win *pixelgl.Window, js pixelgl.Joystick) {
+	x, y := win.JoystickAxis(js, pixelgl.AxisLeftX), win.JoystickAxis(js, pixelgl.AxisLeftY)
+	if math.Hypot(x, y) <= p.bindings.Deadzone {
+		x, y = 0, 0
+	}
+	// the stick points to one direction only, the dominant axis wins
+	horizontal := math.Abs(x) > math.Abs(y)
+	switch {
+	case win.JoystickPressed(js, pixelgl.ButtonDpadUp) || !horizontal && y < 0:
+		p.input |= sim.InputUp
+	case win.JoystickPressed(js, pixelgl.ButtonDpadDown) || !horizontal && y > 0:
+		p.input |= sim.InputDown
+	case win.JoystickPressed(js, pixelgl.ButtonDpadLeft) || horizontal && x < 0:
+		p.input |= sim.InputLeft
+	case win.JoystickPressed(js, pixelgl.ButtonDpadRight) || horizontal && x > 0:
+		p.input |= sim.InputRight
+	}
+	if win.JoystickJustPressed(js, pixelgl.ButtonA) || win.JoystickJustPressed(js, pixelgl.ButtonX) {
+		p.fireLatch = true
+	}
+}
+
+// Read returns polled Input and consumes fire press
+func (p *PlayerInput) Read() sim.Input {
+	input := p.input
+	if p.fireLatch {
+		input |= sim.InputFire
+		p.fireLatch = false
+	}
+	return input
+}
+
+// InputSource provides inputs of all players tick by tick
+type InputSource interface {
+	Poll(win *pixelgl.Window)
+	// NextInputs returns inputs of all players for the next tick or false if they aren't available yet
+	NextInputs() ([]sim.Input, bool)
+	// Ticked is called after the tick is simulated
+	Ticked(s *PlaygroundState) error
+	// LocalPlayers returns indexes of players controlled on this machine
+	LocalPlayers() []int
+}
+
+type localInputSource struct {
+	controllers []Controller
+}
+
+func newLocalInputSource(controllers []Controller) *localInputSource {
+	return &localInputSource{controllers: controllers}
+}
+
+func (l *localInputSource) Poll(win *pixelgl.Window) {
+	for _, c := range l.controllers {
+		c.Poll(win)
+	}
+}
+
+func (l *localInputSource) NextInputs() ([]sim.Input, bool) {
+	inputs := make([]sim.Input, len(l.controllers))
+	for i, c := range l.controllers {
+		inputs[i] = c.Read()
+	}
+	return inputs, true
+}
+
+func (l *localInputSource) Ticked(_ *PlaygroundState) error {
+	return nil
+}
+
+func (l *localInputSource) LocalPlayers() []int {
+	players := make([]int, len(l.controllers))
+	for i := range players {
+		players[i] = i
+	}
+	return players
+}
+
+// netInputSource exchanges inputs with the remote player, see netplay.Session
+type netInputSource struct {
+	session    *netplay.Session
+	controller Controller
+	sent       bool // local input for the current tick is sent
+}
+
+func newNetInputSource(session *netplay.Session, controller Controller) *netInputSource {
+	return &netInputSource{session: session, controller: controller}
+}
+
+func (n *netInputSource) Poll(win *pixelgl.Window) {
+	n.controller.Poll(win)
+}
+
+func (n *netInputSource) NextInputs() ([]sim.Input, bool) {
+	tick := n.session.Tick()
+	if !n.sent {
+		n.session.SetLocalInput(tick, byte(n.controller.Read()))
+		n.sent = true
+	}
+	remoteInputs, ok := n.session.Inputs(tick)
+	if !ok {
+		return nil, false
+	}
+	inputs := make([]sim.Input, len(remoteInputs))
+	for i, input := range remoteInputs {
+		inputs[i] = sim.Input(input)
+	}
+	return inputs, true
+}
+
+func (n *netInputSource) Ticked(s *PlaygroundState) error {
+	tick := n.session.Tick()
+	if tick%netplay.HashInterval == 0 {
+		n.session.SetLocalHash(tick, s.Hash())
+	}
+	n.session.NextTick()
+	n.sent = false
+	return n.session.Err()
+}
+
+func (n *netInputSource) LocalPlayers() []int {
+	return []int{n.session.LocalPlayer()}
+}
+
+// MenuAction is a navigation command in menus, it's read from keyboard and any gamepad
+type MenuAction int
+
+const (
+	MenuNone MenuAction = iota
+	MenuUp
+	MenuDown
+	MenuLeft
+	MenuRight
+	MenuSelect
+	MenuBack
+)
+
+// ReadMenuAction returns the action just pressed, MenuNone if there is none
+func ReadMenuAction(win *pixelgl.Window) MenuAction {
+	keys := []struct {
+		action MenuAction
+		keys   []pixelgl.Button
+	}{
+		{MenuUp, []pixelgl.Button{pixelgl.KeyW, pixelgl.KeyUp}},
+		{MenuDown, []pixelgl.Button{pixelgl.KeyS, pixelgl.KeyDown}},
+		{MenuLeft, []pixelgl.Button{pixelgl.KeyA, pixelgl.KeyLeft}},
+		{MenuRight, []pixelgl.Button{pixelgl.KeyD, pixelgl.KeyRight}},
+		{MenuSelect, []pixelgl.Button{pixelgl.KeyEnter, pixelgl.KeySpace}},
+		{MenuBack, []pixelgl.Button{pixelgl.KeyEscape}},
+	}
+	for _, k := range keys {
+		for _, key := range k.keys {
+			if win.JustPressed(key) {
+				return k.action
+			}
+		}
+	}
+	buttons := []struct {
+		action  MenuAction
+		buttons []pixelgl.GamepadButton
+	}{
+		{MenuUp, []pixelgl.GamepadButton{pixelgl.ButtonDpadUp}},
+		{MenuDown, []pixelgl.GamepadButton{pixelgl.ButtonDpadDown}},
+		{MenuLeft, []pixelgl.GamepadButton{pixelgl.ButtonDpadLeft}},
+		{MenuRight, []pixelgl.GamepadButton{pixelgl.ButtonDpadRight}},
+		{MenuSelect, []pixelgl.GamepadButton{pixelgl.ButtonA}},
+		{MenuBack, []pixelgl.GamepadButton{pixelgl.ButtonB, pixelgl.ButtonStart}},
+	}
+	for js := pixelgl.Joystick1; js <= pixelgl.JoystickLast; js++ {
+		if !win.JoystickPresent(js) {
+			continue
+		}
+		for _, b := range buttons {
+			for _, button := range b.buttons {
+				if win.JoystickJustPressed(js, button) {
+					return b.action
+				}
+			}
+		}
+	}
+	return MenuNone
+}
+
+// pausePressed reports whether a player asks to pause the game, bindings may be nil for defaults
+func pausePressed(win *pixelgl.Window, bindings *Bindings) bool {
+	if bindings == nil {
+		bindings = DefaultBindings()
+	}
+	if win.JustPressed(pixelgl.Button(bindings.Pause)) {
+		return true
+	}
+	for js := pixelgl.Joystick1; js <= pixelgl.JoystickLast; js++ {
+		if win.JoystickPresent(js) && win.JoystickJustPressed(js, pixelgl.ButtonStart) {
+			return true
+		}
+	}
+	return false
+}
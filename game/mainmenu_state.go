@@ -1,19 +1,125 @@
 package game
 
-import "github.com/faiface/pixel/pixelgl"
+import (
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"log"
+	"math"
+)
+
+type menuItem int
+
+const (
+	onePlayerItem menuItem = iota
+	twoPlayersItem
+	versusItem
+	hostGameItem
+	joinGameItem
+	continueItem
+	controlsItem
+)
+
+var menuItemTitles = []string{"1 PLAYER", "2 PLAYERS", "VERSUS", "HOST GAME", "JOIN GAME", "CONTINUE", "CONTROLS"}
 
 type MainMenuState struct {
-	config StateConfig
+	config     StateConfig
+	items      []menuItem // shown, continueItem only if there is a saved game
+	selected   int
+	titleTxt   *text.Text
+	itemsTxt   *text.Text
+	cursor     *pixel.Sprite
+	lineHeight float64
 }
 
 func NewMainMenuState(config StateConfig) *MainMenuState {
-	return &MainMenuState{config: config}
-}
+	s := new(MainMenuState)
+	s.config = config
+	atlas := text.NewAtlas(s.config.DefaultFont, text.ASCII)
+
+	// texts are laid out around the origin, they're moved to the center of the screen by Draw
+	s.titleTxt = text.New(pixel.V(0, 0), atlas)
+	s.titleTxt.Color = colornames.Firebrick
+	title := "BATTLE CITY"
+	r := s.titleTxt.BoundsOf(title)
+	s.titleTxt.Orig = pixel.V(-r.W()/2, r.H()*4)
+	_, _ = fmt.Fprintln(s.titleTxt, title)
 
-func (s *MainMenuState) Update(_ *pixelgl.Window, _ float64) State {
-	return NewStageTitleState(s.config, 1, nil)
+	s.itemsTxt = text.New(pixel.V(-r.W()/3, 0), atlas)
+	s.itemsTxt.Color = colornames.White
+	s.itemsTxt.LineHeight = atlas.LineHeight() * 1.5
+	s.lineHeight = s.itemsTxt.LineHeight
+	if HasSave(s.config) {
+		s.items = append(s.items, continueItem)
+	}
+	s.items = append(s.items, onePlayerItem, twoPlayersItem, versusItem, hostGameItem, joinGameItem, controlsItem)
+	for _, item := range s.items {
+		_, _ = fmt.Fprintln(s.itemsTxt, menuItemTitles[item])
+	}
+	s.cursor = s.config.Sprites.Sprite("cursor")
+	sfx.PlayMusic(sfx.MenuMusic)
+	return s
 }
 
-func (s *MainMenuState) Draw(_ *pixelgl.Window, _ float64) {
+func (s *MainMenuState) Update(win *pixelgl.Window, _ float64) State {
+	itemsCount := len(s.items)
+	switch ReadMenuAction(win) {
+	case MenuUp:
+		s.selected = (s.selected + itemsCount - 1) % itemsCount
+		return nil
+	case MenuDown:
+		s.selected = (s.selected + 1) % itemsCount
+		return nil
+	case MenuSelect:
+	default:
+		return nil
+	}
+	s.config.Match = nil
+	switch s.items[s.selected] {
+	case onePlayerItem:
+		s.config.Players = 1
+		return NewStageTitleState(s.config, s.config.firstStage(), nil)
+	case twoPlayersItem:
+		s.config.Players = 2
+		return NewStageTitleState(s.config, s.config.firstStage(), nil)
+	case versusItem:
+		s.config.Players = 2
+		match, err := sim.NewMatch(s.config.StagesConfigs)
+		if err != nil {
+			log.Printf("versus: %v", err)
+			return nil
+		}
+		s.config.Match = match
+		return NewStageTitleState(s.config, s.config.Match.Arena(), nil)
+	case hostGameItem:
+		return NewNetLobbyState(s.config, true)
+	case joinGameItem:
+		return NewNetLobbyState(s.config, false)
+	case controlsItem:
+		return NewBindingsState(s.config, s)
+	case continueItem:
+		playground, err := LoadFromSlot(s.config)
+		if err != nil {
+			log.Printf("continue: %v", err)
+			return nil
+		}
+		return playground
+	}
+	return nil
+}
 
+func (s *MainMenuState) Draw(canvas *pixelgl.Canvas, _ float64) {
+	canvas.Clear(colornames.Black)
+	center := canvas.Bounds().Center()
+	s.titleTxt.Draw(canvas, pixel.IM.Moved(center))
+	s.itemsTxt.Draw(canvas, pixel.IM.Moved(center))
+	cursorPos := s.itemsTxt.Orig.Add(pixel.V(
+		-sim.TankSize,
+		-float64(s.selected)*s.lineHeight+s.lineHeight/4,
+	))
+	s.cursor.Draw(canvas, pixel.IM.Rotated(pixel.ZV, -math.Pi/2).Moved(cursorPos.Add(center)))
 }
@@ -0,0 +1,121 @@
+package game
+
+import (
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"strings"
+)
+
+// NetLobbyState hosts a network game or joins one and waits until both players are connected
+type NetLobbyState struct {
+	config  StateConfig
+	isHost  bool
+	mode    netplay.Mode // chosen by host
+	addr    string
+	session *netplay.Session
+	err     error
+	atlas   *text.Atlas
+	txt     *text.Text
+}
+
+func NewNetLobbyState(config StateConfig, isHost bool) *NetLobbyState {
+	s := new(NetLobbyState)
+	s.config = config
+	s.isHost = isHost
+	s.addr = fmt.Sprintf("127.0.0.1:%d", netplay.DefaultPort)
+	s.atlas = text.NewAtlas(s.config.DefaultFont, text.ASCII)
+	s.txt = text.New(pixel.V(0, 0), s.atlas)
+	return s
+}
+
+func (s *NetLobbyState) Update(win *pixelgl.Window, _ float64) State {
+	if win.JustPressed(pixelgl.KeyEscape) {
+		if s.session != nil {
+			s.session.Close()
+		}
+		return NewMainMenuState(s.config)
+	}
+	if s.session == nil && s.err == nil && s.isHost { // netcode is being chosen
+		if win.JustPressed(pixelgl.KeyLeft) || win.JustPressed(pixelgl.KeyRight) ||
+			win.JustPressed(pixelgl.KeyA) || win.JustPressed(pixelgl.KeyD) {
+			s.mode = 1 - s.mode
+		}
+		if win.JustPressed(pixelgl.KeyEnter) {
+			inputDelay := netplay.DefaultInputDelay
+			if s.mode == netplay.Rollback {
+				inputDelay = netplay.DefaultRollbackInputDelay
+			}
+			s.session, s.err = netplay.Host(netplay.DefaultPort, s.config.Seed, inputDelay, s.mode)
+		}
+		return nil
+	}
+	if s.session == nil { // joining, address is being typed
+		if s.err != nil {
+			return nil
+		}
+		s.addr += strings.ToUpper(win.Typed())
+		if win.JustPressed(pixelgl.KeyBackspace) && len(s.addr) > 0 {
+			s.addr = s.addr[:len(s.addr)-1]
+		}
+		if win.JustPressed(pixelgl.KeyEnter) {
+			s.session, s.err = netplay.Join(strings.ToLower(s.addr))
+		}
+		return nil
+	}
+	if err := s.session.Err(); err != nil {
+		s.err = err
+		s.session.Close()
+		s.session = nil
+		return nil
+	}
+	if s.session.Ready() {
+		s.config.Session = s.session
+		s.config.Seed = s.session.Seed()
+		s.config.Players = netplay.Players
+		// peers don't exchange settings, but must simulate the same game
+		s.config.Difficulty = sim.NormalDifficulty
+		s.config.Rules = sim.Rules{}
+		return NewStageTitleState(s.config, 1, nil)
+	}
+	return nil
+}
+
+// readsKeys is true while the address is typed
+func (s *NetLobbyState) readsKeys() bool {
+	return s.session == nil && s.err == nil && !s.isHost
+}
+
+func (s *NetLobbyState) Draw(canvas *pixelgl.Canvas, _ float64) {
+	var lines []string
+	switch {
+	case s.err != nil:
+		lines = []string{"NETWORK ERROR", strings.ToUpper(s.err.Error())}
+	case s.isHost && s.session == nil:
+		lines = []string{"NETCODE", "< " + strings.ToUpper(s.mode.String()) + " >", "", "ENTER - HOST"}
+	case s.isHost:
+		lines = []string{"WAITING FOR PLAYER", fmt.Sprintf("PORT %d", netplay.DefaultPort)}
+	case s.session == nil:
+		lines = []string{"HOST ADDRESS", s.addr + "_"}
+	default:
+		lines = []string{"CONNECTING TO", s.addr}
+	}
+	lines = append(lines, "", "ESC - BACK")
+
+	canvas.Clear(colornames.Black)
+	s.txt.Clear()
+	s.txt.Color = colornames.White
+	s.txt.LineHeight = s.atlas.LineHeight() * 1.5
+	center := canvas.Bounds().Center()
+	s.txt.Orig = pixel.V(0, center.Y+s.txt.LineHeight*float64(len(lines))/2)
+	s.txt.Dot = s.txt.Orig
+	for _, line := range lines {
+		s.txt.Dot.X = center.X - s.txt.BoundsOf(line).W()/2
+		_, _ = fmt.Fprintln(s.txt, line)
+	}
+	s.txt.Draw(canvas, pixel.IM)
+}
@@ -0,0 +1,223 @@
+package netplay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is a player or a spectator connected to a Server
+type Client struct {
+	conn     *net.UDPConn
+	server   *net.UDPAddr
+	spectate bool
+	mu       sync.Mutex
+	ready    bool
+	player   int
+	players  int
+	inputs   []byte // not acknowledged by the server yet
+	firstSeq uint32 // sequence number of inputs[0]
+	state    State
+	hasState bool
+	stateAck uint32 // the latest state received
+	err      error
+	done     chan struct{}
+}
+
+// State is a game state received from the server
+type State struct {
+	Tick     uint32
+	Baseline uint32 // tick of the state Payload is a delta to, 0 for full states
+	Payload  []byte
+}
+
+// Connect joins the server at addr (host:port) as a player if there is a free slot or as a spectator
+func Connect(addr string, spectate bool) (*Client, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, server: server, spectate: spectate, player: -1, done: make(chan struct{})}
+	go c.receive()
+	go c.resend()
+	return c, nil
+}
+
+// Ready reports whether the server accepted the client
+func (c *Client) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+// Player returns index of the controlled player, -1 for spectators
+func (c *Client) Player() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.player
+}
+
+// Players returns number of players in the game
+func (c *Client) Players() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.players
+}
+
+// SendInput sends input for the next tick, it's ignored for spectators
+func (c *Client) SendInput(input byte) {
+	c.mu.Lock()
+	if c.player < 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.inputs = append(c.inputs, input)
+	if n := len(c.inputs); n > maxInputsPerPacket { // the server lags too much, it won't need them anyway
+		c.firstSeq += uint32(n - maxInputsPerPacket)
+		c.inputs = c.inputs[n-maxInputsPerPacket:]
+	}
+	c.mu.Unlock()
+	c.sendInputs()
+}
+
+// State returns the latest received state, it's returned only once
+func (c *Client) State() (State, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.state, c.hasState
+	c.hasState = false
+	return state, ok
+}
+
+// AckState tells the server that the state is applied, so next ones can be deltas to it
+func (c *Client) AckState(tick uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tick > c.stateAck {
+		c.stateAck = tick
+	}
+}
+
+// Err returns the reason the connection is broken
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+		_ = c.conn.Close()
+	}
+}
+
+func (c *Client) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.fail(ErrTimeout)
+				return
+			}
+			c.fail(fmt.Errorf("netplay: %w", err))
+			return
+		}
+		if addr.String() != c.server.String() {
+			continue
+		}
+		p, err := unmarshal(buf[:n])
+		if errors.Is(err, ErrVersion) {
+			c.fail(err)
+			return
+		}
+		if err != nil {
+			continue
+		}
+		c.handle(p)
+	}
+}
+
+func (c *Client) handle(p *packet) {
+	switch p.kind {
+	case acceptPacket:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.ready {
+			return
+		}
+		c.ready = true
+		c.player = int(p.player)
+		c.players = int(p.players)
+	case statePacket:
+		payload, err := decompress(p.payload)
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.ready || p.tick <= c.state.Tick { // reordered
+			return
+		}
+		for p.ack > c.firstSeq && len(c.inputs) > 0 {
+			c.inputs = c.inputs[1:]
+			c.firstSeq++
+		}
+		c.state = State{Tick: p.tick, Baseline: p.baseline, Payload: payload}
+		c.hasState = true
+	}
+}
+
+// resend repeats connect requests and unacknowledged inputs, the latter also keep the connection alive
+func (c *Client) resend() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if c.Ready() {
+			c.sendInputs()
+		} else {
+			c.send(&packet{kind: connectPacket, spectate: c.spectate})
+		}
+	}
+}
+
+func (c *Client) sendInputs() {
+	c.mu.Lock()
+	p := &packet{kind: clientInputsPacket, ack: c.stateAck, first: c.firstSeq}
+	p.inputs = append(p.inputs, c.inputs...)
+	c.mu.Unlock()
+	c.send(p)
+}
+
+func (c *Client) send(p *packet) {
+	_, _ = c.conn.WriteToUDP(p.marshal(), c.server)
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
@@ -0,0 +1,50 @@
+package netplay
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conditions emulate a bad network for testing, they are applied to packets sent by a session
+type Conditions struct {
+	Latency time.Duration // one way delay
+	Jitter  time.Duration // random extra delay in [0, Jitter), packets may be reordered
+	Loss    float64       // probability of a packet being dropped
+}
+
+// SetConditions makes the session emulate network conditions, zero Conditions turn emulation off
+func (s *Session) SetConditions(c Conditions) {
+	var cond *conditioner
+	if c != (Conditions{}) {
+		cond = &conditioner{Conditions: c, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	}
+	s.conditions.Store(cond)
+}
+
+type conditioner struct {
+	Conditions
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (c *conditioner) send(conn *net.UDPConn, data []byte, addr *net.UDPAddr) {
+	if c == nil {
+		_, _ = conn.WriteToUDP(data, addr)
+		return
+	}
+	c.mu.Lock()
+	lost := c.rnd.Float64() < c.Loss
+	delay := c.Latency
+	if c.Jitter > 0 {
+		delay += time.Duration(c.rnd.Int63n(int64(c.Jitter)))
+	}
+	c.mu.Unlock()
+	if lost {
+		return
+	}
+	time.AfterFunc(delay, func() {
+		_, _ = conn.WriteToUDP(data, addr)
+	})
+}
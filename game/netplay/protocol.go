@@ -0,0 +1,170 @@
+package netplay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ProtocolVersion must be increased on every incompatible change of the protocol or game simulation
+const ProtocolVersion = 6
+
+const magic = "BCNP"
+
+var (
+	ErrVersion     = errors.New("netplay: protocol version mismatch")
+	errBadPacket   = errors.New("netplay: malformed packet")
+	errUnknownKind = errors.New("netplay: unknown packet kind")
+)
+
+type packetKind uint8
+
+const (
+	helloPacket        packetKind = iota + 1 // join request
+	welcomePacket                            // host accepts join, carries session parameters
+	inputsPacket                             // unacknowledged inputs and the latest state hash of the sender
+	connectPacket                            // client asks the server to join as a player or a spectator
+	acceptPacket                             // server accepts a client
+	clientInputsPacket                       // unacknowledged inputs of a client, also keeps the connection alive
+	statePacket                              // game state sent by the server
+)
+
+// packet layout: magic, version, kind, payload. All numbers are big endian
+type packet struct {
+	kind packetKind
+	// welcome
+	seed       int64
+	inputDelay uint8
+	mode       Mode
+	// inputs
+	ack    uint32 // sender has all receiver's inputs for ticks < ack
+	first  uint32 // tick of inputs[0]
+	inputs []byte
+	// hash of the state at tick for desync detection, it's sent with inputs
+	hashed bool
+	tick   uint32
+	hash   uint64
+	// connect, accept
+	spectate bool
+	player   int8 // -1 for spectators
+	players  uint8
+	// state, uses tick and ack (next expected client's input)
+	baseline uint32 // tick of the state the payload is a delta to, 0 for full states
+	payload  []byte
+}
+
+func (p *packet) marshal() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(magic)
+	buf.WriteByte(ProtocolVersion)
+	buf.WriteByte(byte(p.kind))
+	switch p.kind {
+	case welcomePacket:
+		_ = binary.Write(buf, binary.BigEndian, p.seed)
+		buf.WriteByte(p.inputDelay)
+		buf.WriteByte(byte(p.mode))
+	case inputsPacket:
+		_ = binary.Write(buf, binary.BigEndian, p.ack)
+		_ = binary.Write(buf, binary.BigEndian, p.first)
+		buf.WriteByte(uint8(len(p.inputs)))
+		buf.Write(p.inputs)
+		_ = binary.Write(buf, binary.BigEndian, p.hashed)
+		_ = binary.Write(buf, binary.BigEndian, p.tick)
+		_ = binary.Write(buf, binary.BigEndian, p.hash)
+	case connectPacket:
+		_ = binary.Write(buf, binary.BigEndian, p.spectate)
+	case acceptPacket:
+		_ = binary.Write(buf, binary.BigEndian, p.player)
+		buf.WriteByte(p.players)
+	case clientInputsPacket:
+		_ = binary.Write(buf, binary.BigEndian, p.ack)
+		_ = binary.Write(buf, binary.BigEndian, p.first)
+		buf.WriteByte(uint8(len(p.inputs)))
+		buf.Write(p.inputs)
+	case statePacket:
+		_ = binary.Write(buf, binary.BigEndian, p.tick)
+		_ = binary.Write(buf, binary.BigEndian, p.baseline)
+		_ = binary.Write(buf, binary.BigEndian, p.ack)
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(p.payload)))
+		buf.Write(p.payload)
+	}
+	return buf.Bytes()
+}
+
+func unmarshal(data []byte) (*packet, error) {
+	r := bytes.NewReader(data)
+	header := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, errBadPacket
+	}
+	if header[len(magic)] != ProtocolVersion {
+		return nil, ErrVersion
+	}
+	p := &packet{kind: packetKind(header[len(magic)+1])}
+	var err error
+	switch p.kind {
+	case helloPacket:
+	case welcomePacket:
+		err = binary.Read(r, binary.BigEndian, &p.seed)
+		if err == nil {
+			p.inputDelay, err = r.ReadByte()
+		}
+		var mode byte
+		if err == nil {
+			mode, err = r.ReadByte()
+		}
+		p.mode = Mode(mode)
+	case inputsPacket, clientInputsPacket:
+		var n uint8
+		err = binary.Read(r, binary.BigEndian, &p.ack)
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &p.first)
+		}
+		if err == nil {
+			n, err = r.ReadByte()
+		}
+		if err == nil {
+			p.inputs = make([]byte, n)
+			_, err = io.ReadFull(r, p.inputs)
+		}
+		if err == nil && p.kind == inputsPacket {
+			err = binary.Read(r, binary.BigEndian, &p.hashed)
+			if err == nil {
+				err = binary.Read(r, binary.BigEndian, &p.tick)
+			}
+			if err == nil {
+				err = binary.Read(r, binary.BigEndian, &p.hash)
+			}
+		}
+	case connectPacket:
+		err = binary.Read(r, binary.BigEndian, &p.spectate)
+	case acceptPacket:
+		err = binary.Read(r, binary.BigEndian, &p.player)
+		if err == nil {
+			p.players, err = r.ReadByte()
+		}
+	case statePacket:
+		var n uint16
+		err = binary.Read(r, binary.BigEndian, &p.tick)
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &p.baseline)
+		}
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &p.ack)
+		}
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &n)
+		}
+		if err == nil {
+			p.payload = make([]byte, n)
+			_, err = io.ReadFull(r, p.payload)
+		}
+	default:
+		return nil, errUnknownKind
+	}
+	if err != nil {
+		return nil, errBadPacket
+	}
+	return p, nil
+}
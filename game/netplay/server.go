@@ -0,0 +1,230 @@
+package netplay
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxPacketSize   = 4096
+	maxQueuedInputs = 8        // inputs waiting to be simulated, the older ones are dropped if a client sends too many
+	maxStateSize    = 64 << 10 // decompressed, much more than any state takes, so a larger one is malformed
+)
+
+// Server accepts players and spectators of an authoritative game.
+// Players send inputs, the server sends them game states, see Client
+type Server struct {
+	conn    *net.UDPConn
+	players int
+	mu      sync.Mutex
+	peers   map[string]*peer
+	done    chan struct{}
+}
+
+// Peer is a client connected to the server
+type Peer struct {
+	addr     *net.UDPAddr
+	Player   int    // -1 for spectators
+	StateAck uint32 // the latest state received by the client, 0 if none
+}
+
+type peer struct {
+	Peer
+	lastSeen  time.Time
+	inputs    []byte // received, but not simulated yet
+	nextInput uint32 // sequence number of the next expected input
+}
+
+// Listen starts the server on port, the first players clients which don't ask to spectate become players
+func Listen(port, players int) (*Server, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		conn:    conn,
+		players: players,
+		peers:   make(map[string]*peer),
+		done:    make(chan struct{}),
+	}
+	go s.receive()
+	go s.dropSilentPeers()
+	return s, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Peers returns connected clients ordered by player index, spectators go last
+func (s *Server) Peers() []Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p.Peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if (peers[i].Player < 0) != (peers[j].Player < 0) {
+			return peers[i].Player >= 0
+		}
+		if peers[i].Player != peers[j].Player {
+			return peers[i].Player < peers[j].Player
+		}
+		return peers[i].addr.String() < peers[j].addr.String()
+	})
+	return peers
+}
+
+// NextInput returns the next input of player, false if the player isn't connected or the input is late
+func (s *Server) NextInput(player int) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.Player != player || len(p.inputs) == 0 {
+			continue
+		}
+		input := p.inputs[0]
+		p.inputs = p.inputs[1:]
+		return input, true
+	}
+	return 0, false
+}
+
+// SendState sends the compressed game state to peer, baseline is the tick of the state it's a delta to or 0
+func (s *Server) SendState(peer Peer, tick, baseline uint32, state []byte) {
+	s.mu.Lock()
+	p, ok := s.peers[peer.addr.String()]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	packet := &packet{kind: statePacket, tick: tick, baseline: baseline, ack: p.nextInput, payload: compress(state)}
+	s.mu.Unlock()
+	_, _ = s.conn.WriteToUDP(packet.marshal(), peer.addr)
+}
+
+func (s *Server) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+		_ = s.conn.Close()
+	}
+}
+
+func (s *Server) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+				continue
+			}
+		}
+		p, err := unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.handle(p, addr)
+	}
+}
+
+func (s *Server) handle(p *packet, addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.peers[addr.String()]
+	switch p.kind {
+	case connectPacket:
+		if !ok {
+			client = &peer{Peer: Peer{addr: addr, Player: -1}}
+			if !p.spectate {
+				client.Player = s.freePlayer()
+			}
+			s.peers[addr.String()] = client
+		}
+		client.lastSeen = time.Now()
+		accept := &packet{kind: acceptPacket, player: int8(client.Player), players: uint8(s.players)}
+		_, _ = s.conn.WriteToUDP(accept.marshal(), addr)
+	case clientInputsPacket:
+		if !ok {
+			return
+		}
+		client.lastSeen = time.Now()
+		if p.ack > client.StateAck {
+			client.StateAck = p.ack
+		}
+		if p.first > client.nextInput { // inputs were dropped by the client
+			client.nextInput = p.first
+		}
+		for i, input := range p.inputs {
+			if p.first+uint32(i) != client.nextInput {
+				continue
+			}
+			client.inputs = append(client.inputs, input)
+			client.nextInput++
+		}
+		if n := len(client.inputs); n > maxQueuedInputs {
+			client.inputs = client.inputs[n-maxQueuedInputs:]
+		}
+	}
+}
+
+// freePlayer returns the lowest player index not taken by peers, -1 if all are taken.
+// Must be called with the lock held
+func (s *Server) freePlayer() int {
+	for player := 0; player < s.players; player++ {
+		taken := false
+		for _, p := range s.peers {
+			taken = taken || p.Player == player
+		}
+		if !taken {
+			return player
+		}
+	}
+	return -1
+}
+
+// dropSilentPeers disconnects clients which haven't sent anything for a while, so their player slots are freed
+func (s *Server) dropSilentPeers() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		for addr, p := range s.peers {
+			if time.Since(p.lastSeen) > timeout {
+				delete(s.peers, addr)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func compress(data []byte) []byte {
+	buf := new(bytes.Buffer)
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func decompress(data []byte) ([]byte, error) {
+	payload, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), maxStateSize+1))
+	if err == nil && len(payload) > maxStateSize {
+		err = errBadPacket
+	}
+	return payload, err
+}
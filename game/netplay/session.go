@@ -0,0 +1,438 @@
+// Package netplay implements two players sessions and an authoritative server over UDP.
+// Peers exchange only inputs. In Lockstep mode every tick is simulated when inputs of both players for it are known,
+// local inputs are delayed by a few ticks to hide the latency.
+// In Rollback mode the game predicts remote inputs and corrects the simulation when they arrive,
+// so a smaller delay is enough.
+// Server and Client are the authoritative alternative: clients send inputs, the server simulates the game
+// and sends them its state, clients may also only spectate.
+package netplay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultPort               = 7777
+	DefaultInputDelay         = 3
+	DefaultRollbackInputDelay = 1
+	Players                   = 2
+	HashInterval              = 60 // ticks between desync checks
+)
+
+// Mode is the way the game deals with latency, it's chosen by the host
+type Mode uint8
+
+const (
+	Lockstep Mode = iota
+	Rollback
+)
+
+func (m Mode) String() string {
+	if m == Rollback {
+		return "rollback"
+	}
+	return "lockstep"
+}
+
+const (
+	timeout            = time.Second * 10
+	resendInterval     = time.Millisecond * 15
+	maxInputsPerPacket = 64
+)
+
+var (
+	ErrDesync  = errors.New("netplay: desync detected")
+	ErrTimeout = errors.New("netplay: connection timed out")
+)
+
+type Session struct {
+	conn         *net.UDPConn
+	isHost       bool
+	mu           sync.Mutex
+	remote       *net.UDPAddr
+	ready        bool
+	seed         int64
+	inputDelay   uint32
+	mode         Mode
+	tick         uint32
+	localInputs  map[uint32]byte
+	localNext    uint32 // the next tick local input will be scheduled for
+	remoteInputs map[uint32]byte
+	remoteNext   uint32 // all remote inputs for ticks < remoteNext are received
+	remoteRead   uint32 // remote inputs for ticks < remoteRead are returned by RemoteInputs
+	remoteAck    uint32 // peer has all local inputs for ticks < remoteAck
+	localHashes  map[uint32]uint64
+	remoteHashes map[uint32]uint64
+	hashed       bool   // hashTick and hash are set
+	hashTick     uint32 // tick of the latest local hash, it's sent with inputs
+	hash         uint64
+	hashesDone   uint32 // hashes for ticks < hashesDone are compared or will never be
+	err          error
+	done         chan struct{}
+	conditions   atomic.Value // *conditioner, see SetConditions
+}
+
+// Host listens on port and waits for a player to join
+func Host(port int, seed int64, inputDelay int, mode Mode) (*Session, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(conn, true)
+	s.seed = seed
+	s.inputDelay = uint32(inputDelay)
+	s.mode = mode
+	s.remoteNext = s.inputDelay
+	s.start()
+	return s, nil
+}
+
+// Join connects to the host at addr (host:port)
+func Join(addr string) (*Session, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(conn, false)
+	s.remote = remote
+	s.start()
+	return s, nil
+}
+
+func newSession(conn *net.UDPConn, isHost bool) *Session {
+	return &Session{
+		conn:         conn,
+		isHost:       isHost,
+		localInputs:  make(map[uint32]byte),
+		remoteInputs: make(map[uint32]byte),
+		localHashes:  make(map[uint32]uint64),
+		remoteHashes: make(map[uint32]uint64),
+		done:         make(chan struct{}),
+	}
+}
+
+func (s *Session) start() {
+	go s.receive()
+	go s.resend()
+}
+
+// Ready reports whether both peers are connected
+func (s *Session) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *Session) Seed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seed
+}
+
+func (s *Session) Mode() Mode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mode
+}
+
+// LocalPlayer returns index of the player controlled by this peer
+func (s *Session) LocalPlayer() int {
+	if s.isHost {
+		return 0
+	}
+	return 1
+}
+
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Tick returns the next tick to simulate
+func (s *Session) Tick() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tick
+}
+
+// NextTick must be called after the tick is simulated
+func (s *Session) NextTick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mode == Lockstep {
+		delete(s.remoteInputs, s.tick)
+	} else {
+		for tick := range s.remoteInputs {
+			if tick < s.tick && tick < s.remoteRead {
+				delete(s.remoteInputs, tick)
+			}
+		}
+	}
+	for tick := range s.localInputs {
+		if tick < s.tick && tick < s.remoteAck {
+			delete(s.localInputs, tick)
+		}
+	}
+	s.tick++
+}
+
+// SetLocalInput schedules local input sampled at tick. An input scheduled already isn't changed, the peer may have it
+func (s *Session) SetLocalInput(tick uint32, input byte) {
+	s.mu.Lock()
+	if tick+s.inputDelay < s.localNext {
+		s.mu.Unlock()
+		return
+	}
+	s.localInputs[tick+s.inputDelay] = input
+	s.localNext = tick + s.inputDelay + 1
+	s.mu.Unlock()
+	s.sendInputs()
+}
+
+// Inputs returns inputs of all players for tick ordered by player index, false if they are not known yet
+func (s *Session) Inputs(tick uint32) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inputs := make([]byte, Players)
+	if tick < s.inputDelay {
+		return inputs, true
+	}
+	local, ok := s.localInputs[tick]
+	if !ok {
+		return nil, false
+	}
+	remote, ok := s.remoteInputs[tick]
+	if !ok {
+		return nil, false
+	}
+	inputs[s.LocalPlayer()] = local
+	inputs[1-s.LocalPlayer()] = remote
+	return inputs, true
+}
+
+// LocalInput returns local input scheduled for tick, false if it's not set yet
+func (s *Session) LocalInput(tick uint32) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tick < s.inputDelay {
+		return 0, true
+	}
+	input, ok := s.localInputs[tick]
+	return input, ok
+}
+
+// RemoteInputs returns known remote inputs starting from tick from, it's used in Rollback mode instead of Inputs.
+// Inputs for ticks before the current one are kept until they are returned
+func (s *Session) RemoteInputs(from uint32) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inputs []byte
+	for tick := from; tick < s.remoteNext; tick++ {
+		inputs = append(inputs, s.remoteInputs[tick]) // inputs for ticks < inputDelay are zero
+	}
+	if s.remoteNext > s.remoteRead {
+		s.remoteRead = s.remoteNext
+	}
+	return inputs
+}
+
+// SetLocalHash sets hash of the game state at tick, it's compared with the remote one.
+// Hashes must be set in order of ticks, the peer gets the latest one with inputs
+func (s *Session) SetLocalHash(tick uint32, hash uint64) {
+	s.mu.Lock()
+	s.localHashes[tick] = hash
+	s.hashed, s.hashTick, s.hash = true, tick, hash
+	s.compareHashes(tick)
+	s.mu.Unlock()
+	s.sendInputs()
+}
+
+// Err returns the reason the session is broken, e.g. ErrDesync
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+		_ = s.conn.Close()
+	}
+}
+
+func (s *Session) receive() {
+	buf := make([]byte, 1024)
+	for {
+		s.mu.Lock()
+		if s.ready || !s.isHost { // host waits for a player as long as needed
+			_ = s.conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		s.mu.Unlock()
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.fail(ErrTimeout)
+				return
+			}
+			s.fail(fmt.Errorf("netplay: %w", err))
+			return
+		}
+		p, err := unmarshal(buf[:n])
+		if errors.Is(err, ErrVersion) && !s.isHost {
+			s.fail(err)
+			return
+		}
+		if err != nil {
+			continue
+		}
+		s.handle(p, addr)
+	}
+}
+
+func (s *Session) handle(p *packet, addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready && addr.String() != s.remote.String() { // stranger
+		return
+	}
+	switch p.kind {
+	case helloPacket:
+		if !s.isHost {
+			return
+		}
+		s.remote = addr
+		s.ready = true
+		welcome := &packet{kind: welcomePacket, seed: s.seed, inputDelay: uint8(s.inputDelay), mode: s.mode}
+		s.send(addr, welcome)
+	case welcomePacket:
+		if s.isHost || s.ready {
+			return
+		}
+		s.seed = p.seed
+		s.inputDelay = uint32(p.inputDelay)
+		s.mode = p.mode
+		s.remoteNext = s.inputDelay
+		s.ready = true
+	case inputsPacket:
+		if p.ack > s.remoteAck {
+			s.remoteAck = p.ack
+		}
+		for i, input := range p.inputs {
+			tick := p.first + uint32(i)
+			if tick >= s.remoteNext {
+				s.remoteInputs[tick] = input
+			}
+		}
+		for {
+			if _, ok := s.remoteInputs[s.remoteNext]; !ok {
+				break
+			}
+			s.remoteNext++
+		}
+		if p.hashed && p.tick >= s.hashesDone {
+			s.remoteHashes[p.tick] = p.hash
+			s.compareHashes(p.tick)
+		}
+	}
+}
+
+// resend repeats join requests and unacknowledged inputs, so lost packets don't stall the game
+func (s *Session) resend() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		ready, remote := s.ready, s.remote
+		s.mu.Unlock()
+		if !ready && !s.isHost {
+			s.send(remote, &packet{kind: helloPacket})
+		} else if ready {
+			s.sendInputs()
+		}
+	}
+}
+
+func (s *Session) sendInputs() {
+	s.mu.Lock()
+	first := s.remoteAck
+	if first < s.inputDelay {
+		first = s.inputDelay
+	}
+	p := &packet{kind: inputsPacket, ack: s.remoteNext, first: first}
+	for tick := first; tick < s.localNext && len(p.inputs) < maxInputsPerPacket; tick++ {
+		p.inputs = append(p.inputs, s.localInputs[tick])
+	}
+	p.hashed, p.tick, p.hash = s.hashed, s.hashTick, s.hash
+	remote := s.remote
+	s.mu.Unlock()
+	s.send(remote, p)
+}
+
+func (s *Session) send(addr *net.UDPAddr, p *packet) {
+	if addr == nil {
+		return
+	}
+	data := p.marshal()
+	if conditions, ok := s.conditions.Load().(*conditioner); ok {
+		conditions.send(s.conn, data, addr)
+		return
+	}
+	_, _ = s.conn.WriteToUDP(data, addr)
+}
+
+// compareHashes compares hashes for tick if both are known, it must be called with the lock held.
+// Older hashes are deleted then, peers send only their latest hashes, so the missing ones never come
+func (s *Session) compareHashes(tick uint32) {
+	local, ok := s.localHashes[tick]
+	if !ok {
+		return
+	}
+	remote, ok := s.remoteHashes[tick]
+	if !ok {
+		return
+	}
+	if local != remote {
+		s.err = fmt.Errorf("%w at tick %d", ErrDesync, tick)
+	}
+	s.hashesDone = tick + 1
+	for t := range s.localHashes {
+		if t < s.hashesDone {
+			delete(s.localHashes, t)
+		}
+	}
+	for t := range s.remoteHashes {
+		if t < s.hashesDone {
+			delete(s.remoteHashes, t)
+		}
+	}
+}
+
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
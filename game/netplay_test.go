@@ -0,0 +1,218 @@
+package game
+
+import (
+	"battlecity/assets"
+	"battlecity/game/atlas"
+	"battlecity/game/explosions"
+	"battlecity/game/netplay"
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"golang.org/x/image/font/basicfont"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testSprites struct {
+	once    sync.Once
+	sprites *atlas.Atlas
+	err     error
+}
+
+// testConfig returns the config of a silent game without a window
+func testConfig(t *testing.T) StateConfig {
+	t.Helper()
+	testSprites.once.Do(func() {
+		if testSprites.err = sfx.Init(assets.Sfx, new(sfx.NullSink)); testSprites.err != nil {
+			return
+		}
+		// nothing is drawn, but sprites need a picture
+		testSprites.sprites, testSprites.err = atlas.Load(pixel.MakePictureData(pixel.R(0, 0, 400, 256)), assets.Atlas)
+		if testSprites.err == nil {
+			explosions.InnitExplosionFrames(testSprites.sprites)
+		}
+	})
+	if testSprites.err != nil {
+		t.Fatal(testSprites.err)
+	}
+	return StateConfig{
+		Sprites:       testSprites.sprites,
+		DefaultFont:   basicfont.Face7x13,
+		StagesConfigs: assets.Stages,
+		ScreenBounds:  ScreenBounds,
+		Players:       1,
+	}
+}
+
+// scriptedController changes its input on every read, so an input read twice for a tick is noticed
+type scriptedController struct {
+	reads int
+}
+
+func (c *scriptedController) Poll(_ *pixelgl.Window) {}
+
+func (c *scriptedController) Read() sim.Input {
+	c.reads++
+	input := []sim.Input{sim.InputUp, sim.InputRight, sim.InputDown, sim.InputLeft, 0}[c.reads/7%5]
+	if c.reads%3 == 0 {
+		input |= sim.InputFire
+	}
+	return input
+}
+
+// testPeer is a player of a network game, see TestNetplayPeersStayInSync
+type testPeer struct {
+	session *netplay.Session
+	state   State
+	stages  int                    // number of started stages
+	next    uint32                 // the next tick to record
+	inputs  map[uint32][]sim.Input // of all players by tick
+	hashes  map[uint32]uint64      // by tick, see record
+}
+
+func TestNetplayPeersStayInSync(t *testing.T) {
+	for _, mode := range []netplay.Mode{netplay.Lockstep, netplay.Rollback} {
+		t.Run(mode.String(), func(t *testing.T) {
+			testPeersStayInSync(t, mode)
+		})
+	}
+}
+
+// testPeersStayInSync plays the first stage to its end and the second one for a while over loopback.
+// Peers must simulate every tick with the same inputs and get the same hashes
+func testPeersStayInSync(t *testing.T, mode netplay.Mode) {
+	config := testConfig(t)
+	inputDelay := netplay.DefaultInputDelay
+	if mode == netplay.Rollback {
+		inputDelay = netplay.DefaultRollbackInputDelay
+	}
+	host, err := netplay.Host(0, 1, inputDelay, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	client, err := netplay.Join(fmt.Sprintf("127.0.0.1:%d", host.Addr().(*net.UDPAddr).Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	deadline := time.Now().Add(time.Second * 5)
+	for !host.Ready() || !client.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("peers didn't connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	peers := []*testPeer{{session: host}, {session: client}}
+	for _, peer := range peers {
+		config := config
+		config.Session = peer.session
+		config.Seed = peer.session.Seed()
+		config.Players = netplay.Players
+		config.Controllers = []Controller{new(scriptedController)}
+		playground := NewPlaygroundState(config, 1, nil)
+		playground.world.Stage().EmptyBotsPool() // the stage is cleared at once and the next one starts soon
+		peer.state, peer.stages = playground, 1
+		peer.inputs, peer.hashes = make(map[uint32][]sim.Input), make(map[uint32]uint64)
+	}
+
+	// the first stage is over at tick 180, 3 seconds after it's cleared
+	const ticks = 180 + 4*netplay.HashInterval
+	deadline = time.Now().Add(time.Second * 20)
+	for peers[0].next < ticks || peers[1].next < ticks {
+		if time.Now().After(deadline) {
+			t.Fatalf("peers are stuck at ticks %d and %d", peers[0].session.Tick(), peers[1].session.Tick())
+		}
+		for _, peer := range peers {
+			if peer.next < ticks {
+				peer.step(t)
+			}
+		}
+		time.Sleep(time.Millisecond / 10)
+	}
+
+	for i, peer := range peers {
+		if err := peer.session.Err(); err != nil {
+			t.Fatalf("peer %d: %v", i, err)
+		}
+		if peer.stages != 2 {
+			t.Fatalf("peer %d played %d stages, want 2", i, peer.stages)
+		}
+	}
+	hashes := 0
+	for tick := uint32(0); tick < ticks; tick++ {
+		if fmt.Sprint(peers[0].inputs[tick]) != fmt.Sprint(peers[1].inputs[tick]) {
+			t.Fatalf("tick %d is simulated with inputs %v and %v", tick, peers[0].inputs[tick], peers[1].inputs[tick])
+		}
+		hash, ok := peers[0].hashes[tick]
+		if !ok {
+			continue
+		}
+		if hash != peers[1].hashes[tick] {
+			t.Fatalf("hashes differ at tick %d", tick)
+		}
+		hashes++
+	}
+	if hashes < 4 {
+		t.Fatalf("only %d hashes are compared", hashes)
+	}
+}
+
+// step simulates at most one tick, the stage title is skipped
+func (p *testPeer) step(t *testing.T) {
+	t.Helper()
+	playground, _ := p.state.(*PlaygroundState)
+	dt := TickDt
+	if playground != nil && playground.tickAccumulator >= TickDt {
+		dt = 0 // still waiting for the previous tick
+	}
+	next := p.state.(HeadlessState).Step(dt)
+	if playground != nil {
+		p.record(t, playground)
+	}
+	switch state := next.(type) {
+	case nil:
+		return
+	case *StageTitleState:
+		state.stateStartTime = time.Now().Add(-time.Minute)
+	case *PlaygroundState:
+		p.stages++
+	default:
+		if err := p.session.Err(); err != nil {
+			t.Fatalf("session: %v", err)
+		}
+		t.Fatalf("unexpected state %T", next)
+	}
+	p.state = next
+}
+
+// record remembers inputs and hashes of ticks which won't be simulated again.
+// In lockstep hashes are of states after ticks, in rollback of states before ticks which are multiples of
+// netplay.HashInterval
+func (p *testPeer) record(t *testing.T, playground *PlaygroundState) {
+	t.Helper()
+	r, ok := playground.inputs.(*rollbackInputSource)
+	if !ok {
+		if p.session.Tick() > p.next { // the tick is simulated, lockstep never predicts
+			p.inputs[p.next] = append([]sim.Input(nil), playground.world.Players()[0].Input(), playground.world.Players()[1].Input())
+			p.hashes[p.next] = playground.Hash()
+			p.next++
+		}
+		return
+	}
+	for ; p.next < r.confirmed && p.next < p.session.Tick(); p.next++ {
+		if p.next+uint32(len(r.frames)) < p.session.Tick() {
+			t.Fatalf("tick %d isn't remembered anymore", p.next)
+		}
+		frame := r.frame(p.next)
+		p.inputs[p.next] = append([]sim.Input(nil), frame.inputs...)
+		if p.next%netplay.HashInterval == 0 {
+			p.hashes[p.next] = frame.hash
+		}
+	}
+}
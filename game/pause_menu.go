@@ -0,0 +1,215 @@
+package game
+
+import (
+	"battlecity/game/sfx"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"log"
+	"math"
+)
+
+type pauseItem int
+
+const (
+	resumeItem pauseItem = iota
+	restartItem
+	optionsItem
+	saveItem
+	quitItem
+	volumeItem
+	musicVolumeItem
+	sfxVolumeItem
+	bindingsItem
+	scaleItem
+	backItem
+)
+
+var pauseItemTitles = []string{
+	"RESUME", "RESTART STAGE", "OPTIONS", "SAVE", "QUIT", "VOLUME", "MUSIC", "SFX", "KEY BINDINGS", "WINDOW", "BACK",
+}
+
+// pausePage is a page of PauseMenu
+type pausePage int
+
+const (
+	pauseMainPage pausePage = iota
+	pauseOptionsPage
+)
+
+// windowScales are window sizes offered by the options, multiples of StateConfig.ScreenBounds
+var windowScales = []float64{2, 3, 4, 5, 6}
+
+const volumeSteps = 10
+
+// volumeChannels are sound channels of volume items
+var volumeChannels = map[pauseItem]sfx.Channel{
+	volumeItem:      sfx.MasterChannel,
+	musicVolumeItem: sfx.MusicChannel,
+	sfxVolumeItem:   sfx.EffectsChannel,
+}
+
+// PauseMenu is shown over the paused game. It changes options itself,
+// the rest of items are chosen by the player and handled by PlaygroundState
+type PauseMenu struct {
+	config   StateConfig
+	page     pausePage
+	selected int
+	status   string     // result of the last chosen item, e.g. saving
+	scale    int        // index of the window size in windowScales
+	bounds   pixel.Rect // of the stage, the menu is drawn over it
+	txt      *text.Text
+	shade    *imdraw.IMDraw
+}
+
+func NewPauseMenu(config StateConfig, stageBounds pixel.Rect) *PauseMenu {
+	m := new(PauseMenu)
+	m.config = config
+	m.bounds = stageBounds
+	atlas := text.NewAtlas(m.config.DefaultFont, text.ASCII)
+	m.txt = text.New(pixel.V(0, 0), atlas)
+	m.txt.LineHeight = atlas.LineHeight() * 1.5
+	m.shade = imdraw.New(nil)
+	m.shade.Color = pixel.RGBA{A: 0.6}
+	m.shade.Push(stageBounds.Min, stageBounds.Max)
+	m.shade.Rectangle(0)
+	return m
+}
+
+// Open shows the main page
+func (m *PauseMenu) Open() {
+	m.page = pauseMainPage
+	m.selected = 0
+	m.status = ""
+}
+
+// SetStatus shows the result of the chosen item under the menu
+func (m *PauseMenu) SetStatus(status string) {
+	m.status = status
+}
+
+func (m *PauseMenu) items() []pauseItem {
+	switch m.page {
+	case pauseOptionsPage:
+		return []pauseItem{volumeItem, musicVolumeItem, sfxVolumeItem, bindingsItem, scaleItem, backItem}
+	}
+	items := []pauseItem{resumeItem, restartItem, optionsItem}
+	if m.config.SavePath != "" {
+		items = append(items, saveItem)
+	}
+	return append(items, quitItem)
+}
+
+// Update handles navigation, it returns the item chosen by the player, it's from the main page or key bindings.
+// Going back from the main page resumes the game
+func (m *PauseMenu) Update(win *pixelgl.Window) (pauseItem, bool) {
+	items := m.items()
+	m.scale = windowScaleIndex(win, m.config.ScreenBounds)
+	action := ReadMenuAction(win)
+	switch action {
+	case MenuUp:
+		m.selected = (m.selected + len(items) - 1) % len(items)
+	case MenuDown:
+		m.selected = (m.selected + 1) % len(items)
+	case MenuLeft, MenuRight:
+		delta := 1
+		if action == MenuLeft {
+			delta = -1
+		}
+		switch items[m.selected] {
+		case volumeItem, musicVolumeItem, sfxVolumeItem:
+			ch := volumeChannels[items[m.selected]]
+			step := int(math.Round(sfx.Volume(ch)*volumeSteps)) + delta
+			sfx.SetVolume(ch, float64(step)/volumeSteps)
+		case scaleItem:
+			if i := m.scale + delta; i >= 0 && i < len(windowScales) {
+				setWindowScale(win, m.config.ScreenBounds, windowScales[i])
+				m.scale = i
+			}
+		}
+	case MenuBack:
+		if m.page == pauseMainPage {
+			return resumeItem, true
+		}
+		m.openPage(pauseMainPage)
+	case MenuSelect:
+		switch item := items[m.selected]; item {
+		case optionsItem:
+			m.openPage(pauseOptionsPage)
+		case backItem:
+			m.openPage(pauseMainPage)
+		case volumeItem, musicVolumeItem, sfxVolumeItem, scaleItem: // changed by left and right
+		default:
+			return item, true
+		}
+	}
+	return 0, false
+}
+
+func (m *PauseMenu) openPage(page pausePage) {
+	if m.page == pauseOptionsPage {
+		if err := saveAudio(m.config.SettingsPath); err != nil {
+			log.Printf("pause_menu: %v", err)
+		}
+	}
+	m.page = page
+	m.selected = 0
+	m.status = ""
+}
+
+func (m *PauseMenu) Draw(target pixel.Target) {
+	m.shade.Draw(target)
+	lines := []string{"PAUSE"}
+	for _, item := range m.items() {
+		line := pauseItemTitles[item]
+		switch item {
+		case volumeItem, musicVolumeItem, sfxVolumeItem:
+			line += fmt.Sprintf("  < %d >", int(math.Round(sfx.Volume(volumeChannels[item])*volumeSteps)))
+			if item == volumeItem && sfx.Muted() {
+				line += " MUTED"
+			}
+		case scaleItem:
+			line += fmt.Sprintf("  < %dX >", int(windowScales[m.scale]))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, m.status)
+
+	m.txt.Clear()
+	center := m.bounds.Center()
+	m.txt.Orig = pixel.V(0, center.Y+m.txt.LineHeight*float64(len(lines))/2)
+	m.txt.Dot = m.txt.Orig
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			m.txt.Color = colornames.Firebrick
+		case i == m.selected+1:
+			m.txt.Color = colornames.Gold
+		default:
+			m.txt.Color = colornames.White
+		}
+		m.txt.Dot.X = center.X - m.txt.BoundsOf(line).W()/2
+		_, _ = fmt.Fprintln(m.txt, line)
+	}
+	m.txt.Draw(target, pixel.IM)
+}
+
+// windowScaleIndex returns the index of the current window size in windowScales
+func windowScaleIndex(win *pixelgl.Window, bounds pixel.Rect) int {
+	scale := win.Bounds().W() / bounds.W()
+	closest := 0
+	for i, s := range windowScales {
+		if math.Abs(s-scale) < math.Abs(windowScales[closest]-scale) {
+			closest = i
+		}
+	}
+	return closest
+}
+
+// setWindowScale resizes the window, the game is scaled to fit it by Game
+func setWindowScale(win *pixelgl.Window, bounds pixel.Rect, scale float64) {
+	win.SetBounds(pixel.R(0, 0, bounds.W()*scale, bounds.H()*scale))
+}
@@ -1,414 +1,251 @@
 package game
 
 import (
-	"battlecity/game/explosions"
-	"battlecity/game/sfx"
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
-	"github.com/google/uuid"
 	"golang.org/x/image/colornames"
+	"log"
 	"math"
-	"time"
 )
 
+// TickDt is the duration of one simulation tick in seconds
+const TickDt = sim.TickDt
+
+// PlaygroundState plays a stage or a versus round simulated by sim.World, it draws the world and plays its sounds
 type PlaygroundState struct {
-	config            StateConfig
-	stageNum          int
-	rSide             *RSide
-	stage             *Stage
-	player            *Player
-	bots              map[uuid.UUID]*Bot
-	destroyedBots     []BotType
-	activeBonus       *Bonus
-	bullets           []*Bullet
-	bulletSprite      *pixel.Sprite
-	newBotInterval    time.Duration
-	newBotDuration    time.Duration
-	stageClearedTime  time.Time
-	isPaused          bool
-	isTimeStopBonus   bool
-	timeStopDuration  time.Duration
-	isArmoredHQBonus  bool
-	armoredHQDuration time.Duration
-	explosions        []*explosions.Explosion
+	config          StateConfig
+	world           *sim.World
+	rSide           *RSide
+	stage           *stageView
+	players         []*playerView
+	bots            map[*sim.Bot]*botView
+	bonus           *bonusView // nil if there is no bonus
+	bulletSprite    *pixel.Sprite
+	isPaused        bool
+	pauseMenu       *PauseMenu
+	stageStart      []sim.Progress // of players, the stage restarts with it
+	explosions      *explosionLayer
+	inputs          InputSource
+	tickAccumulator float64
+}
+
+func NewPlaygroundState(config StateConfig, stageNum int, players []*sim.Player) *PlaygroundState {
+	return newPlaygroundState(config, sim.NewWorld(config.simConfig(), stageNum, players))
 }
 
-func NewPlaygroundState(config StateConfig, stageNum int, player *Player) *PlaygroundState {
+// newPlaygroundState plays world, e.g. a loaded one
+func newPlaygroundState(config StateConfig, world *sim.World) *PlaygroundState {
 	s := new(PlaygroundState)
 	s.config = config
-	s.rSide = NewRightSide(s.config.Spritesheet, s.config.DefaultFont)
-	s.stageNum = stageNum
-	s.bulletSprite = pixel.NewSprite(s.config.Spritesheet, pixel.R(323, 154, 326, 150))
-	if player == nil {
-		s.player = NewPlayer(s.config.Spritesheet)
-		s.player.ResetLevel()
-	} else {
-		s.player = player
-	}
-	s.player.Respawn()
-	s.bots = make(map[uuid.UUID]*Bot)
-	s.newBotInterval = time.Second * 3
-	s.stage = NewStage(s.config.Spritesheet, s.config.StagesConfigs, s.stageNum)
+	s.world = world
+	s.rSide = NewRightSide(s.config.Sprites, s.config.DefaultFont)
+	for _, player := range s.world.Players() {
+		s.stageStart = append(s.stageStart, player.Progress())
+		s.players = append(s.players, newPlayerView(s.config.Sprites, player))
+	}
+	s.stage = newStageView(s.config.Sprites, s.world.Stage())
+	s.bots = make(map[*sim.Bot]*botView)
+	s.bulletSprite = s.config.Sprites.Sprite("bullet")
+	switch {
+	case s.config.Session == nil:
+		s.inputs = newLocalInputSource(localControllers(s.config, len(s.world.Players())))
+	case s.config.Session.Mode() == netplay.Rollback:
+		s.inputs = newRollbackInputSource(s.config.Session, localControllers(s.config, 1)[0], s)
+	default:
+		s.inputs = newNetInputSource(s.config.Session, localControllers(s.config, 1)[0])
+	}
+	s.explosions = newExplosionLayer(s.world.Events())
+	subscribeAudio(s.world, s.inputs.LocalPlayers())
+	blocks := s.world.Stage().Blocks
+	s.pauseMenu = NewPauseMenu(s.config, pixel.R(
+		blocks[0][0].Pos().X-sim.BlockSize/2, blocks[0][0].Pos().Y-sim.BlockSize/2,
+		blocks[sim.StageRows-1][sim.StageColumns-1].Pos().X+sim.BlockSize/2,
+		blocks[sim.StageRows-1][sim.StageColumns-1].Pos().Y+sim.BlockSize/2,
+	).Norm())
 	return s
 }
 
 func (s *PlaygroundState) Update(win *pixelgl.Window, dt float64) State {
-	now := time.Now()
-	if s.isStageCleared() && now.Sub(s.stageClearedTime) >= (time.Second*3) {
-		return NewStageTitleState(s.config, s.stageNum+1, s.player)
-	}
-	if win.JustPressed(pixelgl.KeyEscape) && !s.isStageCleared() {
-		s.isPaused = !s.isPaused
-		if s.isPaused {
-			sfx.PlayPause()
-		} else {
-			sfx.StopPause()
-		}
-	}
+	isOnline := s.config.Session != nil
 	if s.isPaused {
-		return nil
+		return s.updatePause(win)
 	}
-
-	const maxBots = 4
-	tanks := s.Tanks()
-
-	s.player.Update(dt)
-	// handle bots creation
-	canCreate := s.newBotDuration > s.newBotInterval || (len(s.destroyedBots) == 0 && len(s.bots) == 0)
-	s.newBotDuration += time.Duration(dt * float64(time.Second))
-	if len(s.bots) < maxBots && canCreate {
-		if newBot := s.stage.CreateBot(tanks, s.config.Spritesheet); newBot != nil {
-			s.bots[newBot.id] = newBot
-			if newBot.isBonus {
-				s.activeBonus = nil
-			}
-			s.newBotDuration = 0
-		}
+	if !isOnline && pausePressed(win, s.config.Bindings) && !s.world.IsOver() {
+		s.isPaused = true
+		s.pauseMenu.Open()
+		s.world.Events().Publish(sim.GamePaused{Paused: true})
+		return nil
 	}
+	s.inputs.Poll(win)
+	return s.Step(dt)
+}
 
-	// handle *all* tanks movement
-	movementResults := make(map[uuid.UUID]*MovementResult)
-	for id, tank := range tanks {
-		newPos, newDirection := tank.CalculateMovement(win, dt)
-		movementResults[id] = &MovementResult{newPos: newPos, direction: newDirection, canMove: true}
-	}
-	for _, blocks := range s.stage.Blocks {
-		for _, block := range blocks {
-			if !block.passable {
-				blockRect := Rect(block.pos, BlockSize, BlockSize)
-				for id, tank := range tanks {
-					movementRes := movementResults[id]
-					if tank.Pos() == movementRes.newPos { // tank didn't move
-						continue
-					}
-					tankRect := Rect(movementRes.newPos, TankSize, TankSize)
-					intersect := tankRect.Intersect(blockRect)
-					if intersect != pixel.ZR { // collision detected
-						movementRes.canMove = false
-					}
-				}
-			}
-		}
+// updatePause handles the pause menu, the game isn't simulated meanwhile
+func (s *PlaygroundState) updatePause(win *pixelgl.Window) State {
+	item, ok := s.pauseMenu.Update(win)
+	if !ok {
+		return nil
 	}
-	for idI := range tanks {
-		movementResultI := movementResults[idI]
-		if !movementResultI.canMove { // already can't move - skip
-			continue
-		}
-		tankIRect := Rect(movementResultI.newPos, TankSize, TankSize)
-		for idJ, tankJ := range tanks {
-			if idI == idJ { // don't compare with itself - skip
-				continue
-			}
-			tankJRect := Rect(tankJ.Pos(), TankSize, TankSize)
-
-			intersect := tankIRect.Intersect(tankJRect)
-			if intersect != pixel.ZR { // collision detected
-				movementResultI.canMove = false
-			}
-		}
+	switch item {
+	case resumeItem:
+		s.isPaused = false
+		s.world.Events().Publish(sim.GamePaused{Paused: false})
+	case restartItem:
+		s.world.Events().Publish(sim.GamePaused{Paused: false})
+		return s.restart()
+	case bindingsItem:
+		return NewBindingsState(s.config, s)
+	case saveItem:
+		s.pauseMenu.SetStatus("SAVED")
+		if err := s.SaveToSlot(); err != nil {
+			log.Printf("playground_state: %v", err)
+			s.pauseMenu.SetStatus("SAVE FAILED")
+		}
+	case quitItem:
+		s.world.Events().Publish(sim.GamePaused{Paused: false})
+		s.config.Match = nil
+		return NewMainMenuState(s.config)
 	}
+	return nil
+}
 
-	if s.isTimeStopBonus {
-		s.timeStopDuration += time.Duration(dt * float64(time.Second))
-		if s.timeStopDuration > time.Second*10 {
-			s.isTimeStopBonus = false
-		}
-	}
-	if s.isArmoredHQBonus {
-		if s.armoredHQDuration == 0 {
-			s.stage.ArmorHQ()
-		}
-		s.armoredHQDuration += time.Duration(dt * float64(time.Second))
-		if s.armoredHQDuration >= time.Second*17 {
-			blinkPeriod := time.Millisecond * 250
-			delta := s.armoredHQDuration - (time.Second * 17)
-			if math.Mod(float64(delta/blinkPeriod), 2) == 0 {
-				if s.stage.isHQArmored {
-					s.stage.DisarmorHQ()
-				}
-			} else {
-				if !s.stage.isHQArmored {
-					s.stage.ArmorHQ()
-				}
-			}
-		}
-		if s.armoredHQDuration >= time.Second*20 {
-			s.isArmoredHQBonus = false
-		}
-	}
-	for id, tank := range tanks {
-		canMove := tank.Side() == human || tank.Side() == bot && !s.isTimeStopBonus
-		if canMove {
-			tank.Move(movementResults[id], dt)
-		}
+// restart starts the stage again, players have lives, levels and scores they had at its start
+func (s *PlaygroundState) restart() State {
+	if s.config.Match != nil {
+		return NewStageTitleState(s.config, s.world.StageNum(), nil)
 	}
+	s.world.RestartPlayers(s.stageStart)
+	return NewStageTitleState(s.config, s.world.StageNum(), s.world.Players())
+}
 
-	s.bonusUpdate()
-
-	// handle bullets movement & collision
-	for i := 0; i < len(s.bullets); i++ {
-		bullet := s.bullets[i]
-		bullet.Move(dt)
-
-		w, h := BulletW, BulletH
-		if bullet.direction.IsHorizontal() {
-			w, h = h, w
-		}
-		bulletRect := Rect(bullet.pos, w, h)
-		var collidedDestroyableBlocks []*Block
-		collision := false
-		for _, blocks := range s.stage.Blocks { // check collision between bullet and blocks
-			for _, block := range blocks {
-				if !block.shootable {
-					blockRect := Rect(block.pos, BlockSize, BlockSize)
-					intersect := bulletRect.Intersect(blockRect)
-					if intersect != pixel.ZR { // collision detected
-						if block.destroyable || (block.kind == SteelBlock && bullet.IsUpgraded()) {
-							if block.kind == HQBlock {
-								s.stage.DestroyHQ()
-								sfx.PlayHQDestroyed()
-								hqPos := pixel.V(15*Scale*BlockSize, 3*Scale*BlockSize)
-								explosion := explosions.NewExplosion(explosions.TankExplosion, hqPos)
-								s.explosions = append(s.explosions, explosion)
-								// TODO game over
-							} else {
-								collidedDestroyableBlocks = append(collidedDestroyableBlocks, block)
-							}
-						}
-						collision = true
-					}
-				}
-			}
-		}
-
-		if len(collidedDestroyableBlocks) != 0 {
-			if len(collidedDestroyableBlocks) > 2 {
-				panic("playground_state: theoretically impossible")
-			}
-
-			firstCollidedBlock := collidedDestroyableBlocks[0]
-			var secondCollidedBlock *Block = nil
-			if len(collidedDestroyableBlocks) == 2 {
-				secondCollidedBlock = collidedDestroyableBlocks[1]
-			}
-			firstCollidedBlock.ProcessCollision(bullet, secondCollidedBlock)
-			if firstCollidedBlock.IsDestroyed() || bullet.IsUpgraded() {
-				s.stage.DestroyBlock(firstCollidedBlock)
-			}
-			if secondCollidedBlock != nil && (secondCollidedBlock.IsDestroyed() || bullet.IsUpgraded()) {
-				s.stage.DestroyBlock(secondCollidedBlock)
-			}
-			s.stage.NeedsRedraw()
-		} else { // check collision between bullet and tanks
-			for id, tank := range tanks {
-				if tank.Side() != bullet.origin.Side() && !tank.OnCreation() {
-					tankRect := Rect(tank.Pos(), TankSize, TankSize)
-					intersect := bulletRect.Intersect(tankRect)
-					if intersect != pixel.ZR { // collision detected
-						if tank.Side() == bot {
-							botTank, _ := tank.(*Bot)
-							botTank.hp--
-							if botTank.isBonus {
-								sfx.PlayBonusAppeared()
-								botTank.isBonus = false
-								s.activeBonus = NewBonus(s.config.Spritesheet, s.stage.Blocks)
-							}
-							if botTank.hp <= 0 {
-								s.destroyBot(id)
-							}
-						} else if !s.player.immune {
-							s.player.lives--
-							if s.player.lives < 0 {
-								// TODO game over
-							}
-							sfx.PlayPlayerDestroyed()
-							explosion := explosions.NewExplosion(explosions.TankExplosion, s.player.pos)
-							s.explosions = append(s.explosions, explosion)
-							s.player.ResetLevel()
-							s.player.Respawn()
-						}
-						collision = true
-					}
-				}
-			}
-		}
-		// check collision between bullet and bullet
-		isBulletBulletCollision := false
-		if !collision {
-			for j := 0; j < len(s.bullets); j++ {
-				bullet2 := s.bullets[j]
-				if i != j && bullet.origin.Side() != bullet2.origin.Side() {
-					w2, h2 := BulletW, BulletH
-					if bullet.direction.IsHorizontal() {
-						w2, h2 = h2, w2
-					}
-					bullet2Rect := Rect(bullet2.pos, w2, h2)
-					intersect := bulletRect.Intersect(bullet2Rect)
-					if intersect != pixel.ZR { // collision detected
-						collision = true
-						isBulletBulletCollision = true
-						bullet2.Destroy()
-					}
-				}
-			}
-		}
-
-		if collision {
-			if !isBulletBulletCollision {
-				explosion := explosions.NewExplosion(explosions.BulletExplosion, bullet.pos)
-				s.explosions = append(s.explosions, explosion)
-			}
-			bullet.Destroy()
+// Step advances the game by dt with polled inputs, it doesn't need a window
+func (s *PlaygroundState) Step(dt float64) State {
+	const maxTicksBehind = 5
+	s.tickAccumulator = math.Min(s.tickAccumulator+dt, maxTicksBehind*TickDt)
+	for s.tickAccumulator >= TickDt {
+		inputs, ok := s.inputs.NextInputs()
+		if !ok { // waiting for remote inputs
+			break
 		}
-		// remove destroyed bullets from slice
-		var tmpBullets []*Bullet
-		for _, b := range s.bullets {
-			if !b.destroyed {
-				tmpBullets = append(tmpBullets, b)
-			}
+		s.tickAccumulator -= TickDt
+		newState := s.Tick(inputs)
+		// the tick is done before the next state starts, otherwise its inputs would be sampled for the same tick again
+		if err := s.inputs.Ticked(s); err != nil {
+			log.Printf("playground_state: %v", err)
+			s.config.Session.Close()
+			s.config.Session = nil
+			return NewMainMenuState(s.config)
 		}
-		s.bullets = tmpBullets
-	}
-
-	// handle explosions
-	for i := 0; i < len(s.explosions); i++ {
-		exp := s.explosions[i]
-		if exp.IsEnded() {
-			s.explosions[i] = s.explosions[len(s.explosions)-1]
-			s.explosions = s.explosions[:len(s.explosions)-1]
+		if newState != nil {
+			return newState
 		}
 	}
 
-	// handle shooting
-	for _, tank := range tanks {
-		canShoot := tank.Side() == human || tank.Side() == bot && !s.isTimeStopBonus
-		if canShoot {
-			bullet := tank.Shoot(win, dt)
-			if bullet != nil {
-				s.bullets = append(s.bullets, bullet)
-			}
-		}
-	}
+	s.updateView()
+	return nil
+}
 
-	if s.isStageCleared() && s.stageClearedTime.IsZero() {
-		s.stageClearedTime = now
-	}
+// updateView removes ended explosions and updates the right side panel
+func (s *PlaygroundState) updateView() {
+	s.explosions.removeEnded()
+	players := s.world.Players()
 	s.rSide.Update(RSideData{
-		stageNum:         s.stageNum,
-		firstPlayerLives: int(math.Max(float64(s.player.lives), 0)),
-		botsPullLen:      len(s.stage.botsPool) - s.stage.botPoolIndex,
+		stageNum:          s.world.StageNum(),
+		firstPlayerLives:  int(math.Max(float64(players[0].Lives()), 0)),
+		secondPlayerLives: s.secondPlayerLives(),
+		botsPullLen:       s.world.Stage().BotsLeft(),
 	})
+}
 
+// Tick advances the simulation by TickDt using inputs of all players
+func (s *PlaygroundState) Tick(inputs []sim.Input) State {
+	if s.world.Tick(inputs) {
+		return s.nextState()
+	}
 	return nil
 }
 
-func (s *PlaygroundState) Draw(win *pixelgl.Window, dt float64) {
-	win.Clear(colornames.Black)
-	s.stage.Draw(win, dt)
-	s.DrawBullets(win)
-	s.player.Draw(win, dt, s.isPaused)
-	for _, b := range s.bots {
-		b.Draw(win, dt, s.isPaused, s.isTimeStopBonus)
+func (s *PlaygroundState) Draw(canvas *pixelgl.Canvas, dt float64) {
+	canvas.Clear(colornames.Black)
+	s.stage.Draw(canvas, dt)
+	s.DrawBullets(canvas)
+	for _, player := range s.players {
+		player.Draw(canvas, dt, s.isPaused)
 	}
-	s.stage.DrawTrees(win)
-	if s.activeBonus != nil {
-		s.activeBonus.Draw(win, dt)
+	s.drawBots(canvas, dt)
+	s.stage.DrawTrees(canvas)
+	if bonus := s.world.Bonus(); bonus != nil {
+		if s.bonus == nil || !s.bonus.shows(bonus) {
+			s.bonus = newBonusView(s.config.Sprites, bonus)
+		}
+		s.bonus.Draw(canvas, dt)
 	}
-	for _, explosion := range s.explosions {
-		explosion.Draw(win, dt, s.isPaused)
+	s.explosions.Draw(canvas, dt, s.isPaused)
+	s.rSide.Draw(canvas)
+	if s.isPaused {
+		s.pauseMenu.Draw(canvas)
 	}
-	s.rSide.Draw(win)
 }
 
-func (s *PlaygroundState) bonusUpdate() {
-	if s.activeBonus != nil {
-		bonusR := Rect(s.activeBonus.pos, BonusSize, BonusSize)
-		playerR := Rect(s.player.pos, TankSize, TankSize)
-		if playerR.Intersect(bonusR) != pixel.ZR {
-			isLifeBonus := false
-			switch s.activeBonus.bonusType {
-			case ImmunityBonus:
-				s.player.MakeImmune(time.Second * 10)
-			case TimeStopBonus:
-				s.isTimeStopBonus = true
-				s.timeStopDuration = 0
-			case HQArmorBonus:
-				s.isArmoredHQBonus = true
-				s.armoredHQDuration = 0
-			case UpgradeBonus:
-				s.player.Upgrade()
-			case AnnihilationBonus:
-				s.annihilateBots()
-			case LifeBonus:
-				isLifeBonus = true
-				if s.player.lives < 9 {
-					s.player.lives++
-				}
-			}
-			if isLifeBonus {
-				sfx.PlayBonusTakenLife()
-			} else {
-				sfx.PlayBonusTakenOther()
-			}
-			s.activeBonus = nil
+// drawBots draws bots in order of creation, views of destroyed bots are dropped
+func (s *PlaygroundState) drawBots(target pixel.Target, dt float64) {
+	bots := s.world.Bots()
+	for b := range s.bots {
+		if !containsBot(bots, b) {
+			delete(s.bots, b)
 		}
 	}
+	for _, b := range bots {
+		view, ok := s.bots[b]
+		if !ok {
+			view = newBotView(s.config.Sprites, b)
+			s.bots[b] = view
+		}
+		view.Draw(target, dt, s.isPaused, s.world.IsTimeStopped())
+	}
 }
 
-func (s *PlaygroundState) destroyBot(id uuid.UUID) {
-	sfx.PlayBotDestroyed()
-	s.destroyedBots = append(s.destroyedBots, s.bots[id].botType)
-	explosion := explosions.NewExplosion(explosions.TankExplosion, s.bots[id].pos)
-	s.explosions = append(s.explosions, explosion)
-	delete(s.bots, id)
+func containsBot(bots []*sim.Bot, b *sim.Bot) bool {
+	for _, b2 := range bots {
+		if b2 == b {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *PlaygroundState) annihilateBots() {
-	for id := range s.bots {
-		s.destroyBot(id)
+// secondPlayerLives returns -1 if there is no second player
+func (s *PlaygroundState) secondPlayerLives() int {
+	players := s.world.Players()
+	if len(players) < 2 {
+		return -1
 	}
-	s.newBotDuration = 0
+	return int(math.Max(float64(players[1].Lives()), 0))
 }
 
-func (s *PlaygroundState) Tanks() map[uuid.UUID]Tank {
-	tanks := make(map[uuid.UUID]Tank)
-	tanks[s.player.id] = s.player
-	for id, b := range s.bots {
-		tanks[id] = b
+func (s *PlaygroundState) DrawBullets(target pixel.Target) {
+	for _, bullet := range s.world.Bullets() {
+		m := pixel.IM.Moved(bullet.Pos()).
+			Rotated(bullet.Pos(), bullet.Direction().Angle())
+		s.bulletSprite.Draw(target, m)
 	}
-	return tanks
 }
 
-func (s *PlaygroundState) DrawBullets(win *pixelgl.Window) {
-	for _, bullet := range s.bullets {
-		m := pixel.IM.Moved(bullet.pos).
-			Scaled(bullet.pos, Scale).
-			Rotated(bullet.pos, bullet.direction.Angle())
-		s.bulletSprite.Draw(win, m)
-	}
+// Hash returns hash of the simulation state, it's used to detect desyncs
+func (s *PlaygroundState) Hash() uint64 {
+	return s.world.Hash()
 }
 
-func (s *PlaygroundState) isStageCleared() bool {
-	return s.stage.IsPoolEmpty() && len(s.bots) == 0
+// nextState returns the state after the stage or the versus round is over
+func (s *PlaygroundState) nextState() State {
+	if s.config.Match == nil {
+		return NewStageTitleState(s.config, s.world.StageNum()+1, s.world.Players())
+	}
+	s.config.Match.EndRound(s.world.RoundWinner())
+	if s.config.Match.Winner() >= 0 {
+		return NewVersusResultState(s.config)
+	}
+	return NewStageTitleState(s.config, s.config.Match.Arena(), nil)
 }
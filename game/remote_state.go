@@ -0,0 +1,141 @@
+package game
+
+import (
+	"battlecity/game/headless"
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"math"
+	"strings"
+)
+
+// RemoteState shows the game simulated by the dedicated server, see headless.Server.
+// A player sends inputs to it, a spectator only watches
+type RemoteState struct {
+	config           StateConfig
+	addr             string
+	client           *netplay.Client
+	controller       Controller
+	playground       *PlaygroundState // drawn, but not simulated
+	baselines        map[uint32]remoteBaseline
+	lastTick         uint32 // of the last applied state
+	inputAccumulator float64
+	err              error
+	atlas            *text.Atlas
+	txt              *text.Text
+}
+
+// remoteBaseline is the stage of an applied state, the server may send next states as deltas to it
+type remoteBaseline struct {
+	stageNum int
+	stage    sim.StageSnapshot
+}
+
+// NewRemoteState connects to the server at addr (host:port)
+func NewRemoteState(config StateConfig, addr string, spectate bool) *RemoteState {
+	s := new(RemoteState)
+	s.config = config
+	s.addr = addr
+	s.controller = localControllers(config, 1)[0]
+	s.baselines = make(map[uint32]remoteBaseline)
+	s.atlas = text.NewAtlas(s.config.DefaultFont, text.ASCII)
+	s.txt = text.New(pixel.V(0, 0), s.atlas)
+	s.client, s.err = netplay.Connect(addr, spectate)
+	return s
+}
+
+func (s *RemoteState) Update(win *pixelgl.Window, dt float64) State {
+	if win.JustPressed(pixelgl.KeyEscape) {
+		if s.client != nil {
+			s.client.Close()
+		}
+		return NewMainMenuState(s.config)
+	}
+	if s.err != nil {
+		return nil
+	}
+	if err := s.client.Err(); err != nil {
+		s.err = err
+		s.client.Close()
+		return nil
+	}
+	if !s.client.Ready() {
+		return nil
+	}
+	if s.client.Player() >= 0 {
+		const maxTicksBehind = 5
+		s.controller.Poll(win)
+		s.inputAccumulator = math.Min(s.inputAccumulator+dt, maxTicksBehind*TickDt)
+		for s.inputAccumulator >= TickDt {
+			s.inputAccumulator -= TickDt
+			s.client.SendInput(byte(s.controller.Read()))
+		}
+	}
+	if state, ok := s.client.State(); ok {
+		s.apply(state)
+	}
+	if s.playground != nil {
+		s.playground.updateView()
+	}
+	return nil
+}
+
+// apply shows the received state, it's acknowledged if it's valid
+func (s *RemoteState) apply(state netplay.State) {
+	d, err := sim.DecodeState(state.Payload, func(stageNum int) (*sim.StageSnapshot, bool) {
+		baseline, ok := s.baselines[state.Baseline]
+		return &baseline.stage, ok && baseline.stageNum == stageNum
+	})
+	if err != nil {
+		return
+	}
+	stageNum := d.StageNum()
+	if s.playground == nil || s.playground.world.StageNum() != stageNum {
+		s.config.Players = d.Players()
+		s.playground = NewPlaygroundState(s.config, stageNum, nil)
+	}
+	s.playground.world.ApplyState(d)
+	s.playground.explosions.addStates(d.Explosions(), s.lastTick)
+	s.lastTick = state.Tick
+	s.baselines[state.Tick] = remoteBaseline{stageNum: stageNum, stage: *d.Stage()}
+	for tick := range s.baselines {
+		if tick+headless.History < state.Tick {
+			delete(s.baselines, tick)
+		}
+	}
+	s.client.AckState(state.Tick)
+}
+
+func (s *RemoteState) Draw(canvas *pixelgl.Canvas, dt float64) {
+	if s.err == nil && s.playground != nil {
+		s.playground.Draw(canvas, dt)
+		return
+	}
+	var lines []string
+	switch {
+	case s.err != nil:
+		lines = []string{"NETWORK ERROR", strings.ToUpper(s.err.Error())}
+	case s.client.Ready():
+		lines = []string{"WAITING FOR GAME"}
+	default:
+		lines = []string{"CONNECTING TO", s.addr}
+	}
+	lines = append(lines, "", "ESC - BACK")
+
+	canvas.Clear(colornames.Black)
+	s.txt.Clear()
+	s.txt.Color = colornames.White
+	s.txt.LineHeight = s.atlas.LineHeight() * 1.5
+	center := canvas.Bounds().Center()
+	s.txt.Orig = pixel.V(0, center.Y+s.txt.LineHeight*float64(len(lines))/2)
+	s.txt.Dot = s.txt.Orig
+	for _, line := range lines {
+		s.txt.Dot.X = center.X - s.txt.BoundsOf(line).W()/2
+		_, _ = fmt.Fprintln(s.txt, line)
+	}
+	s.txt.Draw(canvas, pixel.IM)
+}
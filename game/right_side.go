@@ -1,10 +1,11 @@
 package game
 
 import (
+	"battlecity/game/atlas"
+	"battlecity/game/sim"
 	"fmt"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
-	"github.com/faiface/pixel/pixelgl"
 	"github.com/faiface/pixel/text"
 	"golang.org/x/image/colornames"
 	"golang.org/x/image/font"
@@ -13,31 +14,35 @@ import (
 )
 
 type RSideData struct {
-	stageNum         int
-	firstPlayerLives int
-	botsPullLen      int
+	stageNum          int
+	firstPlayerLives  int
+	secondPlayerLives int // -1 if there is no second player
+	botsPullLen       int
 }
 
 type RSide struct {
-	batch           *pixel.Batch
-	needsRedraw     bool
-	firstPlayerIcon *pixel.Sprite
-	livesIcon       *pixel.Sprite
-	stageIcon       *pixel.Sprite
-	botIcon         *pixel.Sprite
-	atlas           *text.Atlas
-	stageTxt        *text.Text
-	livesTxt        *text.Text
-	data            *RSideData
+	batch            *pixel.Batch
+	needsRedraw      bool
+	firstPlayerIcon  *pixel.Sprite
+	secondPlayerIcon *pixel.Sprite
+	livesIcon        *pixel.Sprite
+	stageIcon        *pixel.Sprite
+	botIcon          *pixel.Sprite
+	atlas            *text.Atlas
+	stageTxt         *text.Text
+	livesTxt         *text.Text
+	secondLivesTxt   *text.Text
+	data             *RSideData
 }
 
-func NewRightSide(spritesheet pixel.Picture, font font.Face) *RSide {
+func NewRightSide(sprites *atlas.Atlas, font font.Face) *RSide {
 	r := new(RSide)
-	r.batch = pixel.NewBatch(&pixel.TrianglesData{}, spritesheet)
-	r.firstPlayerIcon = pixel.NewSprite(spritesheet, pixel.R(376, 112, 392, 120))
-	r.livesIcon = pixel.NewSprite(spritesheet, pixel.R(376, 104, 384, 112))
-	r.stageIcon = pixel.NewSprite(spritesheet, pixel.R(376, 56, 392, 72))
-	r.botIcon = pixel.NewSprite(spritesheet, pixel.R(320, 56, 328, 64))
+	r.batch = pixel.NewBatch(&pixel.TrianglesData{}, sprites.Picture())
+	r.firstPlayerIcon = sprites.Sprite("hud.player1")
+	r.secondPlayerIcon = sprites.Sprite("hud.player2")
+	r.livesIcon = sprites.Sprite("hud.lives")
+	r.stageIcon = sprites.Sprite("hud.stage")
+	r.botIcon = sprites.Sprite("hud.bot")
 	r.atlas = text.NewAtlas(font, text.ASCII)
 	return r
 }
@@ -48,16 +53,26 @@ func (r *RSide) Update(data RSideData) {
 	}
 	if r.data == nil || r.data.firstPlayerLives != data.firstPlayerLives {
 		r.livesTxt = text.New(pixel.V(
-			29*BlockSize*Scale+36,
-			10*BlockSize*Scale+30,
+			29*sim.BlockSize+9,
+			10*sim.BlockSize+8,
 		), r.atlas)
 		r.livesTxt.Color = colornames.Black
 		_, _ = fmt.Fprintln(r.livesTxt, fmt.Sprintf("%d", data.firstPlayerLives))
 	}
+	if r.data == nil || r.data.secondPlayerLives != data.secondPlayerLives {
+		r.secondLivesTxt = text.New(pixel.V(
+			29*sim.BlockSize+9,
+			7*sim.BlockSize+8,
+		), r.atlas)
+		r.secondLivesTxt.Color = colornames.Black
+		if data.secondPlayerLives >= 0 {
+			_, _ = fmt.Fprintln(r.secondLivesTxt, fmt.Sprintf("%d", data.secondPlayerLives))
+		}
+	}
 	if r.data == nil || r.data.stageNum != data.stageNum {
 		r.stageTxt = text.New(pixel.V(
-			29*BlockSize*Scale+36,
-			3*BlockSize*Scale+30,
+			29*sim.BlockSize+9,
+			3*sim.BlockSize+8,
 		), r.atlas)
 		r.stageTxt.Color = colornames.Black
 		_, _ = fmt.Fprintln(r.stageTxt, fmt.Sprintf("%d", data.stageNum))
@@ -65,15 +80,16 @@ func (r *RSide) Update(data RSideData) {
 	r.data = &data
 }
 
-func (r *RSide) Draw(win *pixelgl.Window) {
-	r.drawBatch(win)
-	r.livesTxt.Draw(win, pixel.IM.Scaled(r.livesTxt.Orig, 0.91))
-	r.stageTxt.Draw(win, pixel.IM.Scaled(r.stageTxt.Orig, 0.91))
+func (r *RSide) Draw(target pixel.Target) {
+	r.drawBatch(target)
+	r.livesTxt.Draw(target, pixel.IM)
+	r.secondLivesTxt.Draw(target, pixel.IM)
+	r.stageTxt.Draw(target, pixel.IM)
 }
 
-func (r *RSide) drawBatch(win *pixelgl.Window) {
+func (r *RSide) drawBatch(target pixel.Target) {
 	if !r.needsRedraw {
-		r.batch.Draw(win)
+		r.batch.Draw(target)
 		return
 	}
 	r.batch.Clear()
@@ -81,42 +97,56 @@ func (r *RSide) drawBatch(win *pixelgl.Window) {
 	//bg
 	rightRect := imdraw.New(nil)
 	rightRect.Color = color.RGBA{R: 99, G: 99, B: 99, A: 1}
-	rightRect.Push(pixel.V(BlockSize*Scale*30, 0), pixel.V(BlockSize*Scale*32, BlockSize*Scale*30))
+	rightRect.Push(pixel.V(sim.BlockSize*30, 0), pixel.V(sim.BlockSize*32, sim.BlockSize*30))
 	rightRect.Rectangle(0)
 	rightRect.Draw(r.batch)
 
 	// bots icons
 	for i := 0; i < r.data.botsPullLen; i++ {
-		yStart := 26*BlockSize*Scale + BlockSize*Scale/2
+		yStart := 26*sim.BlockSize + sim.BlockSize/2
 		row := math.Mod(float64(i), 2)
 		botIconPos := pixel.V(
-			29*BlockSize*Scale+BlockSize*Scale/2+row*BlockSize*Scale,
-			yStart-BlockSize*Scale*float64(i/2),
+			29*sim.BlockSize+sim.BlockSize/2+row*sim.BlockSize,
+			yStart-sim.BlockSize*float64(i/2),
 		)
-		r.botIcon.Draw(r.batch, pixel.IM.Moved(botIconPos).Scaled(botIconPos, Scale))
+		r.botIcon.Draw(r.batch, pixel.IM.Moved(botIconPos))
 	}
 
 	// first player lives
 	firstPlayerIconPos := pixel.V(
-		29*BlockSize*Scale+r.firstPlayerIcon.Frame().W()*Scale/2,
-		12*BlockSize*Scale+r.firstPlayerIcon.Frame().H()*Scale/2,
+		29*sim.BlockSize+r.firstPlayerIcon.Frame().W()/2,
+		12*sim.BlockSize+r.firstPlayerIcon.Frame().H()/2,
 	)
-	r.firstPlayerIcon.Draw(r.batch, pixel.IM.Moved(firstPlayerIconPos).Scaled(firstPlayerIconPos, Scale))
+	r.firstPlayerIcon.Draw(r.batch, pixel.IM.Moved(firstPlayerIconPos))
 
 	// lives icon
 	livesIconPos := pixel.V(
-		29*BlockSize*Scale+r.livesIcon.Frame().W()*Scale/2,
-		11*BlockSize*Scale+r.livesIcon.Frame().H()*Scale/2,
+		29*sim.BlockSize+r.livesIcon.Frame().W()/2,
+		11*sim.BlockSize+r.livesIcon.Frame().H()/2,
 	)
-	r.livesIcon.Draw(r.batch, pixel.IM.Moved(livesIconPos).Scaled(livesIconPos, Scale))
+	r.livesIcon.Draw(r.batch, pixel.IM.Moved(livesIconPos))
+
+	// second player lives
+	if r.data.secondPlayerLives >= 0 {
+		secondPlayerIconPos := pixel.V(
+			29*sim.BlockSize+r.secondPlayerIcon.Frame().W()/2,
+			9*sim.BlockSize+r.secondPlayerIcon.Frame().H()/2,
+		)
+		r.secondPlayerIcon.Draw(r.batch, pixel.IM.Moved(secondPlayerIconPos))
+		secondLivesIconPos := pixel.V(
+			29*sim.BlockSize+r.livesIcon.Frame().W()/2,
+			8*sim.BlockSize+r.livesIcon.Frame().H()/2,
+		)
+		r.livesIcon.Draw(r.batch, pixel.IM.Moved(secondLivesIconPos))
+	}
 
 	// stage icon
 	stageIconPos := pixel.V(
-		29*BlockSize*Scale+r.stageIcon.Frame().W()*Scale/2,
-		5*BlockSize*Scale+r.stageIcon.Frame().H()*Scale/2,
+		29*sim.BlockSize+r.stageIcon.Frame().W()/2,
+		5*sim.BlockSize+r.stageIcon.Frame().H()/2,
 	)
-	r.stageIcon.Draw(r.batch, pixel.IM.Moved(stageIconPos).Scaled(stageIconPos, Scale))
+	r.stageIcon.Draw(r.batch, pixel.IM.Moved(stageIconPos))
 
-	r.batch.Draw(win)
+	r.batch.Draw(target)
 	r.needsRedraw = false
 }
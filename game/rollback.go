@@ -0,0 +1,174 @@
+package game
+
+import (
+	"battlecity/game/netplay"
+	"battlecity/game/sim"
+	"github.com/faiface/pixel/pixelgl"
+)
+
+// maxRollbackTicks is how far the simulation may run ahead of known remote inputs
+const maxRollbackTicks = 8
+
+// RollbackStats show how often remote inputs were mispredicted
+type RollbackStats struct {
+	Rollbacks        int // number of corrections
+	MaxDepth         int // the most ticks simulated again at once
+	ResimulatedTicks int
+	Stalls           int // frames spent waiting for remote inputs because the prediction went too far
+}
+
+// rollbackFrame is the state before a tick and inputs it was simulated with
+type rollbackFrame struct {
+	snapshot Snapshot
+	inputs   []sim.Input
+	hash     uint64 // hash of the state, only for ticks multiple of netplay.HashInterval
+}
+
+// rollbackInputSource doesn't wait for remote inputs, it predicts them instead.
+// When a prediction turns out to be wrong, the state is restored and the following ticks are simulated again
+type rollbackInputSource struct {
+	session      *netplay.Session
+	state        *PlaygroundState
+	controller   Controller
+	sent         bool // local input for the current tick is sent
+	frames       [maxRollbackTicks + 2]rollbackFrame
+	remoteInputs map[uint32]sim.Input
+	confirmed    uint32 // remote inputs for all ticks < confirmed are known
+	nextHashTick uint32
+	stats        RollbackStats
+}
+
+// newRollbackInputSource must be created when all inputs before the current tick are known, e.g. on a stage start
+func newRollbackInputSource(session *netplay.Session, controller Controller, state *PlaygroundState) *rollbackInputSource {
+	tick := session.Tick()
+	return &rollbackInputSource{
+		session:      session,
+		state:        state,
+		controller:   controller,
+		remoteInputs: make(map[uint32]sim.Input),
+		confirmed:    tick,
+		nextHashTick: (tick + netplay.HashInterval - 1) / netplay.HashInterval * netplay.HashInterval,
+	}
+}
+
+func (r *rollbackInputSource) Poll(win *pixelgl.Window) {
+	r.controller.Poll(win)
+}
+
+func (r *rollbackInputSource) NextInputs() ([]sim.Input, bool) {
+	tick := r.session.Tick()
+	if !r.sent {
+		r.session.SetLocalInput(tick, byte(r.controller.Read()))
+		r.sent = true
+	}
+	r.receive(tick)
+	r.sendHashes(tick)
+	tooFar := tick >= r.confirmed+maxRollbackTicks
+	// the stage ends soon and it can't be undone, so the last ticks are simulated without prediction
+	isEnding := r.state.world.IsOver() && tick >= r.confirmed
+	if tooFar || isEnding {
+		r.stats.Stalls++
+		return nil, false
+	}
+	local, _ := r.session.LocalInput(tick) // always set, see sent
+	inputs := r.inputs(tick, sim.Input(local))
+	r.save(tick, inputs)
+	return inputs, true
+}
+
+func (r *rollbackInputSource) Ticked(_ *PlaygroundState) error {
+	r.session.NextTick()
+	r.sent = false
+	return r.session.Err()
+}
+
+func (r *rollbackInputSource) LocalPlayers() []int {
+	return []int{r.session.LocalPlayer()}
+}
+
+// receive takes new remote inputs and corrects the simulation if they differ from predicted ones
+func (r *rollbackInputSource) receive(tick uint32) {
+	first := r.confirmed
+	inputs := r.session.RemoteInputs(first)
+	mispredicted := tick
+	remotePlayer := 1 - r.session.LocalPlayer()
+	for i, input := range inputs {
+		t := first + uint32(i)
+		r.remoteInputs[t] = sim.Input(input)
+		if t < tick && t < mispredicted && r.frame(t).inputs[remotePlayer] != sim.Input(input) {
+			mispredicted = t
+		}
+	}
+	r.confirmed = first + uint32(len(inputs))
+	for t := range r.remoteInputs {
+		if t+uint32(len(r.frames)) < tick && t+1 < r.confirmed {
+			delete(r.remoteInputs, t)
+		}
+	}
+	if mispredicted < tick {
+		r.rollback(mispredicted, tick)
+	}
+}
+
+// rollback restores the state before tick from and simulates ticks [from, to) again
+func (r *rollbackInputSource) rollback(from, to uint32) {
+	r.state.Restore(&r.frame(from).snapshot)
+	r.state.world.Events().Replay(func() {
+		for t := from; t < to; t++ {
+			local := r.frame(t).inputs[r.session.LocalPlayer()]
+			inputs := r.inputs(t, local)
+			r.save(t, inputs)
+			_ = r.state.Tick(inputs) // always nil, the stage never ends on a predicted tick
+		}
+	})
+	depth := int(to - from)
+	r.stats.Rollbacks++
+	r.stats.ResimulatedTicks += depth
+	if depth > r.stats.MaxDepth {
+		r.stats.MaxDepth = depth
+	}
+}
+
+// sendHashes sends hashes of states which can't be changed by rollbacks anymore
+func (r *rollbackInputSource) sendHashes(tick uint32) {
+	for r.nextHashTick <= r.confirmed && r.nextHashTick < tick {
+		r.session.SetLocalHash(r.nextHashTick, r.frame(r.nextHashTick).hash)
+		r.nextHashTick += netplay.HashInterval
+	}
+}
+
+// inputs returns inputs of all players for tick, the remote one is predicted if it's unknown
+func (r *rollbackInputSource) inputs(tick uint32, local sim.Input) []sim.Input {
+	inputs := make([]sim.Input, netplay.Players)
+	inputs[r.session.LocalPlayer()] = local
+	remote, ok := r.remoteInputs[tick]
+	if !ok && r.confirmed > 0 {
+		// the remote player most likely keeps moving the same way, but fire is a single press
+		remote = r.remoteInputs[r.confirmed-1] &^ sim.InputFire
+	}
+	inputs[1-r.session.LocalPlayer()] = remote
+	return inputs
+}
+
+// save remembers the state before tick and inputs it's simulated with
+func (r *rollbackInputSource) save(tick uint32, inputs []sim.Input) {
+	frame := r.frame(tick)
+	frame.inputs = inputs
+	r.state.Save(&frame.snapshot)
+	if tick%netplay.HashInterval == 0 {
+		frame.hash = r.state.Hash()
+	}
+}
+
+func (r *rollbackInputSource) frame(tick uint32) *rollbackFrame {
+	return &r.frames[tick%uint32(len(r.frames))]
+}
+
+// RollbackStats returns statistics of rollback netcode, false if it isn't used
+func (s *PlaygroundState) RollbackStats() (RollbackStats, bool) {
+	r, ok := s.inputs.(*rollbackInputSource)
+	if !ok {
+		return RollbackStats{}, false
+	}
+	return r.stats, true
+}
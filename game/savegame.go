@@ -0,0 +1,70 @@
+package game
+
+import (
+	"battlecity/game/sim"
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSave is returned by LoadFromSlot when there is no game to continue
+var ErrNoSave = errors.New("game: no saved game")
+
+// SaveToSlot writes the game to config.SavePath, see sim.World.SaveGame
+func (s *PlaygroundState) SaveToSlot() error {
+	if s.config.SavePath == "" {
+		return errors.New("game: saving is disabled")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.config.SavePath), 0o755); err != nil {
+		return err
+	}
+	// a crash while writing must not corrupt the previous save
+	tmp := s.config.SavePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = s.world.SaveGame(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.config.SavePath)
+}
+
+// LoadFromSlot reads the game from config.SavePath, ErrNoSave if there is none
+func LoadFromSlot(config StateConfig) (*PlaygroundState, error) {
+	if config.SavePath == "" {
+		return nil, ErrNoSave
+	}
+	f, err := os.Open(config.SavePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSave
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	world, err := sim.LoadGame(config.simConfig(), bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	return newPlaygroundState(config.withSimConfig(world.Config()), world), nil
+}
+
+// HasSave reports whether there is a game to continue
+func HasSave(config StateConfig) bool {
+	if config.SavePath == "" {
+		return false
+	}
+	_, err := os.Stat(config.SavePath)
+	return err == nil
+}
@@ -0,0 +1,169 @@
+package game
+
+import (
+	"battlecity/game/sfx"
+	"battlecity/game/sim"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faiface/pixel"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Settings are options of the game kept in a JSON file, command-line flags override them
+type Settings struct {
+	Width                int            `json:"width"`  // of the window
+	Height               int            `json:"height"` // of the window
+	Fullscreen           bool           `json:"fullscreen"`
+	Monitor              int            `json:"monitor"` // of fullscreen from 1, 0 for the primary monitor
+	VSync                bool           `json:"vsync"`
+	Scale                int            `json:"scale"`           // integer scale of the original 256x240 screen, 0 uses width and height
+	IntegerScaling       bool           `json:"integer_scaling"` // the screen is scaled by whole multiples only, otherwise it fits the window
+	Stage                int            `json:"stage"`           // the first stage of a new game
+	Difficulty           sim.Difficulty `json:"difficulty"`
+	Seed                 int64          `json:"seed"`                    // 0 for a random seed
+	GrenadeCountsAsKills bool           `json:"grenade_counts_as_kills"` // see sim.Rules
+	BotsTakeBonuses      bool           `json:"bots_take_bonuses"`       // see sim.Rules
+	NeutralBots          bool           `json:"neutral_bots"`            // see sim.Rules
+	Volume               float64        `json:"volume"`                  // master volume from 0 to 1
+	MusicVolume          float64        `json:"music_volume"`            // from 0 to 1
+	SfxVolume            float64        `json:"sfx_volume"`              // from 0 to 1
+	Muted                bool           `json:"muted"`
+	Music                bool           `json:"music"` // background music, sounds play anyway
+	StagesDir            string         `json:"stages_dir"`
+	Theme                string         `json:"theme"` // directory of a texture pack, empty for built-in sprites and font. It's drawn at 256x240
+}
+
+const (
+	screenWidth  = 256 // of the original game
+	screenHeight = 240
+	maxScale     = 8
+)
+
+// ScreenBounds are bounds of the original screen, the game is drawn at this resolution
+var ScreenBounds = pixel.R(0, 0, screenWidth, screenHeight)
+
+func DefaultSettings() Settings {
+	return Settings{
+		Width:       screenWidth * 4,
+		Height:      screenHeight * 4,
+		VSync:       true,
+		Stage:       1,
+		Volume:      1,
+		MusicVolume: 1,
+		SfxVolume:   1,
+		Music:       true,
+	}
+}
+
+// LoadSettings reads settings from the file at path, default settings are returned if there is no file
+func LoadSettings(path string) (Settings, error) {
+	settings := DefaultSettings()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("settings %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// Save writes settings to the file at path
+func (s Settings) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ApplyAudio sets volumes of sounds
+func (s Settings) ApplyAudio() {
+	sfx.SetVolume(sfx.MasterChannel, s.Volume)
+	sfx.SetVolume(sfx.MusicChannel, s.MusicVolume)
+	sfx.SetVolume(sfx.EffectsChannel, s.SfxVolume)
+	sfx.SetMuted(s.Muted)
+	sfx.EnableMusic(s.Music)
+}
+
+// saveAudio writes current volumes of sounds to the settings file at path, the rest of its settings are kept
+func saveAudio(path string) error {
+	if path == "" {
+		return nil
+	}
+	settings, err := LoadSettings(path)
+	if err != nil {
+		return err
+	}
+	settings.Volume = sfx.Volume(sfx.MasterChannel)
+	settings.MusicVolume = sfx.Volume(sfx.MusicChannel)
+	settings.SfxVolume = sfx.Volume(sfx.EffectsChannel)
+	settings.Muted = sfx.Muted()
+	return settings.Save(path)
+}
+
+// Rules returns optional gameplay rules of the settings
+func (s Settings) Rules() sim.Rules {
+	return sim.Rules{
+		GrenadeCountsAsKills: s.GrenadeCountsAsKills,
+		BotsTakeBonuses:      s.BotsTakeBonuses,
+		NeutralBots:          s.NeutralBots,
+	}
+}
+
+// WindowSize returns the size of the window, Scale takes precedence over Width and Height
+func (s Settings) WindowSize() (int, int) {
+	if s.Scale > 0 {
+		return screenWidth * s.Scale, screenHeight * s.Scale
+	}
+	return s.Width, s.Height
+}
+
+// Validate checks the settings, stages are the stage files to play and monitors is the number of connected monitors
+func (s Settings) Validate(stages fs.FS, monitors int) error {
+	switch {
+	case s.Scale < 0 || s.Scale > maxScale:
+		return fmt.Errorf("scale %d isn't in [0, %d]", s.Scale, maxScale)
+	case s.Scale == 0 && (s.Width < screenWidth || s.Height < screenHeight):
+		return fmt.Errorf("window %dx%d is smaller than %dx%d", s.Width, s.Height, screenWidth, screenHeight)
+	case s.Monitor < 0:
+		return fmt.Errorf("monitor %d must be from 1, or 0 for the primary one", s.Monitor)
+	case s.Monitor < 0:
+		return fmt.Errorf("monitor %d must be from 1, or 0 for the primary one", s.Monitor)
+	case s.Monitor > monitors:
+		return fmt.Errorf("no monitor %d, there are %d", s.Monitor, monitors)
+	case !s.Difficulty.Valid():
+		return fmt.Errorf("unknown difficulty %v", s.Difficulty)
+	case s.Volume < 0 || s.Volume > 1:
+		return fmt.Errorf("volume %v isn't in [0, 1]", s.Volume)
+	case s.MusicVolume < 0 || s.MusicVolume > 1:
+		return fmt.Errorf("music volume %v isn't in [0, 1]", s.MusicVolume)
+	case s.SfxVolume < 0 || s.SfxVolume > 1:
+		return fmt.Errorf("sfx volume %v isn't in [0, 1]", s.SfxVolume)
+	case s.Stage < 1:
+		return fmt.Errorf("stage %d must be from 1", s.Stage)
+	}
+	if s.Theme != "" {
+		if info, err := os.Stat(s.Theme); err != nil {
+			return fmt.Errorf("theme: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("theme %s isn't a directory", s.Theme)
+		}
+	}
+	if err := sim.CheckStages(stages); err != nil {
+		return fmt.Errorf("stages: %w", err)
+	}
+	if _, err := fs.Stat(stages, fmt.Sprintf("%d.stage", s.Stage)); err != nil {
+		return fmt.Errorf("stage %d: %w", s.Stage, err)
+	}
+	return nil
+}
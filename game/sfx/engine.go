@@ -0,0 +1,42 @@
+package sfx
+
+import (
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+)
+
+const maxPlayers = 2
+
+// engine is the idle and moving sound of the tank of a player, panned to the tank
+type engine struct {
+	idle   *beep.Ctrl
+	moving *beep.Ctrl
+	pan    *effects.Pan
+}
+
+func newEngine() *engine {
+	e := new(engine)
+	e.idle = &beep.Ctrl{
+		Streamer: beep.Loop(-1, stream(TankIdle)),
+		Paused:   true,
+	}
+	e.moving = &beep.Ctrl{
+		Streamer: beep.Loop(-1, stream(TankMoving)),
+		Paused:   true,
+	}
+	e.pan = &effects.Pan{Streamer: beep.Mix(e.idle, e.moving)}
+	return e
+}
+
+// playing reports whether the moving or the idle sound is playing
+func (e *engine) playing(moving bool) bool {
+	if moving {
+		return !e.moving.Paused
+	}
+	return !e.idle.Paused
+}
+
+func (e *engine) stop() {
+	e.idle.Paused = true
+	e.moving.Paused = true
+}
@@ -0,0 +1,91 @@
+package sfx
+
+import (
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+	"math"
+)
+
+// Channel is a volume control of a group of sounds
+type Channel int
+
+const (
+	MasterChannel  Channel = iota // of all sounds
+	MusicChannel                  // the startup jingle, the pause and music
+	EffectsChannel                // gameplay sounds and engines
+	channelsCount
+)
+
+// channel mixes its sounds at its volume
+type channel struct {
+	mixer  *beep.Mixer
+	volume *effects.Volume
+}
+
+var (
+	channels [channelsCount]*channel
+	levels   = [channelsCount]float64{1, 1, 1} // from 0 (silence) to 1 (as recorded)
+	silenced bool                              // by the player, see ToggleMute
+)
+
+// newMixer creates channels, the returned master channel streams the mixdown of all sounds
+func newMixer() beep.Streamer {
+	for ch := range channels {
+		mixer := &beep.Mixer{}
+		channels[ch] = &channel{mixer: mixer, volume: &effects.Volume{Streamer: mixer, Base: 2}}
+	}
+	channels[MasterChannel].mixer.Add(channels[MusicChannel].volume, channels[EffectsChannel].volume)
+	songs = &beep.Mixer{}
+	channels[MusicChannel].mixer.Add(songs)
+	for ch := range channels {
+		applyVolume(Channel(ch))
+	}
+	return channels[MasterChannel].volume
+}
+
+// SetVolume sets the volume of the channel from 0 (silence) to 1 (as recorded), it applies to playing sounds
+func SetVolume(ch Channel, level float64) {
+	levels[ch] = math.Max(0, math.Min(1, level))
+	if channels[ch] == nil {
+		return
+	}
+	sink.Lock()
+	defer sink.Unlock()
+	applyVolume(ch)
+}
+
+func Volume(ch Channel) float64 {
+	return levels[ch]
+}
+
+// SetMuted silences all sounds, volumes of channels are kept
+func SetMuted(muted bool) {
+	silenced = muted
+	if channels[MasterChannel] == nil {
+		return
+	}
+	sink.Lock()
+	defer sink.Unlock()
+	applyVolume(MasterChannel)
+}
+
+// ToggleMute silences or restores all sounds
+func ToggleMute() {
+	SetMuted(!silenced)
+}
+
+func Muted() bool {
+	return silenced
+}
+
+func applyVolume(ch Channel) {
+	v := channels[ch].volume
+	v.Silent = levels[ch] == 0 || (ch == MasterChannel && silenced)
+	v.Volume = math.Log2(levels[ch])
+}
+
+func play(ch Channel, s ...beep.Streamer) {
+	sink.Lock()
+	defer sink.Unlock()
+	channels[ch].mixer.Add(s...)
+}
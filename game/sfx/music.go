@@ -0,0 +1,174 @@
+package sfx
+
+import (
+	"errors"
+	"fmt"
+	"github.com/faiface/beep"
+	"io/fs"
+	"log"
+	"math"
+	"time"
+)
+
+// Music is background music of a state of the game
+type Music int
+
+const (
+	NoMusic Music = iota
+	MenuMusic
+	StageMusic
+	GameOverMusic
+	musicCount
+)
+
+// tuneFiles maps music to tune files, see Tune
+var tuneFiles = [musicCount]string{
+	MenuMusic:     "menu.tune",
+	StageMusic:    "stage.tune",
+	GameOverMusic: "gameover.tune",
+}
+
+const crossFadeDuration = time.Millisecond * 500
+
+var (
+	tunes        [musicCount]*Tune
+	songs        *beep.Mixer // of the music channel, songs fading in and out
+	song         *fader      // the playing one, nil if there is no music
+	wantedMusic  Music       // asked by PlayMusic, it waits for the startup jingle
+	playingMusic Music
+	musicOff     bool
+)
+
+// InitMusic loads tunes of music from files, music without a tune file is silent
+func InitMusic(files fs.FS) error {
+	for music, name := range tuneFiles {
+		if name == "" {
+			continue
+		}
+		f, err := files.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("sfx: %s is missing, the music is silent", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		tune, err := ParseTune(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		tunes[music] = tune
+	}
+	return nil
+}
+
+// PlayMusic cross-fades from the playing music to music, NoMusic fades it out.
+// The music starts when the startup jingle is over
+func PlayMusic(music Music) {
+	sink.Lock()
+	if music == wantedMusic {
+		sink.Unlock()
+		return
+	}
+	wantedMusic = music
+	done := startUpDone
+	sink.Unlock()
+
+	select {
+	case <-done:
+	default:
+		if done != nil {
+			go func() {
+				<-done
+				sink.Lock()
+				defer sink.Unlock()
+				if wantedMusic == music { // unless other music was asked meanwhile
+					crossFade(music)
+				}
+			}()
+			return
+		}
+	}
+	sink.Lock()
+	defer sink.Unlock()
+	crossFade(music)
+}
+
+// EnableMusic turns music on or off, sounds aren't affected
+func EnableMusic(on bool) {
+	sink.Lock()
+	defer sink.Unlock()
+	musicOff = !on
+	crossFade(wantedMusic)
+}
+
+// crossFade fades out the playing song and fades in the one of music. The sink must be locked
+func crossFade(music Music) {
+	next := music
+	if musicOff || tunes[music] == nil {
+		next = NoMusic
+	}
+	if next == playingMusic && song != nil {
+		return
+	}
+	if song != nil {
+		song.fadeTo(0)
+		song = nil
+	}
+	playingMusic = next
+	if next == NoMusic || songs == nil {
+		return
+	}
+	song = &fader{streamer: tunes[next].Streamer()}
+	song.fadeTo(1)
+	songs.Add(song)
+}
+
+// pauseMusic pauses or resumes the playing song. The sink must be locked
+func pauseMusic(paused bool) {
+	if song != nil {
+		song.paused = paused
+	}
+}
+
+// fader changes the gain of the streamer gradually, it drains when it's faded out
+type fader struct {
+	streamer beep.Streamer
+	gain     float64
+	target   float64
+	paused   bool
+}
+
+func (f *fader) fadeTo(gain float64) {
+	f.target = gain
+}
+
+func (f *fader) Stream(samples [][2]float64) (n int, ok bool) {
+	if f.paused {
+		for i := range samples {
+			samples[i] = [2]float64{}
+		}
+		return len(samples), true
+	}
+	if f.gain == 0 && f.target == 0 {
+		return 0, false
+	}
+	n, ok = f.streamer.Stream(samples)
+	delta := 1 / float64(sr.N(crossFadeDuration))
+	for i := range samples[:n] {
+		switch {
+		case f.gain < f.target:
+			f.gain = math.Min(f.gain+delta, f.target)
+		case f.gain > f.target:
+			f.gain = math.Max(f.gain-delta, f.target)
+		}
+		samples[i][0] *= f.gain
+		samples[i][1] *= f.gain
+	}
+	return n, ok
+}
+
+func (f *fader) Err() error {
+	return f.streamer.Err()
+}
@@ -1,79 +1,129 @@
 package sfx
 
 import (
-	"embed"
+	"errors"
 	"fmt"
 	"github.com/faiface/beep"
-	"github.com/faiface/beep/speaker"
+	"github.com/faiface/beep/effects"
 	"github.com/faiface/beep/wav"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"log"
 	"time"
 )
 
-var (
-	collection            map[string]*beep.Buffer
-	sr                    beep.SampleRate
-	tankIdleStream        *beep.Ctrl
-	tankMovingStream      *beep.Ctrl
-	startUpStream         beep.StreamSeeker
-	shootStream           beep.StreamSeeker
-	bonusAppearedStream   beep.StreamSeeker
-	bonusTakenLifeStream  beep.StreamSeeker
-	bonusTakenOtherStream beep.StreamSeeker
-	pauseStream           *streamSeekerCtrl
-	startUpDone           chan struct{}
+// Sound is a logical sound of the game, manifest maps it to a file
+type Sound int
+
+const (
+	StartUp Sound = iota
+	TankIdle
+	TankMoving
+	Pause
+	Shoot
+	BonusAppeared
+	BonusTakenLife
+	BonusTakenOther
+	ArmorHit
+	BotDestruction
+	PlayerDestruction
+	HQDestruction
+	BrickHit
+	SteelHit
+	GameOver
+	ScoreTally
+	soundsCount
 )
 
-func Init(files embed.FS) error {
-	dirEntries, _ := files.ReadDir("assets/sfx")
-	collection = make(map[string]*beep.Buffer)
-	for _, fileInfo := range dirEntries {
-		if fileInfo.IsDir() || filepath.Ext(fileInfo.Name()) != ".wav" {
-			continue
-		}
-		wavFile, err := os.Open(fmt.Sprintf("assets/sfx/%s", fileInfo.Name()))
-		if err != nil {
-			return err
-		}
+var soundNames = [soundsCount]string{
+	"StartUp", "TankIdle", "TankMoving", "Pause", "Shoot", "BonusAppeared", "BonusTakenLife", "BonusTakenOther",
+	"ArmorHit", "BotDestruction", "PlayerDestruction", "HQDestruction", "BrickHit", "SteelHit", "GameOver",
+	"ScoreTally",
+}
 
-		streamer, format, err := wav.Decode(wavFile)
-		if err != nil {
-			return err
-		}
-		buffer := beep.NewBuffer(format)
-		buffer.Append(streamer)
-		_ = streamer.Close()
-		collection[fileInfo.Name()] = buffer
+func (s Sound) String() string {
+	if s < 0 || s >= soundsCount {
+		return fmt.Sprintf("Sound(%d)", int(s))
 	}
+	return soundNames[s]
+}
 
-	sr = beep.SampleRate(44100)
-	_ = speaker.Init(sr, sr.N(time.Second/10))
+// manifest maps sounds to files of the sfx directory
+var manifest = [soundsCount]string{
+	StartUp:           "StartUp.wav",
+	TankIdle:          "Battle City SFX (8).wav",
+	TankMoving:        "Battle City SFX (9).wav",
+	Pause:             "Pause.wav",
+	Shoot:             "Shoot.wav",
+	BonusAppeared:     "BonusAppeared.wav",
+	BonusTakenLife:    "BonusTakenLife.wav",
+	BonusTakenOther:   "BonusTakenOther.wav",
+	ArmorHit:          "Battle City SFX (4).wav",
+	BotDestruction:    "BotDestruction.wav",
+	PlayerDestruction: "PlayerDestruction.wav",
+	HQDestruction:     "PlayerDestruction.wav",
+	BrickHit:          "Battle City SFX (2).wav",
+	SteelHit:          "Battle City SFX (1).wav",
+	GameOver:          "Battle City SFX (10).wav",
+	ScoreTally:        "Battle City SFX (3).wav",
+}
 
-	startUpStream = stream("StartUp.wav")
-	tankIdleStream = &beep.Ctrl{
-		Streamer: beep.Loop(-1, stream("TankIdle.wav")),
-		Paused:   true,
+var (
+	collection    [soundsCount]*beep.Buffer
+	sr            = beep.SampleRate(44100)
+	engines       [maxPlayers]*engine
+	startUpStream beep.StreamSeeker
+	pauseStream   *streamSeekerCtrl
+	startUpDone   chan struct{}
+	sink          AudioSink
+)
+
+// Init loads sounds of the manifest from files and starts playing them to the sink.
+// A missing file is replaced by silence with a logged warning, a broken one is an error.
+// Sounds are dropped if the sink fails to start, e.g. the speaker without a sound device
+func Init(files fs.FS, s AudioSink) error {
+	for sound, name := range manifest {
+		buffer, err := load(files, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("sfx: %s is missing, it's silent", name)
+			buffer = beep.NewBuffer(beep.Format{SampleRate: sr, NumChannels: 2, Precision: 2})
+			buffer.Append(beep.Silence(sr.N(time.Second / 10))) // not empty, so it can be looped
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		collection[sound] = buffer
 	}
-	tankMovingStream = &beep.Ctrl{
-		Streamer: beep.Loop(-1, stream("TankMoving.wav")),
-		Paused:   true,
+
+	master := newMixer()
+	if err := s.Start(sr, master); err != nil {
+		log.Printf("sfx: %v, sounds are dropped", err)
+		s = new(NullSink)
+	}
+	sink = s
+
+	startUpStream = stream(StartUp)
+	for i := range engines {
+		engines[i] = newEngine()
 	}
 	pauseStream = &streamSeekerCtrl{
-		streamer: stream("Pause.wav"),
+		streamer: stream(Pause),
 		Paused:   true,
 	}
-	shootStream = stream("Shoot.wav")
-	bonusAppearedStream = stream("BonusAppeared.wav")
-	bonusTakenLifeStream = stream("BonusTakenLife.wav")
-	bonusTakenOtherStream = stream("BonusTakenOther.wav")
 
 	return nil
 }
 
 func ResetForNewStage() {
-	speaker.Clear() // clear all Streamers
+	sink.Lock()
+	for _, ch := range channels[MusicChannel:] {
+		ch.mixer.Clear() // clear all Streamers
+	}
+	voices = nil
+	// the stage music starts again after the startup jingle, see PlayMusic
+	channels[MusicChannel].mixer.Add(songs)
+	crossFade(NoMusic)
+	wantedMusic = NoMusic
 	startUpDone = make(chan struct{})
+	sink.Unlock()
 	_ = startUpStream.Seek(0) // rewind startup stream to start
 	startUpStreamRewound := beep.Seq(
 		beep.Take(sr.N(time.Millisecond*4500), startUpStream),
@@ -81,90 +131,144 @@ func ResetForNewStage() {
 			close(startUpDone) // startup is done
 		}),
 	)
-	speaker.Play(startUpStreamRewound)
-	speaker.Play(pauseStream)
+	play(MusicChannel, startUpStreamRewound)
+	play(MusicChannel, pauseStream)
+	sink.Cue(StartUp)
 
 	go func() {
 		<-startUpDone // wait for startup is done
-		speaker.Play(tankIdleStream, tankMovingStream)
+		for _, e := range engines {
+			play(EffectsChannel, e.pan)
+		}
 	}()
 }
 
-func PlayTankMoving() {
-	if !tankMovingStream.Paused {
+// SetEngine switches the engine of the player between idle and moving, pan places it between the left (-1) and right (1) speaker.
+// Engines of players which never set it stay silent
+func SetEngine(player int, moving bool, pan float64) {
+	if player < 0 || player >= len(engines) {
 		return
 	}
-	speaker.Lock()
-	defer speaker.Unlock()
-	tankIdleStream.Paused = true
-	tankMovingStream.Paused = false
-}
-
-func PlayTankIdle() {
-	if !tankIdleStream.Paused {
+	e := engines[player]
+	sink.Lock()
+	defer sink.Unlock()
+	e.pan.Pan = pan
+	if e.playing(moving) {
 		return
 	}
-	speaker.Lock()
-	defer speaker.Unlock()
-	tankMovingStream.Paused = true
-	tankIdleStream.Paused = false
+	e.moving.Paused = !moving
+	e.idle.Paused = moving
+	if moving {
+		sink.Cue(TankMoving)
+	} else {
+		sink.Cue(TankIdle)
+	}
 }
 
-func PlayBotDestroyed() {
-	speaker.Play(beep.Take(sr.N(time.Millisecond*500), stream("BotDestruction.wav")))
+func PlayBotDestroyed(pan float64) {
+	playEffect(BotDestruction, pan)
 }
 
-func PlayPlayerDestroyed() {
-	speaker.Play(beep.Take(sr.N(time.Millisecond*500), stream("PlayerDestruction.wav")))
+func PlayPlayerDestroyed(pan float64) {
+	playEffect(PlayerDestruction, pan)
 }
 
-func PlayHQDestroyed() {
-	PlayPlayerDestroyed()
+// PlayHQDestroyed preempts all other effects
+func PlayHQDestroyed(pan float64) {
+	playEffect(HQDestruction, pan)
 }
 
-func PlayShoot() {
-	speaker.Lock()
-	_ = shootStream.Seek(0)
-	speaker.Unlock()
-	speaker.Play(beep.Take(sr.N(time.Millisecond*100), shootStream))
+func PlayShoot(pan float64) {
+	playEffect(Shoot, pan)
 }
+
 func PlayBonusAppeared() {
-	speaker.Lock()
-	_ = bonusAppearedStream.Seek(0)
-	speaker.Unlock()
-	speaker.Play(beep.Take(sr.N(time.Millisecond*500), bonusAppearedStream))
+	playEffect(BonusAppeared, 0)
 }
 
 func PlayBonusTakenLife() {
-	speaker.Lock()
-	_ = bonusTakenLifeStream.Seek(0)
-	speaker.Unlock()
-	speaker.Play(beep.Take(sr.N(time.Second), bonusTakenLifeStream))
+	playEffect(BonusTakenLife, 0)
 }
 
 func PlayBonusTakenOther() {
-	speaker.Lock()
-	_ = bonusTakenOtherStream.Seek(0)
-	speaker.Unlock()
-	speaker.Play(beep.Take(sr.N(time.Millisecond*700), bonusTakenOtherStream))
+	playEffect(BonusTakenOther, 0)
+}
+
+func PlayArmorHit(pan float64) {
+	playEffect(ArmorHit, pan)
+}
+
+func PlayBrickHit(pan float64) {
+	playEffect(BrickHit, pan)
+}
+
+func PlaySteelHit(pan float64) {
+	playEffect(SteelHit, pan)
+}
+
+func PlayGameOver() {
+	play(MusicChannel, beep.Take(sr.N(time.Millisecond*500), stream(GameOver)))
+	sink.Cue(GameOver)
+}
+
+// PlayScoreTally plays a tick of counting the score
+func PlayScoreTally() {
+	playEffect(ScoreTally, 0)
 }
 
 func PlayPause() {
-	speaker.Lock()
-	defer speaker.Unlock()
+	sink.Lock()
+	defer sink.Unlock()
 	_ = pauseStream.Seek(0)
 	pauseStream.Paused = false
-	tankMovingStream.Paused = true
-	tankIdleStream.Paused = true
+	pauseMusic(true)
+	for _, e := range engines {
+		e.stop()
+	}
+	sink.Cue(Pause)
 }
 
 func StopPause() {
-	speaker.Lock()
-	defer speaker.Unlock()
+	sink.Lock()
+	defer sink.Unlock()
 	pauseStream.Paused = true
+	pauseMusic(false)
+}
+
+// panned places s between the left (-1) and right (1) speaker
+func panned(pan float64, s beep.Streamer) beep.Streamer {
+	return &effects.Pan{Streamer: s, Pan: pan}
 }
 
-func stream(name string) beep.StreamSeeker {
-	buffer := collection[name]
+func stream(sound Sound) beep.StreamSeeker {
+	buffer := collection[sound]
 	return buffer.Streamer(0, buffer.Len())
 }
+
+// load decodes the wav file at path of files, it's resampled to the sample rate of the speaker
+func load(files fs.FS, path string) (*beep.Buffer, error) {
+	f, err := files.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	streamer, format, err := wav.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+	var resampled beep.Streamer = streamer
+	if format.SampleRate != sr {
+		resampled = beep.Resample(4, format.SampleRate, sr, streamer)
+		format.SampleRate = sr
+	}
+	buffer := beep.NewBuffer(format)
+	buffer.Append(resampled)
+	if err := streamer.Err(); err != nil {
+		return nil, err
+	}
+	if buffer.Len() == 0 {
+		return nil, errors.New("no samples")
+	}
+	return buffer, nil
+}
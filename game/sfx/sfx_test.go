@@ -0,0 +1,126 @@
+package sfx
+
+import (
+	"battlecity/assets"
+	"github.com/faiface/beep"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testFiles returns the built-in sound and tune files, files are changed by replacing them
+func testFiles(t *testing.T, builtIn fs.FS, files map[string]string) fstest.MapFS {
+	t.Helper()
+	mapFS := make(fstest.MapFS)
+	names, err := fs.Glob(builtIn, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(builtIn, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapFS[name] = &fstest.MapFile{Data: data}
+	}
+	for name, data := range files {
+		if data == "" {
+			delete(mapFS, name)
+		} else {
+			mapFS[name] = &fstest.MapFile{Data: []byte(data)}
+		}
+	}
+	return mapFS
+}
+
+func TestInitFallbacks(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string // "" removes the file
+		silent []Sound
+		err    string
+	}{
+		{"built-in", nil, nil, ""},
+		{"missing", map[string]string{manifest[Shoot]: ""}, []Sound{Shoot}, ""},
+		{"shared file missing", map[string]string{manifest[PlayerDestruction]: ""}, []Sound{PlayerDestruction, HQDestruction}, ""},
+		{"broken", map[string]string{manifest[Shoot]: "not a wav"}, nil, manifest[Shoot]},
+	}
+	for _, test := range tests {
+		err := Init(testFiles(t, assets.Sfx, test.files), new(NullSink))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		for sound := Sound(0); sound < soundsCount; sound++ {
+			silent := false
+			for _, s := range test.silent {
+				silent = silent || s == sound
+			}
+			buffer := collection[sound]
+			if got := buffer.Len() == sr.N(time.Second/10) && isSilent(buffer.Streamer(0, buffer.Len())); got != silent {
+				t.Errorf("%s: %s is silent: %v, want %v", test.name, sound, got, silent)
+			}
+		}
+		PlayShoot(0) // silence plays like a sound
+	}
+}
+
+func TestInitMusicFallbacks(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string // "" removes the file
+		silent []Music
+		err    string
+	}{
+		{"built-in", nil, nil, ""},
+		{"missing", map[string]string{tuneFiles[StageMusic]: ""}, []Music{StageMusic}, ""},
+		{"broken", map[string]string{tuneFiles[MenuMusic]: "tempo fast"}, nil, tuneFiles[MenuMusic]},
+	}
+	for _, test := range tests {
+		tunes = [musicCount]*Tune{}
+		err := InitMusic(testFiles(t, assets.Music, test.files))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		for music := MenuMusic; music < musicCount; music++ {
+			silent := false
+			for _, m := range test.silent {
+				silent = silent || m == music
+			}
+			if (tunes[music] == nil) != silent {
+				t.Errorf("%s: music %d is silent: %v, want %v", test.name, music, tunes[music] == nil, silent)
+			}
+		}
+	}
+}
+
+// isSilent reports whether all samples of s are zero
+func isSilent(s beep.Streamer) bool {
+	samples := make([][2]float64, 512)
+	for {
+		n, ok := s.Stream(samples)
+		for _, sample := range samples[:n] {
+			if sample != [2]float64{} {
+				return false
+			}
+		}
+		if !ok {
+			return true
+		}
+	}
+}
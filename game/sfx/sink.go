@@ -0,0 +1,111 @@
+package sfx
+
+import (
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/wav"
+	"io"
+	"sync"
+	"time"
+)
+
+// AudioSink is an output of sounds. It plays the mixdown of all sounds and is told about every played sound
+type AudioSink interface {
+	// Start plays the mixdown streamed at the sample rate
+	Start(sr beep.SampleRate, mixdown beep.Streamer) error
+	// Lock and Unlock guard changes of streamers while the mixdown is streamed
+	Lock()
+	Unlock()
+	// Cue is called when the sound starts playing
+	Cue(sound Sound)
+}
+
+// NullSink drops sounds, e.g. when there is no sound device
+type NullSink struct {
+	mu sync.Mutex
+}
+
+func (s *NullSink) Start(beep.SampleRate, beep.Streamer) error {
+	return nil
+}
+
+func (s *NullSink) Lock() {
+	s.mu.Lock()
+}
+
+func (s *NullSink) Unlock() {
+	s.mu.Unlock()
+}
+
+func (s *NullSink) Cue(Sound) {}
+
+// tickCounter is a sink which counts ticks, like Recorder
+type tickCounter interface {
+	SetTick(tick int)
+}
+
+// SetTick tells the sink the tick of the next sounds, sinks which don't count ticks ignore it
+func SetTick(tick int) {
+	if counter, ok := sink.(tickCounter); ok {
+		counter.SetTick(tick)
+	}
+}
+
+// Cue is a sound played on a tick
+type Cue struct {
+	Tick  int
+	Sound Sound
+}
+
+// Recorder keeps played sounds instead of playing them, the mixdown can be written to a WAV file.
+// Ticks of sounds are told by the game with SetTick
+type Recorder struct {
+	NullSink
+	sr      beep.SampleRate
+	mixdown beep.Streamer
+	tick    int
+	cues    []Cue
+}
+
+func (r *Recorder) Start(sr beep.SampleRate, mixdown beep.Streamer) error {
+	r.sr = sr
+	r.mixdown = mixdown
+	return nil
+}
+
+func (r *Recorder) Cue(sound Sound) {
+	r.cues = append(r.cues, Cue{Tick: r.tick, Sound: sound})
+}
+
+// SetTick sets the tick of the next cues
+func (r *Recorder) SetTick(tick int) {
+	r.tick = tick
+}
+
+// Cues returns played sounds in order
+func (r *Recorder) Cues() []Cue {
+	return r.cues
+}
+
+// Count returns how many times the sound was played
+func (r *Recorder) Count(sound Sound) int {
+	n := 0
+	for _, cue := range r.cues {
+		if cue.Sound == sound {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset forgets played sounds
+func (r *Recorder) Reset() {
+	r.cues = nil
+}
+
+// WriteMixdown streams the next d of the mixdown and writes it to w as a WAV file
+func (r *Recorder) WriteMixdown(w io.WriteSeeker, d time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	format := beep.Format{SampleRate: r.sr, NumChannels: 2, Precision: 2}
+	return wav.Encode(w, beep.Take(r.sr.N(d), r.mixdown), format)
+}
@@ -0,0 +1,69 @@
+package sfx
+
+import (
+	"battlecity/assets"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initTestRecorder plays sounds of the game to a new recorder
+func initTestRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	recorder := new(Recorder)
+	if err := Init(assets.Sfx, recorder); err != nil {
+		t.Fatal(err)
+	}
+	return recorder
+}
+
+func TestRecorderCues(t *testing.T) {
+	recorder := initTestRecorder(t)
+	SetTick(10)
+	PlayShoot(0)
+	PlayBotDestroyed(-1)
+	SetTick(12)
+	SetEngine(0, true, 0)
+	SetEngine(0, true, 0.5) // the engine is moving already
+	PlayGameOver()
+
+	want := []Cue{{10, Shoot}, {10, BotDestruction}, {12, TankMoving}, {12, GameOver}}
+	cues := recorder.Cues()
+	if len(cues) != len(want) {
+		t.Fatalf("cues are %v, want %v", cues, want)
+	}
+	for i := range want {
+		if cues[i] != want[i] {
+			t.Errorf("cue %d is %v, want %v", i, cues[i], want[i])
+		}
+	}
+	if n := recorder.Count(Shoot); n != 1 {
+		t.Errorf("Shoot is played %d times, want 1", n)
+	}
+	recorder.Reset()
+	if len(recorder.Cues()) != 0 {
+		t.Errorf("cues after Reset are %v", recorder.Cues())
+	}
+}
+
+func TestRecorderWriteMixdown(t *testing.T) {
+	recorder := initTestRecorder(t)
+	PlayShoot(0)
+	f, err := os.Create(filepath.Join(t.TempDir(), "mixdown.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := recorder.WriteMixdown(f, time.Second/10); err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 16 bit stereo samples after the header
+	if want := int64(sr.N(time.Second/10) * 4); info.Size() < want {
+		t.Errorf("mixdown is %d bytes, want at least %d", info.Size(), want)
+	}
+}
@@ -0,0 +1,278 @@
+package sfx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/faiface/beep"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tune is music sequenced for channels of a square wave synthesizer like the one of NES. A tune file has lines
+//
+//	tempo 150           beats per minute up to maxTempo, a beat is 4 steps
+//	loop 16             the step the tune goes back to after its end, the tune ends if there is no loop
+//	pulse1 C4 . E4 -    steps of the channel, lines of the same channel are joined
+//
+// Channels are pulse1 and pulse2 (square waves), triangle and noise. A step is a note like C4, C#4 or Db4,
+// "." holds the previous note and "-" is silence. Notes of noise are hits, their pitch is the noise rate.
+// Text after ; is a comment
+type Tune struct {
+	tempo    float64
+	loop     int // step, -1 if the tune doesn't loop
+	channels [chipChannelsCount][]chipStep
+	length   int // in steps, of the longest channel
+}
+
+type chipChannel int
+
+const (
+	pulse1 chipChannel = iota
+	pulse2
+	triangle
+	noise
+	chipChannelsCount
+)
+
+var chipChannelNames = [chipChannelsCount]string{"pulse1", "pulse2", "triangle", "noise"}
+
+// chipVolumes are levels of channels in the mix
+var chipVolumes = [chipChannelsCount]float64{0.12, 0.1, 0.25, 0.08}
+
+// maxTempo keeps steps long enough to be heard, a step is at least hundreds of samples
+const maxTempo = 1000
+
+// noiseRate is how many times per period of its note the noise register is clocked
+const noiseRate = 16
+
+// chipStep is a step of a channel, freq is 0 for silence
+type chipStep struct {
+	freq float64
+	hold bool // the note of the previous step goes on
+}
+
+var noteOffsets = map[byte]int{'C': -9, 'D': -7, 'E': -5, 'F': -4, 'G': -2, 'A': 0, 'B': 2}
+
+// ParseTune reads a tune file, see Tune
+func ParseTune(r io.Reader) (*Tune, error) {
+	t := &Tune{loop: -1}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := t.parseLine(fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case t.tempo <= 0:
+		return nil, errors.New("no tempo")
+	case t.length == 0:
+		return nil, errors.New("no steps")
+	case t.loop >= t.length:
+		return nil, fmt.Errorf("loop %d is after the end at %d", t.loop, t.length)
+	}
+	return t, nil
+}
+
+func (t *Tune) parseLine(fields []string) error {
+	switch fields[0] {
+	case "tempo", "loop":
+		if len(fields) != 2 {
+			return fmt.Errorf("%s needs one value", fields[0])
+		}
+		value, err := strconv.Atoi(fields[1])
+		if err != nil || value < 0 {
+			return fmt.Errorf("bad %s %q", fields[0], fields[1])
+		}
+		if fields[0] == "tempo" && value > maxTempo {
+			return fmt.Errorf("tempo %d is over %d", value, maxTempo)
+		}
+		if fields[0] == "tempo" {
+			t.tempo = float64(value)
+		} else {
+			t.loop = value
+		}
+		return nil
+	}
+	for ch, name := range chipChannelNames {
+		if name != fields[0] {
+			continue
+		}
+		for _, token := range fields[1:] {
+			step, err := parseStep(token)
+			if err != nil {
+				return err
+			}
+			t.channels[ch] = append(t.channels[ch], step)
+		}
+		if len(t.channels[ch]) > t.length {
+			t.length = len(t.channels[ch])
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown channel or command %q", fields[0])
+}
+
+func parseStep(token string) (chipStep, error) {
+	switch token {
+	case ".":
+		return chipStep{hold: true}, nil
+	case "-":
+		return chipStep{}, nil
+	}
+	offset, ok := noteOffsets[token[0]]
+	rest := token[1:]
+	if ok && len(rest) > 1 {
+		switch rest[0] {
+		case '#':
+			offset++
+			rest = rest[1:]
+		case 'b':
+			offset--
+			rest = rest[1:]
+		}
+	}
+	octave, err := strconv.Atoi(rest)
+	if !ok || err != nil || octave < 0 || octave > 8 {
+		return chipStep{}, fmt.Errorf("bad note %q", token)
+	}
+	// A4 is 440 Hz, an octave is 12 semitones
+	semitones := offset + (octave-4)*12
+	return chipStep{freq: 440 * math.Pow(2, float64(semitones)/12)}, nil
+}
+
+// Streamer synthesizes the tune from the start
+func (t *Tune) Streamer() beep.Streamer {
+	return &tuneStreamer{tune: t, stepLen: int(float64(sr) * 60 / t.tempo / 4), lfsr: 1}
+}
+
+// tuneStreamer plays a Tune, it drains at the end of a tune without a loop
+type tuneStreamer struct {
+	tune    *Tune
+	stepLen int // in samples
+	step    int
+	pos     int // in samples from the start of the step
+	notes   [chipChannelsCount]chipStep
+	ages    [chipChannelsCount]int // samples from the start of the note
+	phases  [chipChannelsCount]float64
+	lfsr    uint16 // noise shift register
+}
+
+func (s *tuneStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		if s.pos == 0 && !s.startStep() {
+			return n, n > 0
+		}
+		var value float64
+		for ch := range s.notes {
+			value += s.sample(chipChannel(ch)) * chipVolumes[ch]
+		}
+		samples[i] = [2]float64{value, value}
+		n++
+		s.pos++
+		if s.pos == s.stepLen {
+			s.pos = 0
+			s.step++
+		}
+	}
+	return n, true
+}
+
+// startStep takes notes of the current step, it returns false at the end of the tune
+func (s *tuneStreamer) startStep() bool {
+	if s.step >= s.tune.length {
+		if s.tune.loop < 0 {
+			return false
+		}
+		s.step = s.tune.loop
+	}
+	for ch, steps := range s.tune.channels {
+		step := chipStep{}
+		if s.step < len(steps) {
+			step = steps[s.step]
+		}
+		if step.hold {
+			continue
+		}
+		s.notes[ch] = step
+		s.ages[ch] = 0
+	}
+	return true
+}
+
+// sample returns the next sample of the channel from -1 to 1
+func (s *tuneStreamer) sample(ch chipChannel) float64 {
+	note := s.notes[ch]
+	if note.freq == 0 {
+		return 0
+	}
+	age := s.ages[ch]
+	s.ages[ch]++
+	inc := note.freq / float64(sr)
+	if ch == noise {
+		inc *= noiseRate
+	}
+	s.phases[ch] = math.Mod(s.phases[ch]+inc, 1)
+	phase := s.phases[ch]
+
+	var value float64
+	switch ch {
+	case pulse1, pulse2:
+		duty := 0.5
+		if ch == pulse2 {
+			duty = 0.25
+		}
+		value = -1
+		if phase < duty {
+			value = 1
+		}
+	case triangle:
+		value = 4*math.Abs(phase-0.5) - 1
+	case noise:
+		// the register is clocked at the pitch of the note, hits decay in a few steps
+		if phase < inc {
+			bit := (s.lfsr ^ s.lfsr>>1) & 1
+			s.lfsr = s.lfsr>>1 | bit<<14
+		}
+		value = float64(s.lfsr&1)*2 - 1
+		value *= math.Exp(-float64(age) / float64(s.stepLen))
+	}
+	return value * s.envelope(ch, age)
+}
+
+// envelope softens starts and ends of notes, so they don't click
+func (s *tuneStreamer) envelope(ch chipChannel, age int) float64 {
+	const ramp = 64 // samples
+	level := 1.0
+	if age < ramp {
+		level = float64(age) / ramp
+	}
+	steps := s.tune.channels[ch]
+	next := s.step + 1
+	if next >= s.tune.length && s.tune.loop >= 0 {
+		next = s.tune.loop
+	}
+	if next >= len(steps) || !steps[next].hold {
+		if left := s.stepLen - s.pos; left < ramp {
+			level = math.Min(level, float64(left)/ramp)
+		}
+	}
+	return level
+}
+
+func (s *tuneStreamer) Err() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package sfx
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func parseTestTune(t *testing.T, text string) *Tune {
+	t.Helper()
+	tune, err := ParseTune(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tune
+}
+
+func TestParseTuneNotes(t *testing.T) {
+	tests := []struct {
+		note string
+		freq float64
+	}{
+		{"A4", 440},
+		{"A5", 880},
+		{"A0", 27.5},
+		{"C4", 261.63},
+		{"C#4", 277.18},
+		{"Db4", 277.18},
+		{"Bb3", 233.08},
+		{"B#3", 261.63},
+		{"Cb5", 493.88},
+	}
+	for _, test := range tests {
+		tune := parseTestTune(t, "tempo 120\npulse1 "+test.note)
+		if freq := tune.channels[pulse1][0].freq; math.Abs(freq-test.freq) > 0.01 {
+			t.Errorf("%s is %.2f Hz, want %.2f", test.note, freq, test.freq)
+		}
+	}
+}
+
+func TestParseTuneSteps(t *testing.T) {
+	tune := parseTestTune(t, `
+; a comment
+tempo 150
+loop 2
+pulse1   C4 . - E4 ; joined with the next line
+pulse1   .
+triangle C3 -
+`)
+	if tune.tempo != 150 || tune.loop != 2 || tune.length != 5 {
+		t.Errorf("tempo %v, loop %d, length %d, want 150, 2, 5", tune.tempo, tune.loop, tune.length)
+	}
+	pulse := tune.channels[pulse1]
+	wantHolds := []bool{false, true, false, false, true}
+	if len(pulse) != len(wantHolds) {
+		t.Fatalf("pulse1 has %d steps, want %d", len(pulse), len(wantHolds))
+	}
+	for i, step := range pulse {
+		if step.hold != wantHolds[i] {
+			t.Errorf("step %d: hold %v, want %v", i, step.hold, wantHolds[i])
+		}
+	}
+	if pulse[2].freq != 0 || pulse[2].hold {
+		t.Errorf("step 2 is %+v, want silence", pulse[2])
+	}
+	if len(tune.channels[triangle]) != 2 || len(tune.channels[noise]) != 0 {
+		t.Errorf("triangle has %d steps and noise %d, want 2 and 0", len(tune.channels[triangle]), len(tune.channels[noise]))
+	}
+}
+
+func TestParseTuneErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"no tempo", "pulse1 C4", "no tempo"},
+		{"no steps", "tempo 120", "no steps"},
+		{"tempo", "tempo fast\npulse1 C4", "bad tempo"},
+		{"tempo over max", "tempo 1001\npulse1 C4", "over"},
+		{"loop past the end", "tempo 120\nloop 2\npulse1 C4 .", "after the end"},
+		{"loop at the end", "tempo 120\nloop 1\npulse1 C4", "after the end"},
+		{"unknown channel", "tempo 120\nsquare C4", "unknown channel"},
+		{"note", "tempo 120\npulse1 H4", "bad note"},
+		{"octave", "tempo 120\npulse1 C9", "bad note"},
+		{"sharp without octave", "tempo 120\npulse1 C#", "bad note"},
+	}
+	for _, test := range tests {
+		_, err := ParseTune(strings.NewReader(test.text))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestTuneStreamerLength(t *testing.T) {
+	tune := parseTestTune(t, "tempo 1000\npulse1 C4 . - E4")
+	streamer := tune.Streamer()
+	stepLen := streamer.(*tuneStreamer).stepLen
+	if stepLen < 1 {
+		t.Fatalf("step is %d samples", stepLen)
+	}
+	samples := make([][2]float64, 512)
+	total := 0
+	for {
+		n, ok := streamer.Stream(samples)
+		total += n
+		if !ok {
+			break
+		}
+	}
+	if total != 4*stepLen {
+		t.Errorf("%d samples are streamed, want %d", total, 4*stepLen)
+	}
+}
@@ -0,0 +1,136 @@
+package sfx
+
+import (
+	"github.com/faiface/beep"
+	"time"
+)
+
+// maxVoices is how many effects may play at once
+const maxVoices = 6
+
+// voiceRule limits how a sound is played, see startVoice
+type voiceRule struct {
+	polyphony int           // voices of the sound which may play at once
+	priority  int           // voices of a lower priority give way to the sound
+	preempts  bool          // the sound stops voices of a lower priority and keeps them silent while it plays
+	duration  time.Duration // of the played part of the file
+}
+
+var voiceRules = [soundsCount]voiceRule{
+	Shoot:             {polyphony: 2, priority: 1, duration: time.Millisecond * 100},
+	BrickHit:          {polyphony: 2, priority: 1, duration: time.Millisecond * 150},
+	SteelHit:          {polyphony: 2, priority: 1, duration: time.Millisecond * 100},
+	ScoreTally:        {polyphony: 1, priority: 1, duration: time.Millisecond * 200},
+	ArmorHit:          {polyphony: 2, priority: 2, duration: time.Millisecond * 100},
+	BonusAppeared:     {polyphony: 1, priority: 3, duration: time.Millisecond * 500},
+	BonusTakenOther:   {polyphony: 1, priority: 3, duration: time.Millisecond * 700},
+	BotDestruction:    {polyphony: 1, priority: 3, duration: time.Millisecond * 500},
+	BonusTakenLife:    {polyphony: 1, priority: 4, duration: time.Second},
+	PlayerDestruction: {polyphony: 1, priority: 4, duration: time.Millisecond * 500},
+	HQDestruction:     {polyphony: 1, priority: 5, preempts: true, duration: time.Millisecond * 500},
+}
+
+// voice is a playing sound. It drains when the sound is over or the voice is stopped
+type voice struct {
+	streamer beep.Streamer
+	sound    Sound
+	stopped  bool
+	drained  bool
+}
+
+func (v *voice) Stream(samples [][2]float64) (n int, ok bool) {
+	if v.stopped {
+		return 0, false
+	}
+	n, ok = v.streamer.Stream(samples)
+	if !ok {
+		v.drained = true
+	}
+	return n, ok
+}
+
+func (v *voice) Err() error {
+	return v.streamer.Err()
+}
+
+func (v *voice) playing() bool {
+	return !v.stopped && !v.drained
+}
+
+// voices are effects which are playing, oldest first. They're guarded by the sink
+var voices []*voice
+
+// startVoice returns a voice of the sound if it may play. To make room, it stops the oldest voice of the sound
+// over its polyphony, or the oldest voice of a lower priority over maxVoices. The sink must be locked
+func startVoice(sound Sound, s beep.Streamer) *voice {
+	rule := voiceRules[sound]
+	active := voices[:0]
+	for _, v := range voices {
+		if v.playing() {
+			active = append(active, v)
+		}
+	}
+	voices = active
+
+	same := 0
+	for _, v := range voices {
+		if voiceRules[v.sound].preempts && voiceRules[v.sound].priority > rule.priority {
+			return nil
+		}
+		if v.sound == sound {
+			same++
+		}
+	}
+	for _, v := range voices {
+		if v.sound == sound && same >= rule.polyphony {
+			v.stopped = true
+			same--
+		}
+		if rule.preempts && voiceRules[v.sound].priority < rule.priority {
+			v.stopped = true
+		}
+	}
+	if !stopLowest(rule.priority) {
+		return nil
+	}
+	v := &voice{streamer: s, sound: sound}
+	voices = append(voices, v)
+	return v
+}
+
+// stopLowest stops voices until fewer than maxVoices are playing, the oldest ones of the lowest priority first.
+// It returns false if a voice of a priority lower than priority can't be found to make room
+func stopLowest(priority int) bool {
+	for {
+		playing := 0
+		var lowest *voice
+		for _, v := range voices {
+			if !v.playing() {
+				continue
+			}
+			playing++
+			if lowest == nil || voiceRules[v.sound].priority < voiceRules[lowest.sound].priority {
+				lowest = v
+			}
+		}
+		if playing < maxVoices {
+			return true
+		}
+		if voiceRules[lowest.sound].priority >= priority {
+			return false
+		}
+		lowest.stopped = true
+	}
+}
+
+// playEffect plays the sound on the effects channel under voice rules, pan places it between the speakers
+func playEffect(sound Sound, pan float64) {
+	sink.Lock()
+	defer sink.Unlock()
+	v := startVoice(sound, panned(pan, beep.Take(sr.N(voiceRules[sound].duration), stream(sound))))
+	if v == nil {
+		return
+	}
+	channels[EffectsChannel].mixer.Add(v)
+	sink.Cue(sound)
+}
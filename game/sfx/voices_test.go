@@ -0,0 +1,47 @@
+package sfx
+
+import (
+	"fmt"
+	"github.com/faiface/beep"
+	"testing"
+)
+
+func TestStartVoice(t *testing.T) {
+	tests := []struct {
+		name     string
+		sounds   []Sound // started in order
+		playing  []Sound // oldest first
+		rejected bool    // the last sound isn't played
+	}{
+		{"polyphony", []Sound{Shoot, BrickHit, Shoot, Shoot},
+			[]Sound{BrickHit, Shoot, Shoot}, false},
+		{"oldest of the lowest priority", []Sound{ArmorHit, Shoot, BrickHit, ArmorHit, SteelHit, BrickHit, BotDestruction},
+			[]Sound{ArmorHit, BrickHit, ArmorHit, SteelHit, BrickHit, BotDestruction}, false},
+		{"lower priority", []Sound{BonusAppeared, BonusTakenOther, BotDestruction, BonusTakenLife, PlayerDestruction, ArmorHit, Shoot},
+			[]Sound{BonusAppeared, BonusTakenOther, BotDestruction, BonusTakenLife, PlayerDestruction, ArmorHit}, true},
+		{"same priority", []Sound{Shoot, Shoot, BrickHit, BrickHit, SteelHit, SteelHit, ScoreTally},
+			[]Sound{Shoot, Shoot, BrickHit, BrickHit, SteelHit, SteelHit}, true},
+		{"preempting", []Sound{Shoot, BotDestruction, HQDestruction, ArmorHit},
+			[]Sound{HQDestruction}, true},
+	}
+	for _, test := range tests {
+		voices = nil
+		var v *voice
+		for _, sound := range test.sounds {
+			v = startVoice(sound, beep.Silence(-1))
+		}
+		var playing []Sound
+		for _, v := range voices {
+			if v.playing() {
+				playing = append(playing, v.sound)
+			}
+		}
+		if fmt.Sprint(playing) != fmt.Sprint(test.playing) {
+			t.Errorf("%s: %v are playing, want %v", test.name, playing, test.playing)
+		}
+		if rejected := v == nil; rejected != test.rejected {
+			t.Errorf("%s: the last sound is rejected: %v, want %v", test.name, rejected, test.rejected)
+		}
+	}
+	voices = nil
+}
@@ -1,9 +1,8 @@
-package game
+package sim
 
 import (
 	"battlecity/game/utils"
 	"github.com/faiface/pixel"
-	"github.com/faiface/pixel/imdraw"
 )
 
 const (
@@ -17,22 +16,24 @@ const (
 	BlockSize   = 8.0
 )
 
+// SecondHQBlock marks HQ of the second team in arena files, it's loaded as HQBlock
+const SecondHQBlock = "H"
+
 var (
 	fullQuadrants  = [2][2]bool{{true, true}, {true, true}}
 	emptyQuadrants = [2][2]bool{{false, false}, {false, false}}
 )
 
 type Block struct {
-	kind           string
-	row            int
-	column         int
-	destroyable    bool // can Bullet destroy it
-	passable       bool // can Tank pass through it
-	shootable      bool // can Bullet pass through it
-	bonus          bool // can Bonus appears on it
-	pos            pixel.Vec
-	quadrants      [2][2]bool
-	quadrantIMDraw [2][2]*imdraw.IMDraw
+	kind        string
+	row         int
+	column      int
+	destroyable bool // can Bullet destroy it
+	passable    bool // can Tank pass through it
+	shootable   bool // can Bullet pass through it
+	bonus       bool // can Bonus appears on it
+	pos         pixel.Vec
+	quadrants   [2][2]bool // parts of a destroyable block which are left, [x][y] from the bottom left one
 }
 
 func Border(pos pixel.Vec, row, column int) *Block {
@@ -46,7 +47,7 @@ func Border(pos pixel.Vec, row, column int) *Block {
 	return block
 }
 
-func Brick(pos pixel.Vec, row, column int, shiftX, shiftY float64) *Block {
+func Brick(pos pixel.Vec, row, column int) *Block {
 	block := new(Block)
 	block.row, block.column, block.pos = row, column, pos
 	block.kind = BrickBlock
@@ -55,7 +56,6 @@ func Brick(pos pixel.Vec, row, column int, shiftX, shiftY float64) *Block {
 	block.shootable = false
 	block.bonus = true
 	block.quadrants = fullQuadrants
-	block.InitQuadrants(shiftX, shiftY)
 	return block
 }
 
@@ -115,38 +115,46 @@ func Space(pos pixel.Vec, row, column int) *Block {
 	return block
 }
 
+// NewBlock creates a block of the kind, returns nil for unknown kinds
+func NewBlock(kind string, pos pixel.Vec, row, column int) *Block {
+	switch kind {
+	case BorderBlock:
+		return Border(pos, row, column)
+	case BrickBlock:
+		return Brick(pos, row, column)
+	case SteelBlock:
+		return Steel(pos, row, column)
+	case WaterBlock:
+		return Water(pos, row, column)
+	case HQBlock:
+		return HQ(pos, row, column)
+	case TreesBlock:
+		return Trees(pos, row, column)
+	case SpaceBlock:
+		return Space(pos, row, column)
+	}
+	return nil
+}
+
 func (b *Block) IsDestroyed() bool {
 	return b.quadrants == emptyQuadrants
 }
 
-func (b *Block) InitQuadrants(shiftX, shiftY float64) {
-	if !b.destroyable {
-		return
-	}
-	var quadrantRects [2][2]pixel.Rect
-	for i := 0; i < 2; i++ {
-		for j := 0; j < 2; j++ {
-			r := pixel.R(b.pos.X-shiftX, b.pos.Y-shiftY, b.pos.X, b.pos.Y)
-			r = r.Moved(pixel.V(shiftX*float64(i), shiftY*float64(j)))
-			quadrantRects[i][j] = r
-		}
-	}
-	for i, rectsI := range quadrantRects {
-		for j, rect := range rectsI {
-			imd := imdraw.New(nil)
-			imd.Color = pixel.RGB(0, 0, 0)
-			imd.Push(rect.Min, rect.Max)
-			imd.Rectangle(0)
-			b.quadrantIMDraw[i][j] = imd
-		}
-	}
+func (b *Block) Kind() string {
+	return b.kind
 }
 
-func (b *Block) QuadrantIMDraw(i, j int) *imdraw.IMDraw {
-	if !b.quadrants[i][j] {
-		return b.quadrantIMDraw[i][j]
-	}
-	return nil
+func (b *Block) Pos() pixel.Vec {
+	return b.pos
+}
+
+func (b *Block) IsDestroyable() bool {
+	return b.destroyable
+}
+
+// Quadrants returns parts of the block which are left, see Block.quadrants
+func (b *Block) Quadrants() [2][2]bool {
+	return b.quadrants
 }
 
 func (b *Block) ProcessCollision(bullet *Bullet, sb *Block) {
@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"github.com/faiface/pixel"
+	"math/rand"
+	"time"
+)
+
+type BonusType int
+
+const (
+	BonusSize  = 16
+	BonusScore = 500
+)
+
+const (
+	ImmunityBonus BonusType = iota
+	TimeStopBonus
+	HQArmorBonus
+	UpgradeBonus
+	AnnihilationBonus
+	LifeBonus
+	ShipBonus
+)
+
+const (
+	bonusLifetime     = time.Second * 20
+	bonusBlinkingTime = time.Second * 3
+)
+
+type Bonus struct {
+	pos       pixel.Vec
+	bonusType BonusType
+	duration  time.Duration
+}
+
+// NewBonus places a random bonus on a cell reachable by players, but not under any of them.
+// Returns nil if there is no such cell
+func NewBonus(stage *Stage, playersPos []pixel.Vec, rnd *rand.Rand) *Bonus {
+	var reachable [StageRows][StageColumns]bool
+	for _, pos := range playersPos {
+		for _, cell := range stage.ReachableCells(pos) {
+			reachable[cell[0]][cell[1]] = true
+		}
+	}
+	var cells [][2]int
+	for row := range reachable {
+		for column := range reachable[row] {
+			if reachable[row][column] && !isUnderPlayer(row, column, playersPos) {
+				cells = append(cells, [2]int{row, column})
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+	bonusType := BonusType(rnd.Intn(len(bonusEffects)))
+	cell := cells[rnd.Intn(len(cells))]
+	return newBonus(bonusType, TankCellPos(cell[0], cell[1]))
+}
+
+func newBonus(bonusType BonusType, pos pixel.Vec) *Bonus {
+	bonus := new(Bonus)
+	bonus.bonusType = bonusType
+	bonus.pos = pos
+	return bonus
+}
+
+// isUnderPlayer reports whether bonus at tank cell would be taken immediately
+func isUnderPlayer(row, column int, playersPos []pixel.Vec) bool {
+	for _, pos := range playersPos {
+		playerRow, playerColumn := TankCell(pos)
+		dRow, dColumn := row-playerRow, column-playerColumn
+		if dRow > -2 && dRow < 2 && dColumn > -2 && dColumn < 2 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bonus) Update(dt float64) {
+	b.duration += time.Duration(dt * float64(time.Second))
+}
+
+func (b *Bonus) IsExpired() bool {
+	return b.duration >= bonusLifetime
+}
+
+// IsBlinking reports whether the bonus is about to expire
+func (b *Bonus) IsBlinking() bool {
+	return b.duration >= bonusLifetime-bonusBlinkingTime
+}
+
+func (b *Bonus) Pos() pixel.Vec {
+	return b.pos
+}
+
+func (b *Bonus) Type() BonusType {
+	return b.bonusType
+}
+
+// BonusSnapshot is a copy of the bonus state, see Bonus.Save
+type BonusSnapshot struct {
+	bonus *Bonus
+	value Bonus
+}
+
+// Save returns an empty snapshot for nil bonus
+func (b *Bonus) Save() BonusSnapshot {
+	if b == nil {
+		return BonusSnapshot{}
+	}
+	return BonusSnapshot{bonus: b, value: *b}
+}
+
+// Restore returns the saved bonus to the saved state, nil if there was no bonus
+func (s BonusSnapshot) Restore() *Bonus {
+	if s.bonus == nil {
+		return nil
+	}
+	*s.bonus = s.value
+	return s.bonus
+}
@@ -0,0 +1,134 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	TimeStopEffect     EffectID = "time_stop"
+	HQArmorEffect      EffectID = "hq_armor"
+	PlayerFrozenEffect EffectID = "player_frozen"
+	ShipEffect         EffectID = "ship"
+)
+
+// BonusEffects are effects applied when a player or a bot takes a bonus
+type BonusEffects struct {
+	Player *Effect
+	Bot    *Effect // nil - bots just steal the bonus
+}
+
+var bonusEffects = map[BonusType]BonusEffects{
+	ImmunityBonus: {
+		Player: &Effect{
+			PerPlayer: true,
+			Start: func(_ *World, p *Player) {
+				p.MakeImmune(time.Second * 10)
+			},
+		},
+		Bot: armorBotsEffect,
+	},
+	TimeStopBonus: {
+		Player: &Effect{ID: TimeStopEffect, Duration: time.Second * 10},
+		Bot:    &Effect{ID: PlayerFrozenEffect, Duration: time.Second * 10},
+	},
+	HQArmorBonus: {
+		Player: &Effect{
+			ID:       HQArmorEffect,
+			Duration: time.Second * 20,
+			Start: func(w *World, p *Player) {
+				for team := 0; team < w.stage.Teams(); team++ { // in versus the armor moves to the new owner
+					if team != w.team(p) && w.stage.IsHQArmored(team) {
+						w.stage.DisarmorHQ(team)
+					}
+				}
+				w.stage.ArmorHQ(w.team(p))
+			},
+			Tick: func(w *World, p *Player, elapsed time.Duration) {
+				if elapsed < time.Second*17 {
+					return
+				}
+				// blink before expiration
+				team := w.team(p)
+				blinkPeriod := time.Millisecond * 250
+				delta := elapsed - (time.Second * 17)
+				if math.Mod(float64(delta/blinkPeriod), 2) == 0 {
+					if w.stage.IsHQArmored(team) {
+						w.stage.DisarmorHQ(team)
+					}
+				} else {
+					if !w.stage.IsHQArmored(team) {
+						w.stage.ArmorHQ(team)
+					}
+				}
+			},
+			Expire: func(w *World, p *Player) {
+				if w.stage.IsHQArmored(w.team(p)) {
+					w.stage.DisarmorHQ(w.team(p))
+				}
+			},
+		},
+		Bot: &Effect{
+			Start: func(w *World, _ *Player) {
+				w.effects.Remove(w, HQArmorEffect)
+				for team := 0; team < w.stage.Teams(); team++ {
+					w.stage.DestroyHQArmor(team)
+				}
+			},
+		},
+	},
+	UpgradeBonus: {
+		Player: &Effect{
+			PerPlayer: true,
+			Start: func(_ *World, p *Player) {
+				p.Upgrade()
+			},
+		},
+		Bot: armorBotsEffect,
+	},
+	AnnihilationBonus: {
+		Player: &Effect{
+			Start: func(w *World, p *Player) {
+				w.annihilateBots(p)
+			},
+		},
+		Bot: &Effect{
+			Start: func(w *World, _ *Player) {
+				for _, p := range w.players {
+					w.destroyPlayer(p)
+				}
+			},
+		},
+	},
+	LifeBonus: {
+		Player: &Effect{
+			PerPlayer: true,
+			Start: func(_ *World, p *Player) {
+				if p.lives < 9 {
+					p.lives++
+				}
+			},
+		},
+	},
+	ShipBonus: {
+		Player: &Effect{
+			ID:        ShipEffect,
+			Duration:  -1, // until death
+			PerPlayer: true,
+			Start: func(_ *World, p *Player) {
+				p.hasShip = true
+			},
+			Expire: func(_ *World, p *Player) {
+				p.hasShip = false
+			},
+		},
+	},
+}
+
+var armorBotsEffect = &Effect{
+	Start: func(w *World, _ *Player) {
+		for _, b := range w.bots {
+			b.Armor()
+		}
+	},
+}
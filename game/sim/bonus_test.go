@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"battlecity/assets"
+	"github.com/faiface/pixel"
+	"math/rand"
+	"testing"
+)
+
+// testPlayersPos are spawn positions of both players on the first stage
+var testPlayersPos = []pixel.Vec{NewPlayer(0).spawnPos, NewPlayer(1).spawnPos}
+
+func newTestStage(t *testing.T) *Stage {
+	t.Helper()
+	stage, err := NewStage(assets.Stages, 1, false, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stage
+}
+
+func TestNewBonusIsDeterministic(t *testing.T) {
+	stage := newTestStage(t)
+	for seed := int64(0); seed < 20; seed++ {
+		a := NewBonus(stage, testPlayersPos, rand.New(rand.NewSource(seed)))
+		b := NewBonus(stage, testPlayersPos, rand.New(rand.NewSource(seed)))
+		if a == nil || b == nil {
+			t.Fatalf("seed %d: no bonus", seed)
+		}
+		if a.pos != b.pos || a.bonusType != b.bonusType {
+			t.Errorf("seed %d: bonuses %d at %v and %d at %v", seed, a.bonusType, a.pos, b.bonusType, b.pos)
+		}
+	}
+}
+
+func TestNewBonusAvoidsPlayers(t *testing.T) {
+	stage := newTestStage(t)
+	reachable := make(map[[2]int]bool)
+	for _, pos := range testPlayersPos {
+		for _, cell := range stage.ReachableCells(pos) {
+			reachable[cell] = true
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		bonus := NewBonus(stage, testPlayersPos, rnd)
+		if bonus == nil {
+			t.Fatal("no bonus")
+		}
+		row, column := TankCell(bonus.pos)
+		if !reachable[[2]int{row, column}] {
+			t.Fatalf("bonus at unreachable cell (%d, %d)", row, column)
+		}
+		for _, pos := range testPlayersPos {
+			playerRow, playerColumn := TankCell(pos)
+			if abs(row-playerRow) < 2 && abs(column-playerColumn) < 2 {
+				t.Fatalf("bonus at (%d, %d) is taken at once by the player at (%d, %d)", row, column, playerRow, playerColumn)
+			}
+		}
+	}
+}
+
+func TestNewBonusNothingReachable(t *testing.T) {
+	stage := newTestStage(t)
+	// the player is walled in, the only reachable cell is its own
+	row, column := TankCell(testPlayersPos[0])
+	for r, blocks := range stage.Blocks {
+		for c, block := range blocks {
+			if (r != row-1 && r != row) || (c != column-1 && c != column) {
+				stage.Blocks[r][c] = Steel(block.pos, block.row, block.column)
+			}
+		}
+	}
+	if bonus := NewBonus(stage, testPlayersPos[:1], rand.New(rand.NewSource(1))); bonus != nil {
+		t.Errorf("bonus at %v", bonus.pos)
+	}
+	if bonus := NewBonus(stage, nil, rand.New(rand.NewSource(1))); bonus != nil {
+		t.Errorf("bonus at %v without players", bonus.pos)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
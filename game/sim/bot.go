@@ -0,0 +1,232 @@
+package sim
+
+import (
+	"battlecity/game/utils"
+	"github.com/faiface/pixel"
+	"github.com/google/uuid"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type BotType int
+
+const (
+	DefaultBot BotType = iota
+	RapidMovementBot
+	RapidShootingBot
+	ArmoredBot
+)
+
+const ArmoredBotHP = 4
+
+// botCreationDuration is how long a bot appears before it can act
+const botCreationDuration = time.Millisecond * 780
+
+// Score returns points given for destroying a bot of this type
+func (t BotType) Score() int {
+	return 100 * (int(t) + 1)
+}
+
+type Bot struct {
+	Id
+	botType          BotType
+	poolIndex        int // index in the stage's bots pool, identifies the bot
+	pos              pixel.Vec
+	isBonus          bool
+	speed            float64
+	direction        utils.Direction
+	hp               int
+	currentBullet    *Bullet
+	bulletSpeed      float64
+	sinceLastShot    time.Duration
+	shootingInterval time.Duration
+	maxStuckInterval time.Duration
+	stuckTime        time.Duration
+	onCreation       bool
+	creationDuration time.Duration
+	rnd              *rand.Rand
+}
+
+func NewBot(botType BotType, pos pixel.Vec, isBonus bool, rnd *rand.Rand) *Bot {
+	b := new(Bot)
+	b.id = uuid.Must(uuid.NewRandomFromReader(utils.NewSource(rnd.Int63())))
+	b.rnd = rnd
+	b.pos = pos
+	b.isBonus = isBonus
+	b.direction = utils.South
+	b.shootingInterval = time.Millisecond * 200
+	b.sinceLastShot = time.Minute
+	b.maxStuckInterval = time.Millisecond * 300
+	b.stuckTime = 0
+	b.initBotType(botType)
+
+	return b
+}
+
+func (b *Bot) Update(dt float64) {
+	if b.onCreation {
+		b.creationDuration += time.Duration(dt * float64(time.Second))
+		if b.creationDuration >= botCreationDuration {
+			b.onCreation = false
+		}
+	}
+}
+
+func (b *Bot) CalculateMovement(dt float64) (pixel.Vec, utils.Direction) {
+	const (
+		directionChangeProb = 0.5 // 50% per second
+		turnProb            = 0.7 // 70% per direction change
+	)
+	newDirection := b.direction
+	if b.stuckTime > b.maxStuckInterval || directionChangeProb*dt > b.rnd.Float64() {
+		b.stuckTime = 0
+		if turnProb > b.rnd.Float64() {
+			var perpendicularDirections []utils.Direction
+			if b.direction.IsHorizontal() {
+				perpendicularDirections = []utils.Direction{utils.North, utils.South}
+			} else {
+				perpendicularDirections = []utils.Direction{utils.West, utils.East}
+			}
+			newDirection = perpendicularDirections[b.rnd.Intn(len(perpendicularDirections))]
+		} else {
+			for {
+				randomDirection := utils.RandomDirection(b.rnd)
+				if randomDirection != b.direction {
+					newDirection = randomDirection
+					break
+				}
+			}
+		}
+	}
+
+	speed := b.speed * dt
+	newPos := b.pos.Add(newDirection.Velocity(speed))
+
+	if b.direction.IsPerpendicular(newDirection) {
+		switch b.direction {
+		case utils.North, utils.South:
+			newPos.Y = MRound(math.Round, newPos.Y, BlockSize)
+		case utils.East, utils.West:
+			newPos.X = MRound(math.Round, newPos.X, BlockSize)
+		}
+	}
+	return newPos, newDirection
+}
+
+func (b *Bot) Move(movementRes *MovementResult, dt float64) {
+	if b.onCreation {
+		return
+	}
+	b.direction = movementRes.direction
+	if movementRes.canMove {
+		b.pos = movementRes.newPos
+	} else {
+		b.stuckTime += time.Duration(dt * float64(time.Second))
+		// alignment
+		if b.direction.IsHorizontal() {
+			b.pos = pixel.V(MRound(math.Round, b.pos.X, BlockSize), movementRes.newPos.Y)
+		} else {
+			b.pos = pixel.V(movementRes.newPos.X, MRound(math.Round, b.pos.Y, BlockSize))
+		}
+	}
+}
+
+func (b *Bot) Shoot(dt float64) *Bullet {
+	if b.onCreation {
+		return nil
+	}
+	const shootProb = 1 // 100% per second
+	if b.sinceLastShot < time.Minute {
+		b.sinceLastShot += time.Duration(dt * float64(time.Second))
+	}
+	canShoot := b.sinceLastShot > b.shootingInterval
+	noCurrentBullet := b.currentBullet == nil || b.currentBullet.destroyed
+	if noCurrentBullet && canShoot && shootProb*dt > b.rnd.Float64() {
+		bullet := CreateBullet(b, b.bulletSpeed)
+		b.currentBullet = bullet
+		b.sinceLastShot = 0
+		return bullet
+	}
+
+	return nil
+}
+
+// Armor makes bot as strong as a fresh ArmoredBot
+func (b *Bot) Armor() {
+	b.hp = ArmoredBotHP
+}
+
+func (b *Bot) Side() TankSide {
+	return BotSide
+}
+
+func (b *Bot) Pos() pixel.Vec {
+	return b.pos
+}
+
+func (b *Bot) Direction() utils.Direction {
+	return b.direction
+}
+
+func (b *Bot) OnCreation() bool {
+	return b.onCreation
+}
+
+func (b *Bot) Type() BotType {
+	return b.botType
+}
+
+// PoolIndex returns the index of the bot in the bots pool of its stage, it identifies the bot
+func (b *Bot) PoolIndex() int {
+	return b.poolIndex
+}
+
+func (b *Bot) HP() int {
+	return b.hp
+}
+
+// IsBonus reports whether a hit on the bot spawns a bonus
+func (b *Bot) IsBonus() bool {
+	return b.isBonus
+}
+
+func (b *Bot) initBotType(botType BotType) {
+	var speed, bulletSpeed float64
+	var hp int
+	b.botType = botType
+	switch b.botType {
+	case DefaultBot:
+		speed, bulletSpeed = 30, 100
+		hp = 1
+	case RapidMovementBot:
+		speed, bulletSpeed = 60, 100
+		hp = 1
+	case RapidShootingBot:
+		speed, bulletSpeed = 30, 175
+		hp = 1
+	case ArmoredBot:
+		speed, bulletSpeed = 30, 100
+		hp = ArmoredBotHP
+	}
+
+	b.speed, b.bulletSpeed = speed, bulletSpeed
+	b.hp = hp
+	b.onCreation = true
+}
+
+// BotSnapshot is a copy of the bot's state, see Bot.Save
+type BotSnapshot struct {
+	bot   *Bot
+	value Bot
+}
+
+func (b *Bot) Save() BotSnapshot {
+	return BotSnapshot{bot: b, value: *b}
+}
+
+// Restore returns the saved bot to the saved state, even if it was destroyed after saving
+func (s BotSnapshot) Restore() *Bot {
+	*s.bot = s.value
+	return s.bot
+}
@@ -1,4 +1,4 @@
-package game
+package sim
 
 import (
 	"battlecity/game/utils"
@@ -21,7 +21,7 @@ type Bullet struct {
 func CreateBullet(origin Tank, speed float64) *Bullet {
 	b := new(Bullet)
 	b.origin = origin
-	b.pos = b.origin.Pos().Add(b.origin.Direction().Velocity(TankSize / 2 * Scale))
+	b.pos = b.origin.Pos().Add(b.origin.Direction().Velocity(TankSize / 2))
 	b.direction = b.origin.Direction()
 	b.speed = speed
 	return b
@@ -36,7 +36,36 @@ func (b *Bullet) Destroy() {
 	b.destroyed = true
 }
 
+func (b *Bullet) Pos() pixel.Vec {
+	return b.pos
+}
+
+func (b *Bullet) Direction() utils.Direction {
+	return b.direction
+}
+
+// Origin returns the tank which fired the bullet
+func (b *Bullet) Origin() Tank {
+	return b.origin
+}
+
+// BulletSnapshot is a copy of the bullet's state, see Bullet.Save
+type BulletSnapshot struct {
+	bullet *Bullet
+	value  Bullet
+}
+
+func (b *Bullet) Save() BulletSnapshot {
+	return BulletSnapshot{bullet: b, value: *b}
+}
+
+// Restore returns the saved bullet to the saved state, even if it was destroyed after saving
+func (s BulletSnapshot) Restore() *Bullet {
+	*s.bullet = s.value
+	return s.bullet
+}
+
 func (b *Bullet) IsUpgraded() bool {
 	player, ok := b.origin.(*Player)
-	return ok && player.level == maxLevel
+	return ok && player.level == MaxLevel
 }
@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+)
+
+// Difficulty changes how fast bots come
+type Difficulty int
+
+const (
+	NormalDifficulty Difficulty = iota
+	EasyDifficulty
+	HardDifficulty
+)
+
+var difficultyNames = []string{"normal", "easy", "hard"}
+
+func (d Difficulty) String() string {
+	if !d.Valid() {
+		return fmt.Sprintf("Difficulty(%d)", int(d))
+	}
+	return difficultyNames[d]
+}
+
+func (d Difficulty) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Difficulty) UnmarshalText(text []byte) error {
+	for i, name := range difficultyNames {
+		if name == string(text) {
+			*d = Difficulty(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown difficulty %q, it's one of %v", text, difficultyNames)
+}
+
+// Set and String make Difficulty a flag.Value
+func (d *Difficulty) Set(s string) error {
+	return d.UnmarshalText([]byte(s))
+}
+
+// Valid reports whether d is one of known difficulties
+func (d Difficulty) Valid() bool {
+	return d >= 0 && int(d) < len(difficultyNames)
+}
+
+// newBotInterval returns the interval between bots appearance
+func (d Difficulty) newBotInterval() time.Duration {
+	switch d {
+	case EasyDifficulty:
+		return time.Second * 4
+	case HardDifficulty:
+		return time.Second * 2
+	}
+	return time.Second * 3
+}
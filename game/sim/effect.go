@@ -0,0 +1,123 @@
+package sim
+
+import "time"
+
+type EffectID string
+
+// Effect describes a gameplay effect, e.g. a taken bonus.
+// Hooks are optional, p is the player who owns or triggered the effect, nil if it's triggered by bots
+type Effect struct {
+	ID        EffectID
+	Duration  time.Duration // 0 - applied once, < 0 - lasts until removed
+	PerPlayer bool          // stored by the player and cleared on death, otherwise global
+	Start     func(w *World, p *Player)
+	Tick      func(w *World, p *Player, elapsed time.Duration)
+	Expire    func(w *World, p *Player)
+}
+
+type activeEffect struct {
+	effect  *Effect
+	player  *Player
+	elapsed time.Duration
+}
+
+// Effects is a set of active effects, either global or of a particular player
+type Effects struct {
+	active []*activeEffect
+}
+
+func NewEffects() *Effects {
+	return new(Effects)
+}
+
+// Add starts effect, restarting it if it's already active
+func (e *Effects) Add(w *World, effect *Effect, p *Player) {
+	if effect.Start != nil {
+		effect.Start(w, p)
+	}
+	if effect.Duration == 0 {
+		return
+	}
+	for _, a := range e.active {
+		if a.effect.ID == effect.ID {
+			a.effect = effect
+			a.player = p
+			a.elapsed = 0
+			return
+		}
+	}
+	e.active = append(e.active, &activeEffect{effect: effect, player: p})
+}
+
+func (e *Effects) Update(w *World, dt float64) {
+	var active, expired []*activeEffect
+	for _, a := range e.active {
+		a.elapsed += time.Duration(dt * float64(time.Second))
+		if a.effect.Duration > 0 && a.elapsed >= a.effect.Duration {
+			expired = append(expired, a)
+			continue
+		}
+		if a.effect.Tick != nil {
+			a.effect.Tick(w, a.player, a.elapsed)
+		}
+		active = append(active, a)
+	}
+	e.active = active
+	for _, a := range expired { // expire hooks may add new effects
+		e.expire(w, a)
+	}
+}
+
+func (e *Effects) IsActive(id EffectID) bool {
+	for _, a := range e.active {
+		if a.effect.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Effects) Remove(w *World, id EffectID) {
+	for i, a := range e.active {
+		if a.effect.ID == id {
+			e.active = append(e.active[:i], e.active[i+1:]...)
+			e.expire(w, a)
+			return
+		}
+	}
+}
+
+// Clear expires all effects
+func (e *Effects) Clear(w *World) {
+	active := e.active
+	e.active = nil
+	for _, a := range active {
+		e.expire(w, a)
+	}
+}
+
+// EffectsSnapshot is a copy of active effects and their timers, see Effects.Save
+type EffectsSnapshot []activeEffect
+
+func (e *Effects) Save() EffectsSnapshot {
+	snapshot := make(EffectsSnapshot, len(e.active))
+	for i, a := range e.active {
+		snapshot[i] = *a
+	}
+	return snapshot
+}
+
+// Restore replaces active effects with the saved ones, hooks aren't called
+func (e *Effects) Restore(snapshot EffectsSnapshot) {
+	e.active = make([]*activeEffect, len(snapshot))
+	for i := range snapshot {
+		a := snapshot[i]
+		e.active[i] = &a
+	}
+}
+
+func (e *Effects) expire(w *World, a *activeEffect) {
+	if a.effect.Expire != nil {
+		a.effect.Expire(w, a.player)
+	}
+}
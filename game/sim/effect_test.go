@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// loggedEffect returns an effect which logs calls of its hooks
+func loggedEffect(id EffectID, duration time.Duration, log *[]string) *Effect {
+	return &Effect{
+		ID:       id,
+		Duration: duration,
+		Start: func(_ *World, _ *Player) {
+			*log = append(*log, "start "+string(id))
+		},
+		Tick: func(_ *World, _ *Player, elapsed time.Duration) {
+			*log = append(*log, fmt.Sprintf("tick %s %v", id, elapsed))
+		},
+		Expire: func(_ *World, _ *Player) {
+			*log = append(*log, "expire "+string(id))
+		},
+	}
+}
+
+func TestEffects(t *testing.T) {
+	const step = time.Millisecond * 100
+	tests := []struct {
+		name     string
+		duration time.Duration
+		steps    func(e *Effects, effect *Effect)
+		log      []string
+		active   bool
+	}{
+		{"once", 0, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Update(nil, step.Seconds())
+		}, []string{"start a"}, false},
+		{"timed", step * 2, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Update(nil, step.Seconds())
+			e.Update(nil, step.Seconds())
+		}, []string{"start a", "tick a 100ms", "expire a"}, false},
+		{"until removed", -1, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Update(nil, time.Hour.Seconds())
+			e.Remove(nil, effect.ID)
+			e.Update(nil, step.Seconds())
+		}, []string{"start a", "tick a 1h0m0s", "expire a"}, false},
+		{"restarted", step * 2, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Update(nil, step.Seconds())
+			e.Add(nil, effect, nil)
+			e.Update(nil, step.Seconds())
+		}, []string{"start a", "tick a 100ms", "start a", "tick a 100ms"}, true},
+		{"cleared", -1, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Clear(nil)
+			e.Clear(nil)
+		}, []string{"start a", "expire a"}, false},
+		{"removed twice", -1, func(e *Effects, effect *Effect) {
+			e.Add(nil, effect, nil)
+			e.Remove(nil, effect.ID)
+			e.Remove(nil, effect.ID)
+		}, []string{"start a", "expire a"}, false},
+	}
+	for _, test := range tests {
+		var log []string
+		e := NewEffects()
+		effect := loggedEffect("a", test.duration, &log)
+		test.steps(e, effect)
+		if fmt.Sprint(log) != fmt.Sprint(test.log) {
+			t.Errorf("%s: hooks are called %q, want %q", test.name, log, test.log)
+		}
+		if e.IsActive(effect.ID) != test.active {
+			t.Errorf("%s: active = %v, want %v", test.name, e.IsActive(effect.ID), test.active)
+		}
+	}
+}
+
+func TestEffectsExpireInOrder(t *testing.T) {
+	var log []string
+	e := NewEffects()
+	first := loggedEffect("first", time.Millisecond*100, &log)
+	second := loggedEffect("second", time.Millisecond*50, &log)
+	next := loggedEffect("next", -1, &log)
+	first.Expire = func(_ *World, _ *Player) {
+		log = append(log, "expire first")
+		e.Add(nil, next, nil) // an expire hook may add effects
+	}
+	e.Add(nil, first, nil)
+	e.Add(nil, second, nil)
+	e.Update(nil, 0.1)
+	want := []string{"start first", "start second", "expire first", "start next", "expire second"}
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Errorf("hooks are called %q, want %q", log, want)
+	}
+	if e.IsActive("first") || e.IsActive("second") || !e.IsActive("next") {
+		t.Errorf("active effects are %v", e.active)
+	}
+}
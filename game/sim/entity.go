@@ -1,20 +1,18 @@
-package game
+package sim
 
 import (
 	"battlecity/game/utils"
 	"github.com/faiface/pixel"
-	"github.com/faiface/pixel/pixelgl"
 	"github.com/google/uuid"
 )
 
-const Scale = 4.0
 const TankSize = 16.0
 
 type TankSide int
 
 const (
-	human TankSide = iota
-	bot
+	HumanSide TankSide = iota
+	BotSide
 )
 
 type Id struct {
@@ -25,9 +23,9 @@ type Tank interface {
 	Side() TankSide
 	Pos() pixel.Vec
 	Direction() utils.Direction
-	CalculateMovement(win *pixelgl.Window, dt float64) (pixel.Vec, utils.Direction)
+	CalculateMovement(dt float64) (pixel.Vec, utils.Direction)
 	Move(movementRes *MovementResult, dt float64)
-	Shoot(win *pixelgl.Window, dt float64) *Bullet
+	Shoot(dt float64) *Bullet
 	OnCreation() bool
 }
 
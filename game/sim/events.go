@@ -0,0 +1,156 @@
+package sim
+
+import "github.com/faiface/pixel"
+
+// Event is something which happened in the simulation, it's published to subscribers of Events
+type Event interface {
+	event()
+}
+
+// BulletFired is published when tank shoots
+type BulletFired struct {
+	Tank   Tank
+	Bullet *Bullet
+}
+
+// BlockHit is published when a bullet of Tank hits a block, the bullet explodes at Pos.
+// Steel is whether it hit steel or the border without destroying it
+type BlockHit struct {
+	Tank  Tank
+	Pos   pixel.Vec
+	Steel bool
+}
+
+// TankHit is published when a bullet hits an enemy tank, the bullet explodes at Pos.
+// The tank isn't destroyed if it's armored, immune or saved by a ship
+type TankHit struct {
+	Tank      Tank
+	Pos       pixel.Vec
+	Destroyed bool
+}
+
+// TankDestroyed is published when tank is destroyed, by Killer if it isn't nil
+type TankDestroyed struct {
+	Tank   Tank
+	Killer *Player
+	Pos    pixel.Vec
+}
+
+// BonusSpawned is published when a bonus appears on the stage
+type BonusSpawned struct {
+	Bonus *Bonus
+}
+
+// BonusTaken is published when a tank takes a bonus, Player is nil if a bot took it
+type BonusTaken struct {
+	Type   BonusType
+	Player *Player
+}
+
+// HQDestroyed is published when HQ of team is destroyed
+type HQDestroyed struct {
+	Team int
+	Pos  pixel.Vec
+}
+
+// StageCleared is published when all bots of the stage are destroyed
+type StageCleared struct {
+	Stage int
+}
+
+// GameOver is published when the co-op game is lost, the HQ is destroyed or all players are out of lives
+type GameOver struct{}
+
+// PlayerMoved is published on every tick for each player, Moving is whether the player drives
+type PlayerMoved struct {
+	Player *Player
+	Moving bool
+}
+
+// GamePaused is published when the game is paused or resumed
+type GamePaused struct {
+	Paused bool
+}
+
+func (BulletFired) event()   {}
+func (BlockHit) event()      {}
+func (TankHit) event()       {}
+func (TankDestroyed) event() {}
+func (BonusSpawned) event()  {}
+func (BonusTaken) event()    {}
+func (HQDestroyed) event()   {}
+func (StageCleared) event()  {}
+func (GameOver) event()      {}
+func (PlayerMoved) event()   {}
+func (GamePaused) event()    {}
+
+// ExplosionKind is the size of an explosion, values match explosions.ExplosionType
+type ExplosionKind uint8
+
+const (
+	BulletExplosion ExplosionKind = iota
+	TankExplosion
+)
+
+// Explosion returns the explosion shown for event, false if it doesn't explode
+func Explosion(event Event) (ExplosionKind, pixel.Vec, bool) {
+	switch e := event.(type) {
+	case BlockHit:
+		return BulletExplosion, e.Pos, true
+	case TankHit:
+		return BulletExplosion, e.Pos, true
+	case TankDestroyed:
+		return TankExplosion, e.Pos, true
+	case HQDestroyed:
+		return TankExplosion, e.Pos, true
+	}
+	return 0, pixel.ZV, false
+}
+
+// Events delivers events of the simulation to subscribers in order of subscription.
+// Subscribers are called synchronously, so the ones which change the simulation (e.g. scoring) stay deterministic
+type Events struct {
+	subscribers []func(Event)
+	replaying   int // number of active Replay calls
+}
+
+func NewEvents() *Events {
+	return new(Events)
+}
+
+func (e *Events) Subscribe(subscriber func(Event)) {
+	e.subscribers = append(e.subscribers, subscriber)
+}
+
+func (e *Events) Publish(event Event) {
+	for _, subscriber := range e.subscribers {
+		subscriber(event)
+	}
+}
+
+// Replay runs f which simulates ticks again, e.g. after a rollback.
+// Their events are published again, but subscribers like audio shouldn't repeat them
+func (e *Events) Replay(f func()) {
+	e.replaying++
+	defer func() { e.replaying-- }()
+	f()
+}
+
+// Replaying reports whether published events are replayed, see Replay
+func (e *Events) Replaying() bool {
+	return e.replaying > 0
+}
+
+// score rewards players for destroyed bots and taken bonuses
+func score(event Event) {
+	switch e := event.(type) {
+	case TankDestroyed:
+		if b, ok := e.Tank.(*Bot); ok && e.Killer != nil {
+			e.Killer.score += b.botType.Score()
+		}
+	case BonusTaken:
+		if e.Player != nil {
+			e.Player.score += BonusScore
+		}
+	}
+}
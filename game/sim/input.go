@@ -0,0 +1,38 @@
+package sim
+
+import "battlecity/game/utils"
+
+// Input is a state of player's controls during one tick
+type Input uint8
+
+const (
+	InputUp Input = 1 << iota
+	InputRight
+	InputDown
+	InputLeft
+	InputFire
+)
+
+// Direction returns pressed direction, the first one wins if several are pressed
+func (i Input) Direction() (utils.Direction, bool) {
+	switch {
+	case i&InputUp != 0:
+		return utils.North, true
+	case i&InputRight != 0:
+		return utils.East, true
+	case i&InputDown != 0:
+		return utils.South, true
+	case i&InputLeft != 0:
+		return utils.West, true
+	}
+	return utils.North, false
+}
+
+func (i Input) IsMoving() bool {
+	_, ok := i.Direction()
+	return ok
+}
+
+func (i Input) IsFiring() bool {
+	return i&InputFire != 0
+}
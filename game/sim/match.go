@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// versusRoundsToWin is how many rounds a player must win to win the match
+const versusRoundsToWin = 2
+
+// Match is the score of a versus game, states of its rounds share it
+type Match struct {
+	round  int    // from 1
+	wins   [2]int // by team
+	arenas int
+}
+
+// NewMatch starts a match in arenas found in stagesConfigs
+func NewMatch(stagesConfigs fs.FS) (*Match, error) {
+	arenas, err := stagesCount(stagesConfigs, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Match{round: 1, arenas: arenas}, nil
+}
+
+// Arena returns the number of the arena of the current round, arenas take turns
+func (m *Match) Arena() int {
+	return (m.round-1)%m.arenas + 1
+}
+
+func (m *Match) Round() int {
+	return m.round
+}
+
+// EndRound counts the round won by team, -1 is a draw
+func (m *Match) EndRound(team int) {
+	if team >= 0 {
+		m.wins[team]++
+	}
+	m.round++
+}
+
+// Winner returns the team which won the match, -1 if it's still going
+func (m *Match) Winner() int {
+	for team, wins := range m.wins {
+		if wins >= versusRoundsToWin {
+			return team
+		}
+	}
+	return -1
+}
+
+// Score returns the match score like "1 - 0"
+func (m *Match) Score() string {
+	return fmt.Sprintf("%d - %d", m.wins[0], m.wins[1])
+}
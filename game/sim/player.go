@@ -0,0 +1,283 @@
+package sim
+
+import (
+	"battlecity/game/utils"
+	"github.com/faiface/pixel"
+	"github.com/google/uuid"
+	"math"
+	"time"
+)
+
+const MaxLevel = 3
+
+// playerCreationDuration is how long a player appears before it can act
+const playerCreationDuration = time.Millisecond * 520
+
+type Player struct {
+	Id
+	index               int
+	input               Input
+	pos                 pixel.Vec
+	spawnPos            pixel.Vec
+	spawnDirection      utils.Direction
+	speed               float64
+	direction           utils.Direction
+	immune              bool
+	onCreation          bool
+	creationDuration    time.Duration
+	hasShip             bool // can cross water
+	immunityDuration    time.Duration
+	maxImmunityDuration time.Duration
+	bulletSpeed         float64
+	currentBullet1      *Bullet
+	currentBullet2      *Bullet
+	sinceLastShot       time.Duration
+	shootingInterval    time.Duration
+	level               int
+	lives               int
+	score               int
+	effects             *Effects
+}
+
+func NewPlayer(index int) *Player {
+	p := new(Player)
+	p.id = uuid.New()
+	p.index = index
+	spawnColumn := 11.0
+	if p.index == 1 {
+		spawnColumn = 19
+	}
+	p.SetSpawn(pixel.V(spawnColumn*BlockSize, 3*BlockSize), utils.North)
+	p.lives = 2
+	p.effects = NewEffects()
+	p.shootingInterval = time.Millisecond * 200
+	p.sinceLastShot = time.Minute
+	return p
+}
+
+func (p *Player) SetInput(input Input) {
+	p.input = input
+}
+
+func (p *Player) Update(dt float64) {
+	if p.onCreation {
+		p.creationDuration += time.Duration(dt * float64(time.Second))
+		if p.creationDuration >= playerCreationDuration {
+			p.onCreation = false
+		}
+		return
+	}
+	if p.immunityDuration >= p.maxImmunityDuration {
+		p.immune = false
+		p.immunityDuration = 0
+	}
+	if p.immune {
+		p.immunityDuration += time.Duration(dt * float64(time.Second))
+	}
+}
+
+// SetSpawn changes where the player appears on Respawn
+func (p *Player) SetSpawn(pos pixel.Vec, direction utils.Direction) {
+	p.spawnPos = pos
+	p.spawnDirection = direction
+}
+
+func (p *Player) Respawn() {
+	p.MakeImmune(time.Second * 3)
+	p.onCreation = true
+	p.creationDuration = 0
+	p.pos = p.spawnPos
+	p.direction = p.spawnDirection
+	p.currentBullet1 = nil
+	p.currentBullet2 = nil
+}
+
+func (p *Player) CalculateMovement(dt float64) (pixel.Vec, utils.Direction) {
+	newDirection, ok := p.input.Direction()
+	if !ok {
+		return p.pos, p.direction
+	}
+	speed := p.speed * dt
+	newPos := p.pos.Add(newDirection.Velocity(speed))
+
+	if p.direction.IsPerpendicular(newDirection) {
+		switch p.direction {
+		case utils.North, utils.South:
+			newPos.Y = MRound(math.Round, newPos.Y, BlockSize)
+		case utils.East, utils.West:
+			newPos.X = MRound(math.Round, newPos.X, BlockSize)
+		}
+	}
+	return newPos, newDirection
+}
+
+func (p *Player) Shoot(dt float64) *Bullet {
+	if p.onCreation {
+		return nil
+	}
+	if p.sinceLastShot < time.Minute {
+		p.sinceLastShot += time.Duration(dt * float64(time.Second))
+	}
+	if p.currentBullet1 != nil && p.currentBullet1.destroyed {
+		p.currentBullet1 = nil
+	}
+	if p.currentBullet2 != nil && p.currentBullet2.destroyed {
+		p.currentBullet2 = nil
+	}
+
+	var shootingInterval time.Duration
+	var canShoot bool
+	if p.level < 2 {
+		shootingInterval = p.shootingInterval
+		canShoot = p.currentBullet1 == nil
+	} else {
+		canShoot = p.currentBullet2 == nil
+		if canShoot {
+			shootingInterval = p.shootingInterval / 2
+			if p.currentBullet1 != nil && p.currentBullet2 == nil {
+				shootingInterval = p.shootingInterval / 8
+			}
+		}
+	}
+	canShoot = canShoot && p.sinceLastShot >= shootingInterval
+	if canShoot && p.input.IsFiring() {
+		bullet := CreateBullet(p, p.bulletSpeed)
+		if p.currentBullet1 == nil {
+			p.currentBullet1 = bullet
+		} else {
+			p.currentBullet2 = bullet
+		}
+		p.sinceLastShot = 0
+		return bullet
+	}
+
+	return nil
+}
+
+func (p *Player) Move(movementRes *MovementResult, _ float64) {
+	if p.onCreation {
+		return
+	}
+	p.direction = movementRes.direction
+	if movementRes.canMove {
+		p.pos = movementRes.newPos
+	} else {
+		// alignment
+		if p.direction.IsHorizontal() {
+			p.pos = pixel.V(MRound(math.Round, p.pos.X, BlockSize), movementRes.newPos.Y)
+		} else {
+			p.pos = pixel.V(movementRes.newPos.X, MRound(math.Round, p.pos.Y, BlockSize))
+		}
+	}
+}
+
+func (p *Player) MakeImmune(maxDuration time.Duration) {
+	p.immune = true
+	p.maxImmunityDuration = maxDuration
+}
+
+func (p *Player) Upgrade() {
+	if p.level != MaxLevel {
+		p.changeLevel(p.level + 1)
+	}
+}
+
+func (p *Player) ResetLevel() {
+	p.changeLevel(0)
+}
+
+func (p *Player) Side() TankSide {
+	return HumanSide
+}
+
+func (p *Player) Pos() pixel.Vec {
+	return p.pos
+}
+
+func (p *Player) Direction() utils.Direction {
+	return p.direction
+}
+
+func (p *Player) OnCreation() bool {
+	return p.onCreation
+}
+
+// Index returns the number of the player from 0
+func (p *Player) Index() int {
+	return p.index
+}
+
+// Input returns the input of the last tick
+func (p *Player) Input() Input {
+	return p.input
+}
+
+func (p *Player) Level() int {
+	return p.level
+}
+
+// Lives returns lives left, -1 if the player is out of the game
+func (p *Player) Lives() int {
+	return p.lives
+}
+
+// IsOut reports whether the player has no lives left, it neither moves nor can be hit then
+func (p *Player) IsOut() bool {
+	return p.lives < 0
+}
+
+func (p *Player) Score() int {
+	return p.score
+}
+
+func (p *Player) IsImmune() bool {
+	return p.immune
+}
+
+// HasShip reports whether the player can cross water
+func (p *Player) HasShip() bool {
+	return p.hasShip
+}
+
+// PlayerSnapshot is a copy of the player's state, see Player.Save
+type PlayerSnapshot struct {
+	player  *Player
+	value   Player
+	effects EffectsSnapshot
+}
+
+func (p *Player) Save() PlayerSnapshot {
+	return PlayerSnapshot{player: p, value: *p, effects: p.effects.Save()}
+}
+
+// Restore returns the saved player to the saved state
+func (s PlayerSnapshot) Restore() *Player {
+	*s.player = s.value
+	s.player.effects.Restore(s.effects)
+	return s.player
+}
+
+// Progress is what a player carries from stage to stage
+type Progress struct {
+	Lives, Level, Score int
+}
+
+func (p *Player) Progress() Progress {
+	return Progress{Lives: p.lives, Level: p.level, Score: p.score}
+}
+
+func (p *Player) changeLevel(level int) {
+	if level >= 4 {
+		panic("player: level out of bounds [0, 4)")
+	}
+	p.level = level
+
+	switch p.level {
+	case 0:
+		p.bulletSpeed = 100
+		p.speed = 44
+	default:
+		p.bulletSpeed = 200
+		p.speed = 50
+	}
+}
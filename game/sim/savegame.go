@@ -0,0 +1,453 @@
+package sim
+
+import (
+	"battlecity/game/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/faiface/pixel"
+	"io"
+	"time"
+)
+
+// Saved games hold the whole simulation state, so the game continues exactly where it was saved.
+// Layout: magic, saveHeader, stage, destroyed bots, players, bots, bullets, bonus, global effects.
+// All numbers are big endian, strings are prefixed by their uint8 length
+
+// saveVersion must be increased on every incompatible change of the format
+const saveVersion = 3
+
+const saveMagic = "BCSV"
+
+var (
+	errSaveVersion = errors.New("sim: unsupported save version")
+	errBadSave     = errors.New("sim: malformed save")
+)
+
+const (
+	saveGrenadeCountsAsKills = 1 << iota
+	saveBotsTakeBonuses
+	saveNeutralBots
+)
+
+type saveHeader struct {
+	Version              uint16
+	StageNum             uint16
+	Players              uint8
+	Seed                 int64
+	Rules                uint8
+	Difficulty           uint8
+	Tick                 uint64
+	Rnd                  uint64
+	NewBotDuration       int64
+	StageClearedDuration int64
+	IsRoundOver          bool
+	RoundWinner          int8
+	HasMatch             bool
+	MatchRound           uint16
+	MatchWins            [2]uint16
+}
+
+type savedPlayer struct {
+	X, Y                float64
+	Direction           uint8
+	Level               uint8
+	Lives               int8
+	Score               int32
+	Flags               uint8 // see tankFlags
+	ImmunityDuration    int64
+	MaxImmunityDuration int64
+	CreationDuration    int64
+	SinceLastShot       int64
+	Bullets             [2]int16 // indexes of current bullets, -1 for none
+}
+
+type savedBot struct {
+	PoolIndex        uint8
+	X, Y             float64
+	Direction        uint8
+	HP               int8
+	Flags            uint8 // see tankFlags
+	CreationDuration int64
+	SinceLastShot    int64
+	StuckTime        int64
+	Bullet           int16 // index of the current bullet, -1 for none
+}
+
+type savedBullet struct {
+	X, Y      float64
+	Direction uint8
+	Speed     float64
+	IsBots    bool  // origin is a bot
+	Origin    uint8 // player index or bot's pool index
+	Destroyed bool
+}
+
+type savedBonus struct {
+	Type     uint8
+	X, Y     float64
+	Duration int64
+}
+
+// SaveGame writes the simulation state to out
+func (w *World) SaveGame(out io.Writer) error {
+	buf := new(bytes.Buffer)
+	write := func(v interface{}) {
+		_ = binary.Write(buf, binary.BigEndian, v)
+	}
+	writeString := func(str string) {
+		write(uint8(len(str)))
+		buf.WriteString(str)
+	}
+	writeEffects := func(effects *Effects) {
+		write(uint8(len(effects.active)))
+		for _, a := range effects.active {
+			writeString(string(a.effect.ID))
+			write(int64(a.elapsed))
+			player := int8(-1)
+			if a.player != nil {
+				player = int8(a.player.index)
+			}
+			write(player)
+		}
+	}
+
+	buf.WriteString(saveMagic)
+	header := saveHeader{
+		Version:              saveVersion,
+		StageNum:             uint16(w.stageNum),
+		Players:              uint8(len(w.players)),
+		Seed:                 w.config.Seed,
+		Difficulty:           uint8(w.config.Difficulty),
+		Tick:                 w.tick,
+		Rnd:                  w.rndSource.State(),
+		NewBotDuration:       int64(w.newBotDuration),
+		StageClearedDuration: int64(w.stageClearedDuration),
+		IsRoundOver:          w.isRoundOver,
+		RoundWinner:          int8(w.roundWinner),
+	}
+	for i, rule := range []bool{
+		w.config.Rules.GrenadeCountsAsKills, w.config.Rules.BotsTakeBonuses, w.config.Rules.NeutralBots,
+	} {
+		if rule {
+			header.Rules |= 1 << i
+		}
+	}
+	if m := w.config.Match; m != nil {
+		header.HasMatch = true
+		header.MatchRound = uint16(m.round)
+		header.MatchWins = [2]uint16{uint16(m.wins[0]), uint16(m.wins[1])}
+	}
+	write(header)
+
+	var stage StageSnapshot
+	w.stage.Save(&stage)
+	write(uint8(len(w.stage.botsPool)))
+	for _, botType := range w.stage.botsPool {
+		write(uint8(botType))
+	}
+	write(uint8(stage.botPoolIndex))
+	write(uint8(len(stage.hqs)))
+	for _, hq := range stage.hqs {
+		write([]bool{hq.isArmored, hq.isDestroyed})
+	}
+	for row := range stage.blocks {
+		for _, block := range stage.blocks[row] {
+			write([]uint8{block.kind[0], encodeQuadrants(block.quadrants)})
+		}
+	}
+	write(uint16(len(w.destroyedBots)))
+	for _, botType := range w.destroyedBots {
+		write(uint8(botType))
+	}
+
+	bulletIndex := func(bullet *Bullet) int16 {
+		for i, b := range w.bullets {
+			if b == bullet {
+				return int16(i)
+			}
+		}
+		return -1
+	}
+	for _, p := range w.players {
+		write(savedPlayer{
+			X: p.pos.X, Y: p.pos.Y, Direction: uint8(p.direction), Level: uint8(p.level), Lives: int8(p.lives),
+			Score: int32(p.score), Flags: tankFlags(p.immune, p.onCreation, p.hasShip, false),
+			ImmunityDuration: int64(p.immunityDuration), MaxImmunityDuration: int64(p.maxImmunityDuration),
+			CreationDuration: int64(p.creationDuration), SinceLastShot: int64(p.sinceLastShot),
+			Bullets: [2]int16{bulletIndex(p.currentBullet1), bulletIndex(p.currentBullet2)},
+		})
+		writeEffects(p.effects)
+	}
+	write(uint8(len(w.bots)))
+	for _, b := range w.bots {
+		write(savedBot{
+			PoolIndex: uint8(b.poolIndex), X: b.pos.X, Y: b.pos.Y, Direction: uint8(b.direction), HP: int8(b.hp),
+			Flags: tankFlags(false, b.onCreation, false, b.isBonus), CreationDuration: int64(b.creationDuration),
+			SinceLastShot: int64(b.sinceLastShot), StuckTime: int64(b.stuckTime), Bullet: bulletIndex(b.currentBullet),
+		})
+	}
+	write(uint16(len(w.bullets)))
+	for _, bullet := range w.bullets {
+		saved := savedBullet{
+			X: bullet.pos.X, Y: bullet.pos.Y, Direction: uint8(bullet.direction), Speed: bullet.speed,
+			Destroyed: bullet.destroyed,
+		}
+		switch origin := bullet.origin.(type) {
+		case *Player:
+			saved.Origin = uint8(origin.index)
+		case *Bot:
+			saved.IsBots, saved.Origin = true, uint8(origin.poolIndex)
+		}
+		write(saved)
+	}
+	write(w.activeBonus != nil)
+	if w.activeBonus != nil {
+		write(savedBonus{
+			Type: uint8(w.activeBonus.bonusType), X: w.activeBonus.pos.X, Y: w.activeBonus.pos.Y,
+			Duration: int64(w.activeBonus.duration),
+		})
+	}
+	writeEffects(w.effects)
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// LoadGame reads a game written by SaveGame, config provides everything but the simulation state
+func LoadGame(config Config, r io.Reader) (*World, error) {
+	var err error
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, v)
+		}
+	}
+	readString := func() string {
+		var n uint8
+		read(&n)
+		str := make([]byte, n)
+		read(str)
+		return string(str)
+	}
+	magic := make([]byte, len(saveMagic))
+	read(magic)
+	var header saveHeader
+	read(&header)
+	if err != nil || string(magic) != saveMagic {
+		return nil, errBadSave
+	}
+	if header.Version != saveVersion {
+		return nil, fmt.Errorf("%w %d", errSaveVersion, header.Version)
+	}
+
+	config.Players = int(header.Players)
+	config.Seed = header.Seed
+	config.Difficulty = Difficulty(header.Difficulty)
+	config.Rules.GrenadeCountsAsKills = header.Rules&saveGrenadeCountsAsKills != 0
+	config.Rules.BotsTakeBonuses = header.Rules&saveBotsTakeBonuses != 0
+	config.Rules.NeutralBots = header.Rules&saveNeutralBots != 0
+	config.Match = nil
+	if header.HasMatch {
+		if config.Match, err = NewMatch(config.StagesConfigs); err != nil {
+			return nil, err
+		}
+		config.Match.round = int(header.MatchRound)
+		config.Match.wins = [2]int{int(header.MatchWins[0]), int(header.MatchWins[1])}
+	}
+	if config.Players < 1 || config.Players > 2 || (header.HasMatch && config.Players != 2) ||
+		!config.Difficulty.Valid() || header.StageNum < 1 {
+		return nil, errBadSave
+	}
+	w, err := newWorld(config, int(header.StageNum), nil)
+	if err != nil {
+		return nil, err
+	}
+	w.tick = header.Tick
+	w.newBotDuration = time.Duration(header.NewBotDuration)
+	w.stageClearedDuration = time.Duration(header.StageClearedDuration)
+	w.isRoundOver = header.IsRoundOver
+	w.roundWinner = int(header.RoundWinner)
+
+	var n uint8
+	read(&n)
+	pool := make([]uint8, n)
+	read(pool)
+	w.stage.botsPool = nil
+	for _, botType := range pool {
+		if botType > uint8(ArmoredBot) {
+			return nil, errBadSave
+		}
+		w.stage.botsPool = append(w.stage.botsPool, BotType(botType))
+	}
+	var stage StageSnapshot
+	var botPoolIndex uint8
+	read(&botPoolIndex)
+	stage.botPoolIndex = int(botPoolIndex)
+	read(&n)
+	if int(n) != w.stage.Teams() {
+		return nil, errBadSave
+	}
+	for i := 0; i < int(n); i++ {
+		var flags [2]bool
+		read(&flags)
+		stage.hqs = append(stage.hqs, hq{isArmored: flags[0], isDestroyed: flags[1]})
+	}
+	for row := range stage.blocks {
+		for column := range stage.blocks[row] {
+			var block [2]uint8
+			read(&block)
+			stage.blocks[row][column] = decodeBlock(block[0], block[1])
+			if !isBlockKind(stage.blocks[row][column].kind) {
+				return nil, errBadSave
+			}
+		}
+	}
+	w.stage.Restore(&stage)
+	var destroyedBots uint16
+	read(&destroyedBots)
+	destroyed := make([]uint8, destroyedBots)
+	read(destroyed)
+	for _, botType := range destroyed {
+		w.destroyedBots = append(w.destroyedBots, BotType(botType))
+	}
+
+	readEffects := func(effects *Effects) {
+		var n uint8
+		read(&n)
+		var snapshot EffectsSnapshot
+		for i := 0; i < int(n); i++ {
+			id := EffectID(readString())
+			var elapsed int64
+			var player int8
+			read(&elapsed)
+			read(&player)
+			effect := effectByID(id)
+			if effect == nil || int(player) >= len(w.players) {
+				err = errBadSave
+				return
+			}
+			a := activeEffect{effect: effect, elapsed: time.Duration(elapsed)}
+			if player >= 0 {
+				a.player = w.players[player]
+			}
+			snapshot = append(snapshot, a)
+		}
+		effects.Restore(snapshot)
+	}
+	players := make([]savedPlayer, len(w.players))
+	for i, p := range w.players {
+		read(&players[i])
+		saved := players[i]
+		if saved.Level > MaxLevel {
+			return nil, errBadSave
+		}
+		p.pos = pixel.V(saved.X, saved.Y)
+		p.direction = utils.Direction(saved.Direction)
+		p.changeLevel(int(saved.Level))
+		p.lives = int(saved.Lives)
+		p.score = int(saved.Score)
+		p.immune = saved.Flags&tankImmune != 0
+		p.onCreation = saved.Flags&tankOnCreation != 0
+		p.hasShip = saved.Flags&tankHasShip != 0
+		p.immunityDuration = time.Duration(saved.ImmunityDuration)
+		p.maxImmunityDuration = time.Duration(saved.MaxImmunityDuration)
+		p.creationDuration = time.Duration(saved.CreationDuration)
+		p.sinceLastShot = time.Duration(saved.SinceLastShot)
+		readEffects(p.effects)
+	}
+	read(&n)
+	bots := make([]savedBot, n)
+	read(bots)
+	if err != nil {
+		return nil, errBadSave
+	}
+	w.bots = nil
+	for _, saved := range bots {
+		if int(saved.PoolIndex) >= len(w.stage.botsPool) {
+			return nil, errBadSave
+		}
+		b := NewBot(w.stage.botsPool[saved.PoolIndex], pixel.V(saved.X, saved.Y), false, w.rnd)
+		b.poolIndex = int(saved.PoolIndex)
+		b.direction = utils.Direction(saved.Direction)
+		b.hp = int(saved.HP)
+		b.isBonus = saved.Flags&tankIsBonus != 0
+		b.onCreation = saved.Flags&tankOnCreation != 0
+		b.creationDuration = time.Duration(saved.CreationDuration)
+		b.sinceLastShot = time.Duration(saved.SinceLastShot)
+		b.stuckTime = time.Duration(saved.StuckTime)
+		w.bots = append(w.bots, b)
+	}
+
+	var bulletsN uint16
+	read(&bulletsN)
+	bullets := make([]savedBullet, bulletsN)
+	read(bullets)
+	if err != nil {
+		return nil, errBadSave
+	}
+	w.bullets = nil
+	for _, saved := range bullets {
+		bullet := &Bullet{
+			pos: pixel.V(saved.X, saved.Y), direction: utils.Direction(saved.Direction), speed: saved.Speed,
+			destroyed: saved.Destroyed,
+		}
+		if saved.IsBots {
+			for _, b := range w.bots {
+				if b.poolIndex == int(saved.Origin) {
+					bullet.origin = b
+				}
+			}
+		} else if int(saved.Origin) < len(w.players) {
+			bullet.origin = w.players[saved.Origin]
+		}
+		if bullet.origin == nil { // e.g. the bot is destroyed, its bullets fly on
+			bullet.origin = NewBot(DefaultBot, pixel.ZV, false, w.rnd)
+		}
+		w.bullets = append(w.bullets, bullet)
+	}
+	bullet := func(i int16) *Bullet {
+		if i < 0 || int(i) >= len(w.bullets) {
+			return nil
+		}
+		return w.bullets[i]
+	}
+	for i, p := range w.players {
+		p.currentBullet1 = bullet(players[i].Bullets[0])
+		p.currentBullet2 = bullet(players[i].Bullets[1])
+	}
+	for i, b := range w.bots {
+		b.currentBullet = bullet(bots[i].Bullet)
+	}
+
+	var hasBonus bool
+	read(&hasBonus)
+	w.activeBonus = nil
+	if hasBonus {
+		var saved savedBonus
+		read(&saved)
+		if saved.Type >= uint8(len(bonusEffects)) {
+			return nil, errBadSave
+		}
+		w.activeBonus = newBonus(BonusType(saved.Type), pixel.V(saved.X, saved.Y))
+		w.activeBonus.duration = time.Duration(saved.Duration)
+	}
+	readEffects(w.effects)
+	if err != nil {
+		return nil, errBadSave
+	}
+	w.rndSource.Seed(int64(header.Rnd)) // bots creation above used the generator
+	return w, nil
+}
+
+// effectByID returns the effect of a bonus with id, nil if there is none
+func effectByID(id EffectID) *Effect {
+	for _, effects := range bonusEffects {
+		for _, effect := range []*Effect{effects.Player, effects.Bot} {
+			if effect != nil && effect.ID != "" && effect.ID == id {
+				return effect
+			}
+		}
+	}
+	return nil
+}
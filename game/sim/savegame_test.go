@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"battlecity/assets"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/faiface/pixel"
+	"testing"
+)
+
+// testInputs are inputs of all players at tick, players move around and fire
+func testInputs(tick, players int) []Input {
+	inputs := make([]Input, players)
+	for i := range inputs {
+		inputs[i] = []Input{InputUp, InputRight, InputDown, InputLeft, 0}[(tick/40+i)%5]
+		if tick%25 == i {
+			inputs[i] |= InputFire
+		}
+	}
+	return inputs
+}
+
+// newSavedWorld plays the first stage for a while, so there are bots, bullets and effects to save
+func newSavedWorld(t *testing.T) (*World, []byte) {
+	t.Helper()
+	w := NewWorld(Config{StagesConfigs: assets.Stages, Seed: 1, Players: 2}, 1, nil)
+	for tick := 0; tick < 600; tick++ {
+		w.Tick(testInputs(tick, 2))
+	}
+	w.playerTakeBonus(w.players[0], TimeStopBonus)
+	w.activeBonus = newBonus(LifeBonus, pixel.V(150, 150))
+	buf := new(bytes.Buffer)
+	if err := w.SaveGame(buf); err != nil {
+		t.Fatal(err)
+	}
+	return w, buf.Bytes()
+}
+
+func TestSaveGameRoundTrip(t *testing.T) {
+	w, data := newSavedWorld(t)
+	if len(w.bots) == 0 || len(w.effects.active) == 0 {
+		t.Fatalf("%d bots and %d effects are saved, want some", len(w.bots), len(w.effects.active))
+	}
+	loaded, err := LoadGame(Config{StagesConfigs: assets.Stages}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Hash() != w.Hash() {
+		t.Fatal("hashes differ after loading")
+	}
+	for tick := 600; tick < 1200; tick++ {
+		inputs := testInputs(tick, 2)
+		w.Tick(inputs)
+		loaded.Tick(inputs)
+		if loaded.Hash() != w.Hash() {
+			t.Fatalf("hashes differ at tick %d", tick)
+		}
+	}
+}
+
+func TestLoadGameErrors(t *testing.T) {
+	_, data := newSavedWorld(t)
+	badMagic := append([]byte("XXXX"), data[len(saveMagic):]...)
+	badVersion := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(badVersion[len(saveMagic):], saveVersion+1)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, errBadSave},
+		{"bad magic", badMagic, errBadSave},
+		{"wrong version", badVersion, errSaveVersion},
+	}
+	for _, cut := range []int{len(saveMagic) + 1, len(data) / 4, len(data) / 2, len(data) - 1} {
+		tests = append(tests, struct {
+			name string
+			data []byte
+			err  error
+		}{"truncated", data[:cut], errBadSave})
+	}
+	for _, test := range tests {
+		_, err := LoadGame(Config{StagesConfigs: assets.Stages}, bytes.NewReader(test.data))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s (%d bytes): error %v, want %v", test.name, len(test.data), err, test.err)
+		}
+	}
+}
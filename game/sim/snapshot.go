@@ -0,0 +1,76 @@
+package sim
+
+import (
+	"battlecity/game/utils"
+	"time"
+)
+
+// Snapshot is a copy of the simulation state, see World.Save.
+// Entities are saved together with pointers to them and restored in place,
+// so references between them (e.g. bullet's origin or player's current bullets) stay valid
+type Snapshot struct {
+	tick                 uint64
+	rnd                  utils.Source
+	stage                StageSnapshot
+	players              []PlayerSnapshot
+	bots                 []BotSnapshot
+	destroyedBots        []BotType
+	bullets              []BulletSnapshot
+	bonus                BonusSnapshot
+	effects              EffectsSnapshot
+	newBotDuration       time.Duration
+	stageClearedDuration time.Duration
+	isRoundOver          bool
+	roundWinner          int
+}
+
+// Save copies the simulation state to snapshot, its memory is reused
+func (w *World) Save(snapshot *Snapshot) {
+	snapshot.tick = w.tick
+	snapshot.rnd = *w.rndSource
+	w.stage.Save(&snapshot.stage)
+	snapshot.players = snapshot.players[:0]
+	for _, player := range w.players {
+		snapshot.players = append(snapshot.players, player.Save())
+	}
+	snapshot.bots = snapshot.bots[:0]
+	for _, b := range w.bots {
+		snapshot.bots = append(snapshot.bots, b.Save())
+	}
+	snapshot.destroyedBots = append(snapshot.destroyedBots[:0], w.destroyedBots...)
+	snapshot.bullets = snapshot.bullets[:0]
+	for _, bullet := range w.bullets {
+		snapshot.bullets = append(snapshot.bullets, bullet.Save())
+	}
+	snapshot.bonus = w.activeBonus.Save()
+	snapshot.effects = w.effects.Save()
+	snapshot.newBotDuration = w.newBotDuration
+	snapshot.stageClearedDuration = w.stageClearedDuration
+	snapshot.isRoundOver = w.isRoundOver
+	snapshot.roundWinner = w.roundWinner
+}
+
+// Restore returns the simulation to the saved state
+func (w *World) Restore(snapshot *Snapshot) {
+	w.tick = snapshot.tick
+	*w.rndSource = snapshot.rnd
+	w.stage.Restore(&snapshot.stage)
+	for i, player := range snapshot.players {
+		w.players[i] = player.Restore()
+	}
+	w.bots = make([]*Bot, len(snapshot.bots))
+	for i, b := range snapshot.bots {
+		w.bots[i] = b.Restore()
+	}
+	w.destroyedBots = append([]BotType(nil), snapshot.destroyedBots...)
+	w.bullets = make([]*Bullet, len(snapshot.bullets))
+	for i, bullet := range snapshot.bullets {
+		w.bullets[i] = bullet.Restore()
+	}
+	w.activeBonus = snapshot.bonus.Restore()
+	w.effects.Restore(snapshot.effects)
+	w.newBotDuration = snapshot.newBotDuration
+	w.stageClearedDuration = snapshot.stageClearedDuration
+	w.isRoundOver = snapshot.isRoundOver
+	w.roundWinner = snapshot.roundWinner
+}
@@ -0,0 +1,508 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/faiface/pixel"
+	"io/fs"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	StageColumns = 30
+	StageRows    = 30
+)
+
+// errNoArenas is returned when there are no arenas, the game is playable without versus then
+var errNoArenas = errors.New("no arenas")
+
+// hq is the base of a team, the team loses when it's destroyed
+type hq struct {
+	row, column int // of the top left block
+	isArmored   bool
+	isDestroyed bool
+}
+
+type Stage struct {
+	Blocks       [StageColumns][StageRows]*Block
+	revision     int // changes with blocks and HQs
+	botsPool     []BotType
+	botPoolIndex int
+	botsSpawnY   float64
+	hqs          []hq // by team
+	rnd          *rand.Rand
+}
+
+// NewStage loads the stage stageNum, arenas are stages of the versus mode with an HQ for each team.
+// Stages start over after the last one, stageNum keeps counting, so bots get tougher
+func NewStage(stagesConfigs fs.FS, stageNum int, isArena bool, rnd *rand.Rand) (*Stage, error) {
+	count, err := stagesCount(stagesConfigs, isArena)
+	if err != nil {
+		return nil, err
+	}
+	name := stageFileName((stageNum-1)%count+1, isArena)
+	data, err := fs.ReadFile(stagesConfigs, name)
+	if err != nil {
+		return nil, err
+	}
+	blocks, hqs, err := parseStage(data, isArena)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	stage := new(Stage)
+	stage.rnd = rnd
+	stage.Blocks = blocks
+	stage.hqs = hqs
+	stage.botsSpawnY = 27 * BlockSize
+	if isArena { // the top belongs to the second team
+		stage.botsSpawnY = 15 * BlockSize
+	}
+	stage.initBotsPool(stageNum, isArena)
+	return stage, nil
+}
+
+// CheckStages parses every stage and arena in stagesConfigs, so a broken file is reported before the game
+// starts. Both are numbered from 1 without gaps, there must be at least one stage. Arenas are optional,
+// NewMatch fails without them
+func CheckStages(stagesConfigs fs.FS) error {
+	for _, isArena := range []bool{false, true} {
+		count, err := stagesCount(stagesConfigs, isArena)
+		if errors.Is(err, errNoArenas) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for stageNum := 1; stageNum <= count; stageNum++ {
+			name := stageFileName(stageNum, isArena)
+			data, err := fs.ReadFile(stagesConfigs, name)
+			if err != nil {
+				return err
+			}
+			if _, _, err := parseStage(data, isArena); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func stageFileName(stageNum int, isArena bool) string {
+	if isArena {
+		return fmt.Sprintf("arena/%d.stage", stageNum)
+	}
+	return fmt.Sprintf("%d.stage", stageNum)
+}
+
+// stagesCount returns the number of stages or arenas, files which aren't numbered like template.stage
+// are skipped
+func stagesCount(stagesConfigs fs.FS, isArena bool) (int, error) {
+	kind, pattern := "stages", "*.stage"
+	if isArena {
+		kind, pattern = "arenas", "arena/*.stage"
+	}
+	names, err := fs.Glob(stagesConfigs, pattern)
+	if err != nil {
+		return 0, err
+	}
+	numbers := make(map[int]bool)
+	for _, name := range names {
+		stageNum, err := strconv.Atoi(strings.TrimSuffix(path.Base(name), ".stage"))
+		if err == nil && stageNum > 0 {
+			numbers[stageNum] = true
+		}
+	}
+	if len(numbers) == 0 && isArena {
+		return 0, errNoArenas
+	}
+	if len(numbers) == 0 {
+		return 0, errors.New("no stages")
+	}
+	for stageNum := 1; stageNum <= len(numbers); stageNum++ {
+		if !numbers[stageNum] {
+			return 0, fmt.Errorf("%s: missing %s", kind, stageFileName(stageNum, isArena))
+		}
+	}
+	return len(numbers), nil
+}
+
+// parseStage returns blocks and HQs of a stage file, a stage has the HQ of the first team, an arena of both
+func parseStage(data []byte, isArena bool) (blocks [StageColumns][StageRows]*Block, hqs []hq, err error) {
+	// maxStageChars + new line chars
+	maxChars := StageColumns*StageRows + StageColumns
+	if len(data) != maxChars {
+		return blocks, nil, fmt.Errorf("invalid length %d, want %d", len(data), maxChars)
+	}
+	var teamHQs [2]*hq
+	n := 0
+	for _, ch := range string(data) {
+		blockSymbol := string(ch)
+		if blockSymbol == "\n" {
+			continue
+		}
+		if n == StageColumns*StageRows {
+			return blocks, nil, fmt.Errorf("more than %d blocks", n)
+		}
+
+		row := n / 30
+		column := int(math.Mod(float64(n), 30))
+
+		shiftX, shiftY := BlockSize/2, BlockSize/2
+		x, y := float64(column)*BlockSize+shiftX, float64(30-row)*BlockSize-shiftY
+		pos := pixel.V(x, y)
+
+		team := 0
+		if blockSymbol == SecondHQBlock {
+			blockSymbol, team = HQBlock, 1
+		}
+		if blockSymbol == HQBlock && teamHQs[team] == nil { // the first block of an HQ is its top left one
+			teamHQs[team] = &hq{row: row, column: column}
+		}
+		block := NewBlock(blockSymbol, pos, row, column)
+		if block == nil {
+			return blocks, nil, fmt.Errorf("invalid block symbol %q at (%d, %d)", blockSymbol, row, column)
+		}
+		blocks[row][column] = block
+		n++
+	}
+	if n != StageColumns*StageRows {
+		return blocks, nil, fmt.Errorf("%d blocks, want %d", n, StageColumns*StageRows)
+	}
+	switch {
+	case teamHQs[0] == nil:
+		return blocks, nil, errors.New("no HQ")
+	case isArena && teamHQs[1] == nil:
+		return blocks, nil, errors.New("no HQ of the second team")
+	case !isArena && teamHQs[1] != nil:
+		return blocks, nil, errors.New("HQ of the second team outside an arena")
+	}
+	for _, hq := range teamHQs {
+		if hq != nil {
+			hqs = append(hqs, *hq)
+		}
+	}
+	return blocks, hqs, nil
+}
+
+// Revision changes whenever blocks or HQs change, so views know when to draw them again
+func (s *Stage) Revision() int {
+	return s.revision
+}
+
+// changed must be called when blocks or HQs change
+func (s *Stage) changed() {
+	s.revision++
+}
+
+// ArmorHQ replaces walls around HQ of team with steel
+func (s *Stage) ArmorHQ(team int) {
+	for _, hqArmorIndex := range s.getHQArmorIndexes(team) {
+		row := hqArmorIndex[0]
+		column := hqArmorIndex[1]
+		block := s.Blocks[row][column]
+		s.Blocks[row][column] = Steel(block.pos, block.row, block.column)
+	}
+	s.changed()
+	s.hqs[team].isArmored = true
+}
+
+func (s *Stage) DisarmorHQ(team int) {
+	for _, hqArmorIndex := range s.getHQArmorIndexes(team) {
+		row := hqArmorIndex[0]
+		column := hqArmorIndex[1]
+		block := s.Blocks[row][column]
+		s.Blocks[row][column] = Brick(block.pos, block.row, block.column)
+	}
+	s.changed()
+	s.hqs[team].isArmored = false
+}
+
+// DestroyHQArmor removes all walls around HQ of team
+func (s *Stage) DestroyHQArmor(team int) {
+	for _, hqArmorIndex := range s.getHQArmorIndexes(team) {
+		row := hqArmorIndex[0]
+		column := hqArmorIndex[1]
+		block := s.Blocks[row][column]
+		s.Blocks[row][column] = Space(block.pos, block.row, block.column)
+	}
+	s.changed()
+	s.hqs[team].isArmored = false
+}
+
+func (s *Stage) DestroyHQ(team int) {
+	for _, hqIndex := range s.getHQIndexes(team) {
+		row := hqIndex[0]
+		column := hqIndex[1]
+		block := s.Blocks[row][column]
+		s.Blocks[row][column] = Space(block.pos, block.row, block.column)
+	}
+	s.changed()
+	s.hqs[team].isDestroyed = true
+}
+
+func (s *Stage) IsHQArmored(team int) bool {
+	return s.hqs[team].isArmored
+}
+
+func (s *Stage) IsHQDestroyed(team int) bool {
+	return s.hqs[team].isDestroyed
+}
+
+// Teams returns the number of HQs
+func (s *Stage) Teams() int {
+	return len(s.hqs)
+}
+
+// HQTeam returns the team which HQ the block is part of, -1 if it isn't an HQ block
+func (s *Stage) HQTeam(block *Block) int {
+	for team := range s.hqs {
+		for _, hqIndex := range s.getHQIndexes(team) {
+			if hqIndex == [2]int{block.row, block.column} {
+				return team
+			}
+		}
+	}
+	return -1
+}
+
+// HQPos returns the center of HQ of team
+func (s *Stage) HQPos(team int) pixel.Vec {
+	return TankCellPos(s.hqs[team].row+1, s.hqs[team].column+1)
+}
+
+func (s *Stage) DestroyBlock(block *Block) {
+	s.Blocks[block.row][block.column] = Space(block.pos, block.row, block.column)
+	s.changed()
+}
+
+func (s *Stage) CreateBot(tanks []Tank) *Bot {
+	for {
+		randomColumn := float64(s.rnd.Intn(27-3) + 3)
+		newBotPos := pixel.V(randomColumn*BlockSize, s.botsSpawnY)
+		newBotRect := Rect(newBotPos, TankSize, TankSize)
+		noIntersection := true
+		for _, tank := range tanks {
+			tankRect := Rect(tank.Pos(), TankSize, TankSize)
+			intersect := tankRect.Intersect(newBotRect)
+			if intersect != pixel.ZR {
+				noIntersection = false
+				break
+			}
+		}
+		if noIntersection {
+			if s.IsPoolEmpty() {
+				return nil
+			}
+			isBonus := false
+			botType := s.botsPool[s.botPoolIndex]
+			if s.botPoolIndex == 3 || s.botPoolIndex == 10 || s.botPoolIndex == len(s.botsPool)-3 {
+				isBonus = true
+			}
+			b := NewBot(botType, newBotPos, isBonus, s.rnd)
+			b.poolIndex = s.botPoolIndex
+			s.botPoolIndex++
+			return b
+		}
+	}
+}
+
+// PassabilityMap reports for every tank position (see TankCell) whether a tank fits in it
+func (s *Stage) PassabilityMap() [StageRows][StageColumns]bool {
+	var m [StageRows][StageColumns]bool
+	for row := 1; row < StageRows; row++ {
+		for column := 1; column < StageColumns; column++ {
+			m[row][column] = s.Blocks[row-1][column-1].passable && s.Blocks[row-1][column].passable &&
+				s.Blocks[row][column-1].passable && s.Blocks[row][column].passable
+		}
+	}
+	return m
+}
+
+// ReachableCells returns tank cells reachable from pos in row-major order
+func (s *Stage) ReachableCells(pos pixel.Vec) [][2]int {
+	passability := s.PassabilityMap()
+	startRow, startColumn := TankCell(pos)
+	if !passability[startRow][startColumn] {
+		return nil
+	}
+	var visited [StageRows][StageColumns]bool
+	visited[startRow][startColumn] = true
+	queue := [][2]int{{startRow, startColumn}}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		for _, d := range [4][2]int{{-1, 0}, {0, 1}, {1, 0}, {0, -1}} {
+			row, column := cell[0]+d[0], cell[1]+d[1]
+			if row < 0 || row >= StageRows || column < 0 || column >= StageColumns {
+				continue
+			}
+			if passability[row][column] && !visited[row][column] {
+				visited[row][column] = true
+				queue = append(queue, [2]int{row, column})
+			}
+		}
+	}
+	var cells [][2]int
+	for row := range visited {
+		for column := range visited[row] {
+			if visited[row][column] {
+				cells = append(cells, [2]int{row, column})
+			}
+		}
+	}
+	return cells
+}
+
+// TankCell returns the nearest tank cell for pos.
+// Tank cell (row, column) is the point shared by blocks [row-1][column-1] and [row][column]
+func TankCell(pos pixel.Vec) (int, int) {
+	column := int(math.Round(pos.X / BlockSize))
+	row := StageRows - int(math.Round(pos.Y/BlockSize))
+	column = int(math.Max(1, math.Min(float64(column), StageColumns-1)))
+	row = int(math.Max(1, math.Min(float64(row), StageRows-1)))
+	return row, column
+}
+
+// TankCellPos is the reverse of TankCell
+func TankCellPos(row, column int) pixel.Vec {
+	return pixel.V(float64(column)*BlockSize, float64(StageRows-row)*BlockSize)
+}
+
+// StageSnapshot holds the state of blocks, HQs and bots pool, see Stage.Save
+type StageSnapshot struct {
+	blocks       [StageRows][StageColumns]blockSnapshot
+	botPoolIndex int
+	hqs          []hq
+}
+
+type blockSnapshot struct {
+	kind      string
+	quadrants [2][2]bool
+}
+
+// Save copies the mutable state of the stage to snapshot
+func (s *Stage) Save(snapshot *StageSnapshot) {
+	for row, blocks := range s.Blocks {
+		for column, block := range blocks {
+			snapshot.blocks[row][column] = blockSnapshot{kind: block.kind, quadrants: block.quadrants}
+		}
+	}
+	snapshot.botPoolIndex = s.botPoolIndex
+	snapshot.hqs = append(snapshot.hqs[:0], s.hqs...)
+}
+
+// Restore returns the stage to the saved state, blocks are recreated only if their kind has changed
+func (s *Stage) Restore(snapshot *StageSnapshot) {
+	for row, blocks := range s.Blocks {
+		for column, block := range blocks {
+			saved := snapshot.blocks[row][column]
+			if block.kind != saved.kind {
+				block = NewBlock(saved.kind, block.pos, row, column)
+				s.Blocks[row][column] = block
+				s.changed()
+			}
+			if block.quadrants != saved.quadrants {
+				block.quadrants = saved.quadrants
+				s.changed()
+			}
+		}
+	}
+	for team := range s.hqs {
+		if team >= len(snapshot.hqs) {
+			break
+		}
+		saved := snapshot.hqs[team]
+		if s.hqs[team].isDestroyed != saved.isDestroyed {
+			s.changed()
+		}
+		s.hqs[team].isArmored = saved.isArmored
+		s.hqs[team].isDestroyed = saved.isDestroyed
+	}
+	s.botPoolIndex = snapshot.botPoolIndex
+}
+
+func (s *Stage) IsPoolEmpty() bool {
+	return s.botPoolIndex >= len(s.botsPool)
+}
+
+// BotsLeft returns the number of bots which haven't appeared yet
+func (s *Stage) BotsLeft() int {
+	return len(s.botsPool) - s.botPoolIndex
+}
+
+// EmptyBotsPool removes bots which haven't appeared yet, e.g. in versus arenas without neutral bots
+func (s *Stage) EmptyBotsPool() {
+	s.botsPool = nil
+	s.botPoolIndex = 0
+}
+
+func (s *Stage) initBotsPool(stageNum int, isArena bool) {
+	// probability density function
+	var pdf [4]float64
+	avgBotsCount := 20
+	switch stageNum {
+	case 1:
+		pdf = [4]float64{0.88, 0.12, 0, 0}
+		avgBotsCount = 18
+	case 2:
+		pdf = [4]float64{0.7, 0.2, 0, 0.1}
+	case 3:
+		pdf = [4]float64{0.7, 0.2, 0, 0.1}
+	case 4:
+		pdf = [4]float64{0.1, 0.25, 0.5, 0.15}
+	default:
+		pdf = [4]float64{0.4, 0.25, 0.25, 0.1}
+	}
+	if isArena { // neutral bots only make the fight harder
+		pdf = [4]float64{0.7, 0.3, 0, 0}
+	}
+	avgBotsCountDiff := s.rnd.Intn(5) - 2 // [-2; 2]
+	botsCount := avgBotsCount + avgBotsCountDiff
+
+	// cumulative distribution function
+	cdf := make([]float64, 4)
+	cdf[0] = pdf[0]
+	for i := 1; i < 4; i++ {
+		cdf[i] = cdf[i-1] + pdf[i]
+	}
+
+	for i := 0; i < botsCount; i++ {
+		botType := DefaultBot
+		r := s.rnd.Float64()
+		for r > cdf[botType] {
+			botType++
+		}
+		s.botsPool = append(s.botsPool, botType)
+	}
+}
+
+// getHQArmorIndexes returns blocks around HQ of team, except the border
+func (s *Stage) getHQArmorIndexes(team int) [][2]int {
+	hq := s.hqs[team]
+	var indexes [][2]int
+	for row := hq.row - 1; row <= hq.row+2; row++ {
+		for column := hq.column - 1; column <= hq.column+2; column++ {
+			isHQ := row >= hq.row && row <= hq.row+1 && column >= hq.column && column <= hq.column+1
+			if isHQ || s.Blocks[row][column].kind == BorderBlock {
+				continue
+			}
+			indexes = append(indexes, [2]int{row, column})
+		}
+	}
+	return indexes
+}
+
+func (s *Stage) getHQIndexes(team int) [4][2]int {
+	hq := s.hqs[team]
+	return [4][2]int{
+		{hq.row, hq.column},
+		{hq.row, hq.column + 1},
+		{hq.row + 1, hq.column},
+		{hq.row + 1, hq.column + 1},
+	}
+}
@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"battlecity/assets"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testStagesFS returns stages with the built-in first stage and arena, files are changed by replacing them
+func testStagesFS(t *testing.T, files map[string]string) fstest.MapFS {
+	t.Helper()
+	stage, err := fs.ReadFile(assets.Stages, "1.stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arena, err := fs.ReadFile(assets.Stages, "arena/1.stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := fstest.MapFS{
+		"1.stage":       {Data: stage},
+		"arena/1.stage": {Data: arena},
+	}
+	for name, data := range files {
+		if data == "" {
+			delete(stages, name)
+		} else {
+			stages[name] = &fstest.MapFile{Data: []byte(data)}
+		}
+	}
+	return stages
+}
+
+func TestCheckStages(t *testing.T) {
+	if err := CheckStages(assets.Stages); err != nil {
+		t.Fatalf("built-in stages: %v", err)
+	}
+	stage := string(testStagesFS(t, nil)["1.stage"].Data)
+	arena := string(testStagesFS(t, nil)["arena/1.stage"].Data)
+	tests := []struct {
+		name  string
+		files map[string]string // "" removes the file
+		err   string
+	}{
+		{"valid", map[string]string{"2.stage": stage, "template.stage": "?"}, ""},
+		{"no stages", map[string]string{"1.stage": ""}, "no stages"},
+		{"no arenas", map[string]string{"arena/1.stage": ""}, ""},
+		{"arena gap", map[string]string{"arena/1.stage": "", "arena/2.stage": arena}, "missing arena/1.stage"},
+		{"gap", map[string]string{"3.stage": stage}, "missing 2.stage"},
+		{"short", map[string]string{"1.stage": stage[:len(stage)-1]}, "invalid length"},
+		{"symbol", map[string]string{"1.stage": strings.Replace(stage, " ", "?", 1)}, "invalid block symbol"},
+		{"rows", map[string]string{"1.stage": strings.Replace(stage, "\n", BorderBlock, 1)}, "more than"},
+		{"columns", map[string]string{"1.stage": strings.Replace(stage, "|\n", "\n\n", 1)}, "blocks, want"},
+		{"no HQ", map[string]string{"1.stage": strings.Replace(stage, HQBlock, SpaceBlock, -1)}, "no HQ"},
+		{"second HQ", map[string]string{"1.stage": strings.Replace(stage, HQBlock, SecondHQBlock, 1)}, "outside an arena"},
+		{"one team", map[string]string{"arena/1.stage": strings.Replace(arena, SecondHQBlock, HQBlock, -1)}, "second team"},
+	}
+	for _, test := range tests {
+		err := CheckStages(testStagesFS(t, test.files))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestNewStageStartsOver(t *testing.T) {
+	count, err := stagesCount(assets.Stages, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewStage(assets.Stages, 1, false, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewStage(assets.Stages, count+1, false, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("stage after the last one: %v", err)
+	}
+	for row := range first.Blocks {
+		for column, block := range first.Blocks[row] {
+			if again.Blocks[row][column].kind != block.kind {
+				t.Fatalf("stage %d differs from stage 1 at (%d, %d)", count+1, row, column)
+			}
+		}
+	}
+	if _, err := NewMatch(testStagesFS(t, map[string]string{"arena/1.stage": ""})); err == nil {
+		t.Error("match without arenas")
+	}
+}
@@ -0,0 +1,395 @@
+package sim
+
+import (
+	"battlecity/game/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/faiface/pixel"
+	"time"
+)
+
+// The state sent by the dedicated server holds only what clients need to draw the game.
+// Blocks are sent in full or as a delta to the stage of a state the client has acknowledged.
+// Layout: stateHeader, bots pool, HQs, players, bots, bullets, bonus, blocks, explosions. All numbers are big endian
+
+var errBadState = errors.New("sim: malformed state")
+
+const (
+	stateTimeStop = 1 << iota
+	stateFullBlocks
+)
+
+const (
+	hqArmored = 1 << iota
+	hqDestroyed
+)
+
+const (
+	tankImmune = 1 << iota
+	tankOnCreation
+	tankHasShip
+	tankIsBonus
+)
+
+type stateHeader struct {
+	StageNum     uint16
+	BotPoolIndex uint8
+	Flags        uint8
+}
+
+type playerState struct {
+	X, Y      float32
+	Direction uint8
+	Level     uint8
+	Lives     int8
+	Score     uint32
+	Input     uint8
+	Flags     uint8
+}
+
+type botState struct {
+	PoolIndex uint8
+	X, Y      float32
+	Direction uint8
+	HP        int8
+	Flags     uint8
+}
+
+type bulletState struct {
+	X, Y      float32
+	Direction uint8
+}
+
+type bonusState struct {
+	Type     uint8
+	X, Y     float32
+	Duration uint32 // ms
+}
+
+type blockState struct {
+	Index     uint16 // row * StageColumns + column, only in deltas
+	Kind      uint8
+	Quadrants uint8
+}
+
+// ExplosionState is an explosion happened on the server since the state with tick Tick, see Explosion
+type ExplosionState struct {
+	Tick uint32
+	Type uint8
+	X, Y float32
+}
+
+// EncodeState writes the state of w, stage is its saved stage. Blocks are a delta to baseline if it's not nil
+func (w *World) EncodeState(baseline *StageSnapshot, stage *StageSnapshot, exps []ExplosionState) []byte {
+	buf := new(bytes.Buffer)
+	write := func(v interface{}) {
+		_ = binary.Write(buf, binary.BigEndian, v)
+	}
+	header := stateHeader{StageNum: uint16(w.stageNum), BotPoolIndex: uint8(w.stage.botPoolIndex)}
+	if w.effects.IsActive(TimeStopEffect) {
+		header.Flags |= stateTimeStop
+	}
+	if baseline == nil {
+		header.Flags |= stateFullBlocks
+	}
+	write(header)
+	write(uint8(len(w.stage.botsPool)))
+	for _, botType := range w.stage.botsPool {
+		write(uint8(botType))
+	}
+	write(uint8(len(w.stage.hqs)))
+	for _, hq := range w.stage.hqs {
+		var flags uint8
+		if hq.isArmored {
+			flags |= hqArmored
+		}
+		if hq.isDestroyed {
+			flags |= hqDestroyed
+		}
+		write(flags)
+	}
+
+	write(uint8(len(w.players)))
+	for _, p := range w.players {
+		state := playerState{
+			X: float32(p.pos.X), Y: float32(p.pos.Y), Direction: uint8(p.direction),
+			Level: uint8(p.level), Lives: int8(p.lives), Score: uint32(p.score), Input: uint8(p.input),
+		}
+		state.Flags = tankFlags(p.immune, p.onCreation, p.hasShip, false)
+		write(state)
+	}
+	write(uint8(len(w.bots)))
+	for _, b := range w.bots {
+		write(botState{
+			PoolIndex: uint8(b.poolIndex), X: float32(b.pos.X), Y: float32(b.pos.Y), Direction: uint8(b.direction),
+			HP: int8(b.hp), Flags: tankFlags(false, b.onCreation, false, b.isBonus),
+		})
+	}
+	write(uint8(len(w.bullets)))
+	for _, bullet := range w.bullets {
+		write(bulletState{X: float32(bullet.pos.X), Y: float32(bullet.pos.Y), Direction: uint8(bullet.direction)})
+	}
+	if w.activeBonus == nil {
+		write(uint8(0))
+	} else {
+		write(uint8(1))
+		write(bonusState{
+			Type: uint8(w.activeBonus.bonusType), X: float32(w.activeBonus.pos.X), Y: float32(w.activeBonus.pos.Y),
+			Duration: uint32(w.activeBonus.duration / time.Millisecond),
+		})
+	}
+
+	var blocks []blockState
+	for row := range stage.blocks {
+		for column, block := range stage.blocks[row] {
+			if baseline != nil && baseline.blocks[row][column] == block {
+				continue
+			}
+			blocks = append(blocks, blockState{
+				Index: uint16(row*StageColumns + column), Kind: block.kind[0], Quadrants: encodeQuadrants(block.quadrants),
+			})
+		}
+	}
+	write(uint16(len(blocks)))
+	for _, block := range blocks {
+		if baseline == nil { // the index is implied
+			write([]uint8{block.Kind, block.Quadrants})
+		} else {
+			write(block)
+		}
+	}
+
+	write(uint8(len(exps)))
+	for _, e := range exps {
+		write(e)
+	}
+	return buf.Bytes()
+}
+
+// DecodedState is the state received from the server, see EncodeState
+type DecodedState struct {
+	header     stateHeader
+	botsPool   []BotType
+	players    []playerState
+	bots       []botState
+	bullets    []bulletState
+	bonus      *bonusState
+	stage      StageSnapshot
+	explosions []ExplosionState
+}
+
+// StageNum returns the number of the stage of the state
+func (d *DecodedState) StageNum() int {
+	return int(d.header.StageNum)
+}
+
+// Players returns the number of players
+func (d *DecodedState) Players() int {
+	return len(d.players)
+}
+
+// Stage returns the stage, next states may be deltas to it
+func (d *DecodedState) Stage() *StageSnapshot {
+	return &d.stage
+}
+
+// Explosions returns explosions since an earlier state, see ExplosionState
+func (d *DecodedState) Explosions() []ExplosionState {
+	return d.explosions
+}
+
+// DecodeState parses data, blocks not sent in a delta are taken from baseline
+func DecodeState(data []byte, baseline func(stageNum int) (*StageSnapshot, bool)) (*DecodedState, error) {
+	r := bytes.NewReader(data)
+	var err error
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, v)
+		}
+	}
+	var n uint8
+	d := new(DecodedState)
+	read(&d.header)
+	read(&n)
+	pool := make([]uint8, n)
+	read(pool)
+	for _, botType := range pool {
+		d.botsPool = append(d.botsPool, BotType(botType))
+	}
+	read(&n)
+	hqs := make([]uint8, n)
+	read(hqs)
+	read(&n)
+	d.players = make([]playerState, n)
+	read(d.players)
+	read(&n)
+	d.bots = make([]botState, n)
+	read(d.bots)
+	read(&n)
+	d.bullets = make([]bulletState, n)
+	read(d.bullets)
+	read(&n)
+	if n != 0 {
+		d.bonus = new(bonusState)
+		read(d.bonus)
+		if int(d.bonus.Type) >= len(bonusEffects) {
+			return nil, errBadState
+		}
+	}
+
+	var blocksN uint16
+	read(&blocksN)
+	if d.header.Flags&stateFullBlocks != 0 {
+		if blocksN != StageRows*StageColumns {
+			return nil, errBadState
+		}
+		blocks := make([][2]uint8, blocksN)
+		read(blocks)
+		for i, block := range blocks {
+			d.stage.blocks[i/StageColumns][i%StageColumns] = decodeBlock(block[0], block[1])
+		}
+	} else {
+		base, ok := baseline(int(d.header.StageNum))
+		if !ok {
+			return nil, errBadState
+		}
+		d.stage = *base
+		blocks := make([]blockState, blocksN)
+		read(blocks)
+		for _, block := range blocks {
+			if int(block.Index) >= StageRows*StageColumns {
+				return nil, errBadState
+			}
+			d.stage.blocks[block.Index/StageColumns][block.Index%StageColumns] = decodeBlock(block.Kind, block.Quadrants)
+		}
+	}
+	for row := range d.stage.blocks {
+		for _, block := range d.stage.blocks[row] {
+			if !isBlockKind(block.kind) {
+				return nil, errBadState
+			}
+		}
+	}
+	d.stage.botPoolIndex = int(d.header.BotPoolIndex)
+	d.stage.hqs = nil // positions are known from the stage file
+	for _, flags := range hqs {
+		d.stage.hqs = append(d.stage.hqs, hq{isArmored: flags&hqArmored != 0, isDestroyed: flags&hqDestroyed != 0})
+	}
+
+	read(&n)
+	d.explosions = make([]ExplosionState, n)
+	read(d.explosions)
+	if err != nil {
+		return nil, errBadState
+	}
+	return d, nil
+}
+
+// ApplyState makes w look like the decoded state, the simulation isn't run
+func (w *World) ApplyState(d *DecodedState) {
+	w.stage.botsPool = d.botsPool
+	w.stage.Restore(&d.stage)
+	for i, state := range d.players {
+		if i >= len(w.players) {
+			break
+		}
+		p := w.players[i]
+		p.pos = pixel.V(float64(state.X), float64(state.Y))
+		p.direction = utils.Direction(state.Direction)
+		if int(state.Level) != p.level && int(state.Level) <= MaxLevel {
+			p.changeLevel(int(state.Level))
+		}
+		p.lives = int(state.Lives)
+		p.score = int(state.Score)
+		p.input = Input(state.Input)
+		p.onCreation = state.Flags&tankOnCreation != 0
+		p.immune = state.Flags&tankImmune != 0
+		p.hasShip = state.Flags&tankHasShip != 0
+	}
+
+	bots := make(map[int]*Bot, len(w.bots))
+	for _, b := range w.bots {
+		bots[b.poolIndex] = b
+	}
+	w.bots = w.bots[:0]
+	for _, state := range d.bots {
+		b, ok := bots[int(state.PoolIndex)]
+		if !ok {
+			if int(state.PoolIndex) >= len(d.botsPool) {
+				continue
+			}
+			botType := d.botsPool[state.PoolIndex]
+			b = NewBot(botType, pixel.ZV, false, w.rnd)
+			b.poolIndex = int(state.PoolIndex)
+		}
+		b.pos = pixel.V(float64(state.X), float64(state.Y))
+		b.direction = utils.Direction(state.Direction)
+		b.hp = int(state.HP)
+		b.isBonus = state.Flags&tankIsBonus != 0
+		b.onCreation = state.Flags&tankOnCreation != 0
+		w.bots = append(w.bots, b)
+	}
+
+	w.bullets = w.bullets[:0]
+	for _, state := range d.bullets {
+		w.bullets = append(w.bullets, &Bullet{
+			pos: pixel.V(float64(state.X), float64(state.Y)), direction: utils.Direction(state.Direction),
+		})
+	}
+
+	if d.bonus == nil {
+		w.activeBonus = nil
+	} else {
+		pos := pixel.V(float64(d.bonus.X), float64(d.bonus.Y))
+		if w.activeBonus == nil || w.activeBonus.bonusType != BonusType(d.bonus.Type) || w.activeBonus.pos != pos {
+			w.activeBonus = newBonus(BonusType(d.bonus.Type), pos)
+		}
+		w.activeBonus.duration = time.Duration(d.bonus.Duration) * time.Millisecond
+	}
+
+	isTimeStop := d.header.Flags&stateTimeStop != 0
+	if isTimeStop != w.effects.IsActive(TimeStopEffect) {
+		var effects EffectsSnapshot
+		if isTimeStop {
+			effects = EffectsSnapshot{{effect: bonusEffects[TimeStopBonus].Player}}
+		}
+		w.effects.Restore(effects)
+	}
+}
+
+func tankFlags(immune, onCreation, hasShip, isBonus bool) uint8 {
+	var flags uint8
+	for i, flag := range []bool{immune, onCreation, hasShip, isBonus} {
+		if flag {
+			flags |= 1 << i
+		}
+	}
+	return flags
+}
+
+func encodeQuadrants(quadrants [2][2]bool) uint8 {
+	var bits uint8
+	for i := 0; i < 4; i++ {
+		if quadrants[i/2][i%2] {
+			bits |= 1 << i
+		}
+	}
+	return bits
+}
+
+func isBlockKind(kind string) bool {
+	switch kind {
+	case BorderBlock, BrickBlock, SteelBlock, WaterBlock, HQBlock, TreesBlock, SpaceBlock:
+		return true
+	}
+	return false
+}
+
+func decodeBlock(kind, quadrants uint8) blockSnapshot {
+	block := blockSnapshot{kind: string([]byte{kind})}
+	for i := 0; i < 4; i++ {
+		block.quadrants[i/2][i%2] = quadrants&(1<<i) != 0
+	}
+	return block
+}
@@ -1,9 +1,9 @@
-package game
+package sim
 
 import "github.com/faiface/pixel"
 
 func Rect(pos pixel.Vec, w float64, h float64) pixel.Rect {
-	w, h = w*Scale/2, h*Scale/2
+	w, h = w/2, h/2
 	return pixel.R(pos.X-w, pos.Y-h, pos.X+w, pos.Y+h)
 }
 
@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"battlecity/assets"
+	"github.com/faiface/pixel"
+	"testing"
+)
+
+// newTestVersus starts the first round of a new match, players can be hit at once
+func newTestVersus(t *testing.T) *World {
+	t.Helper()
+	match, err := NewMatch(assets.Stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWorld(Config{StagesConfigs: assets.Stages, Seed: 1, Players: 2, Match: match}, match.Arena(), nil)
+	for _, player := range w.players {
+		player.immune, player.onCreation = false, false
+	}
+	return w
+}
+
+// fireAt puts a bullet of tank right at pos, it hits there on the next tick
+func fireAt(w *World, tank Tank, pos pixel.Vec) {
+	w.bullets = append(w.bullets, &Bullet{origin: tank, pos: pos, direction: tank.Direction()})
+}
+
+func TestVersusFriendlyFire(t *testing.T) {
+	coop := NewWorld(Config{StagesConfigs: assets.Stages, Seed: 1, Players: 2}, 1, nil)
+	for _, player := range coop.players {
+		player.immune, player.onCreation = false, false
+	}
+	tests := []struct {
+		name  string
+		w     *World
+		lives int
+	}{
+		{"co-op", coop, 2},
+		{"versus", newTestVersus(t), 1},
+	}
+	for _, test := range tests {
+		shooter, target := test.w.players[0], test.w.players[1]
+		fireAt(test.w, shooter, target.pos)
+		test.w.Tick([]Input{0, 0})
+		if target.lives != test.lives {
+			t.Errorf("%s: the hit player has %d lives, want %d", test.name, target.lives, test.lives)
+		}
+		if shooter.lives != 2 {
+			t.Errorf("%s: the shooter has %d lives, want 2", test.name, shooter.lives)
+		}
+	}
+}
+
+func TestVersusRoundWinner(t *testing.T) {
+	tests := []struct {
+		name   string
+		hit    func(w *World)
+		winner int
+	}{
+		{"opposing HQ", func(w *World) {
+			fireAt(w, w.players[0], w.stage.HQPos(1))
+		}, 0},
+		{"own HQ", func(w *World) {
+			fireAt(w, w.players[1], w.stage.HQPos(1))
+		}, 0},
+		{"last life", func(w *World) {
+			w.players[0].lives = 0
+			fireAt(w, w.players[1], w.players[0].pos)
+		}, 1},
+		{"both HQs", func(w *World) {
+			fireAt(w, w.players[0], w.stage.HQPos(1))
+			fireAt(w, w.players[1], w.stage.HQPos(0))
+		}, -1},
+		{"both last lives", func(w *World) {
+			w.players[0].lives, w.players[1].lives = 0, 0
+			fireAt(w, w.players[0], w.players[1].pos)
+			fireAt(w, w.players[1], w.players[0].pos)
+		}, -1},
+	}
+	for _, test := range tests {
+		w := newTestVersus(t)
+		w.Tick([]Input{0, 0})
+		if w.IsOver() {
+			t.Fatalf("%s: the round is over before the hit", test.name)
+		}
+		test.hit(w)
+		w.Tick([]Input{0, 0})
+		if !w.IsOver() {
+			t.Errorf("%s: the round isn't over", test.name)
+			continue
+		}
+		if winner := w.RoundWinner(); winner != test.winner {
+			t.Errorf("%s: team %d won the round, want %d", test.name, winner, test.winner)
+		}
+	}
+}
+
+func TestMatchWinner(t *testing.T) {
+	tests := []struct {
+		rounds []int // winners of rounds, -1 for a draw
+		winner int
+		score  string
+	}{
+		{[]int{0}, -1, "1 - 0"},
+		{[]int{-1, -1, -1}, -1, "0 - 0"},
+		{[]int{0, 1}, -1, "1 - 1"},
+		{[]int{0, -1, 0}, 0, "2 - 0"},
+		{[]int{1, 0, 1}, 1, "1 - 2"},
+	}
+	for _, test := range tests {
+		match, err := NewMatch(assets.Stages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, team := range test.rounds {
+			match.EndRound(team)
+		}
+		if match.Winner() != test.winner || match.Score() != test.score {
+			t.Errorf("rounds %v: winner %d with %s, want %d with %s",
+				test.rounds, match.Winner(), match.Score(), test.winner, test.score)
+		}
+		if match.Round() != len(test.rounds)+1 {
+			t.Errorf("rounds %v: round %d is next", test.rounds, match.Round())
+		}
+	}
+}
@@ -0,0 +1,662 @@
+// Package sim simulates the game. It neither draws nor plays sounds, so the dedicated server runs it without
+// a window, the game draws it and plays sounds of its events
+package sim
+
+import (
+	"battlecity/game/utils"
+	"encoding/binary"
+	"fmt"
+	"github.com/faiface/pixel"
+	"hash/fnv"
+	"io/fs"
+	"math/rand"
+	"time"
+)
+
+// TickDt is the duration of one simulation tick in seconds
+const TickDt = 1.0 / 60
+
+// Config is what the simulation of a game depends on, peers of a network game must have the same one
+type Config struct {
+	StagesConfigs fs.FS // stage files like 1.stage and arena/1.stage
+	Rules         Rules
+	Difficulty    Difficulty
+	Seed          int64
+	Players       int    // number of players
+	Match         *Match // versus match in progress, nil in co-op
+}
+
+// Rules are optional gameplay rules
+type Rules struct {
+	GrenadeCountsAsKills bool // bots destroyed by grenade give score and count as kills
+	BotsTakeBonuses      bool // bots can pick up bonuses with their own effects
+	NeutralBots          bool // bots appear in versus arenas and attack both players
+}
+
+// World is a stage or a versus round being played
+type World struct {
+	config               Config
+	stageNum             int
+	stage                *Stage
+	players              []*Player
+	bots                 []*Bot // in order of creation
+	destroyedBots        []BotType
+	activeBonus          *Bonus
+	bullets              []*Bullet
+	newBotInterval       time.Duration
+	newBotDuration       time.Duration
+	stageClearedDuration time.Duration // since the stage is cleared or the versus round is decided
+	isRoundOver          bool
+	roundWinner          int      // team, -1 for a draw
+	effects              *Effects // global effects
+	events               *Events
+	rndSource            *utils.Source
+	rnd                  *rand.Rand
+	tick                 uint64
+}
+
+// NewWorld starts the stage stageNum, or the arena in versus. Players come from the previous stage, nil starts a new game.
+// Stages must be checked by CheckStages beforehand
+func NewWorld(config Config, stageNum int, players []*Player) *World {
+	w, err := newWorld(config, stageNum, players)
+	if err != nil {
+		panic(fmt.Sprintf("world: %v", err))
+	}
+	return w
+}
+
+func newWorld(config Config, stageNum int, players []*Player) (*World, error) {
+	w := new(World)
+	w.config = config
+	w.stageNum = stageNum
+	w.rndSource = utils.NewSource(w.config.Seed + int64(w.stageNum))
+	w.rnd = rand.New(w.rndSource)
+	w.events = NewEvents()
+	w.events.Subscribe(score)
+	if players == nil {
+		for i := 0; i < w.config.Players; i++ {
+			player := NewPlayer(i)
+			player.ResetLevel()
+			players = append(players, player)
+		}
+	}
+	w.players = players
+	for _, player := range w.players {
+		if w.isVersus() && player.index == 1 { // the second team starts at the top of the arena
+			player.SetSpawn(TankCellPos(3, 19), utils.South)
+		}
+		player.Respawn()
+	}
+	w.effects = NewEffects()
+	w.newBotInterval = w.config.Difficulty.newBotInterval()
+	stage, err := NewStage(w.config.StagesConfigs, w.stageNum, w.isVersus(), w.rnd)
+	if err != nil {
+		return nil, err
+	}
+	w.stage = stage
+	if w.isVersus() && !w.config.Rules.NeutralBots {
+		w.stage.EmptyBotsPool()
+	}
+	return w, nil
+}
+
+// Config returns the config the world is simulated with
+func (w *World) Config() Config {
+	return w.config
+}
+
+// Events are published by ticks, subscribers are called in order of subscription
+func (w *World) Events() *Events {
+	return w.events
+}
+
+func (w *World) StageNum() int {
+	return w.stageNum
+}
+
+func (w *World) Stage() *Stage {
+	return w.stage
+}
+
+// Players returns players by index
+func (w *World) Players() []*Player {
+	return w.players
+}
+
+// Bots returns bots in order of creation
+func (w *World) Bots() []*Bot {
+	return w.bots
+}
+
+func (w *World) Bullets() []*Bullet {
+	return w.bullets
+}
+
+// Bonus returns the bonus on the stage, nil if there is none
+func (w *World) Bonus() *Bonus {
+	return w.activeBonus
+}
+
+// IsTimeStopped reports whether bots are stopped by TimeStopBonus
+func (w *World) IsTimeStopped() bool {
+	return w.effects.IsActive(TimeStopEffect)
+}
+
+// Ticks returns the number of simulated ticks, events of a tick are published when it's already counted
+func (w *World) Ticks() uint64 {
+	return w.tick
+}
+
+// RoundWinner returns the team which won the versus round, -1 for a draw or if the round goes on
+func (w *World) RoundWinner() int {
+	return w.roundWinner
+}
+
+// RestartPlayers returns players to progress they had, e.g. at the start of the stage. Their effects are over
+func (w *World) RestartPlayers(progress []Progress) {
+	for i, player := range w.players {
+		player.lives, player.score = progress[i].Lives, progress[i].Score
+		player.changeLevel(progress[i].Level)
+		player.effects.Clear(w)
+	}
+}
+
+// Tick advances the simulation by TickDt using inputs of all players.
+// It returns true without advancing it when the stage or the versus round has been over for a while
+func (w *World) Tick(inputs []Input) bool {
+	dt := TickDt
+	w.tick++
+	if w.IsOver() {
+		if w.stageClearedDuration == 0 && !w.isVersus() {
+			w.events.Publish(StageCleared{Stage: w.stageNum})
+		}
+		if w.stageClearedDuration >= time.Second*3 {
+			return true
+		}
+		w.stageClearedDuration += time.Duration(dt * float64(time.Second))
+	}
+
+	const maxBots = 4
+	tanks := w.Tanks()
+	gameOver := w.isGameOver()
+
+	for i, player := range w.players {
+		player.SetInput(inputs[i])
+		player.Update(dt)
+	}
+	for i, player := range w.players {
+		w.events.Publish(PlayerMoved{Player: player, Moving: inputs[i].IsMoving() && !player.IsOut()})
+	}
+	for _, b := range w.bots {
+		b.Update(dt)
+	}
+	// handle bots creation
+	canCreate := w.newBotDuration > w.newBotInterval || (len(w.destroyedBots) == 0 && len(w.bots) == 0)
+	w.newBotDuration += time.Duration(dt * float64(time.Second))
+	if len(w.bots) < maxBots && canCreate {
+		if newBot := w.stage.CreateBot(tanks); newBot != nil {
+			w.bots = append(w.bots, newBot)
+			w.newBotDuration = 0
+		}
+	}
+
+	// handle *all* tanks movement
+	movementResults := make([]*MovementResult, len(tanks))
+	for id, tank := range tanks {
+		newPos, newDirection := tank.CalculateMovement(dt)
+		movementResults[id] = &MovementResult{newPos: newPos, direction: newDirection, canMove: true}
+	}
+	for _, blocks := range w.stage.Blocks {
+		for _, block := range blocks {
+			if !block.passable {
+				blockRect := Rect(block.pos, BlockSize, BlockSize)
+				for id, tank := range tanks {
+					movementRes := movementResults[id]
+					if tank.Pos() == movementRes.newPos { // tank didn't move
+						continue
+					}
+					if w.canSwim(tank, block) {
+						continue
+					}
+					tankRect := Rect(movementRes.newPos, TankSize, TankSize)
+					intersect := tankRect.Intersect(blockRect)
+					if intersect != pixel.ZR { // collision detected
+						movementRes.canMove = false
+					}
+				}
+			}
+		}
+	}
+	for idI := range tanks {
+		movementResultI := movementResults[idI]
+		if !movementResultI.canMove { // already can't move - skip
+			continue
+		}
+		tankIRect := Rect(movementResultI.newPos, TankSize, TankSize)
+		for idJ, tankJ := range tanks {
+			if idI == idJ { // don't compare with itself - skip
+				continue
+			}
+			tankJRect := Rect(tankJ.Pos(), TankSize, TankSize)
+
+			intersect := tankIRect.Intersect(tankJRect)
+			if intersect != pixel.ZR { // collision detected
+				movementResultI.canMove = false
+			}
+		}
+	}
+
+	w.effects.Update(w, dt)
+	for _, player := range w.players {
+		player.effects.Update(w, dt)
+	}
+	for id, tank := range tanks {
+		if w.canAct(tank) {
+			tank.Move(movementResults[id], dt)
+		}
+	}
+
+	w.bonusUpdate(dt)
+
+	// handle bullets movement & collision
+	for i := 0; i < len(w.bullets); i++ {
+		bullet := w.bullets[i]
+		if bullet.destroyed { // by another bullet
+			continue
+		}
+		bullet.Move(dt)
+
+		width, height := BulletW, BulletH
+		if bullet.direction.IsHorizontal() {
+			width, height = height, width
+		}
+		bulletRect := Rect(bullet.pos, width, height)
+		var collidedDestroyableBlocks []*Block
+		collision, hitSteel := false, false
+		for _, blocks := range w.stage.Blocks { // check collision between bullet and blocks
+			for _, block := range blocks {
+				if !block.shootable {
+					blockRect := Rect(block.pos, BlockSize, BlockSize)
+					intersect := bulletRect.Intersect(blockRect)
+					if intersect != pixel.ZR { // collision detected
+						if block.destroyable || (block.kind == SteelBlock && bullet.IsUpgraded()) {
+							if block.kind == HQBlock {
+								team := w.stage.HQTeam(block)
+								w.stage.DestroyHQ(team)
+								w.events.Publish(HQDestroyed{Team: team, Pos: w.stage.HQPos(team)})
+							} else {
+								collidedDestroyableBlocks = append(collidedDestroyableBlocks, block)
+							}
+						} else if block.kind == SteelBlock || block.kind == BorderBlock {
+							hitSteel = true
+						}
+						collision = true
+					}
+				}
+			}
+		}
+
+		hitBlock, hitTank := collision, false
+		if len(collidedDestroyableBlocks) != 0 {
+			if len(collidedDestroyableBlocks) > 2 {
+				panic("world: theoretically impossible")
+			}
+
+			firstCollidedBlock := collidedDestroyableBlocks[0]
+			var secondCollidedBlock *Block = nil
+			if len(collidedDestroyableBlocks) == 2 {
+				secondCollidedBlock = collidedDestroyableBlocks[1]
+			}
+			firstCollidedBlock.ProcessCollision(bullet, secondCollidedBlock)
+			if firstCollidedBlock.IsDestroyed() || bullet.IsUpgraded() {
+				w.stage.DestroyBlock(firstCollidedBlock)
+			}
+			if secondCollidedBlock != nil && (secondCollidedBlock.IsDestroyed() || bullet.IsUpgraded()) {
+				w.stage.DestroyBlock(secondCollidedBlock)
+			}
+			w.stage.changed()
+		} else { // check collision between bullet and tanks
+			for _, tank := range tanks {
+				if w.areEnemies(bullet.origin, tank) && !tank.OnCreation() {
+					tankRect := Rect(tank.Pos(), TankSize, TankSize)
+					intersect := bulletRect.Intersect(tankRect)
+					if intersect != pixel.ZR { // collision detected
+						if tank.Side() == BotSide {
+							botTank, _ := tank.(*Bot)
+							botTank.hp--
+							if botTank.isBonus {
+								botTank.isBonus = false
+								w.activeBonus = NewBonus(w.stage, w.playersPos(), w.rnd)
+								if w.activeBonus != nil {
+									w.events.Publish(BonusSpawned{Bonus: w.activeBonus})
+								}
+							}
+							destroyed := botTank.hp <= 0
+							if destroyed {
+								killer, _ := bullet.origin.(*Player)
+								w.destroyBot(botTank, killer)
+							}
+							w.events.Publish(TankHit{Tank: tank, Pos: bullet.pos, Destroyed: destroyed})
+						} else {
+							destroyed := w.destroyPlayer(tank.(*Player))
+							w.events.Publish(TankHit{Tank: tank, Pos: bullet.pos, Destroyed: destroyed})
+						}
+						collision, hitTank = true, true
+					}
+				}
+			}
+		}
+		// check collision between bullet and bullet
+		if !collision {
+			for j := 0; j < len(w.bullets); j++ {
+				bullet2 := w.bullets[j]
+				if i != j && w.areEnemies(bullet.origin, bullet2.origin) {
+					w2, h2 := BulletW, BulletH
+					if bullet.direction.IsHorizontal() {
+						w2, h2 = h2, w2
+					}
+					bullet2Rect := Rect(bullet2.pos, w2, h2)
+					intersect := bulletRect.Intersect(bullet2Rect)
+					if intersect != pixel.ZR { // collision detected
+						collision = true
+						bullet2.Destroy()
+					}
+				}
+			}
+		}
+
+		if hitBlock && !hitTank {
+			w.events.Publish(BlockHit{Tank: bullet.origin, Pos: bullet.pos, Steel: hitSteel})
+		}
+		if collision {
+			bullet.Destroy()
+		}
+	}
+	// remove destroyed bullets from slice, all of them are moved first
+	var tmpBullets []*Bullet
+	for _, b := range w.bullets {
+		if !b.destroyed {
+			tmpBullets = append(tmpBullets, b)
+		}
+	}
+	w.bullets = tmpBullets
+
+	// handle shooting
+	for _, tank := range tanks {
+		if w.canAct(tank) {
+			bullet := tank.Shoot(dt)
+			if bullet != nil {
+				w.bullets = append(w.bullets, bullet)
+				w.events.Publish(BulletFired{Tank: tank, Bullet: bullet})
+			}
+		}
+	}
+
+	if !gameOver && w.isGameOver() {
+		w.events.Publish(GameOver{})
+	}
+	if w.isVersus() && !w.isRoundOver {
+		w.isRoundOver, w.roundWinner = w.checkRound()
+	}
+	return false
+}
+
+func (w *World) bonusUpdate(dt float64) {
+	if w.activeBonus == nil {
+		return
+	}
+	w.activeBonus.Update(dt)
+	if w.activeBonus.IsExpired() {
+		w.activeBonus = nil
+		return
+	}
+	bonusR := Rect(w.activeBonus.pos, BonusSize, BonusSize)
+	for _, player := range w.players {
+		if player.IsOut() {
+			continue
+		}
+		playerR := Rect(player.pos, TankSize, TankSize)
+		if !player.onCreation && playerR.Intersect(bonusR) != pixel.ZR {
+			w.playerTakeBonus(player, w.activeBonus.bonusType)
+			w.activeBonus = nil
+			return
+		}
+	}
+	if !w.config.Rules.BotsTakeBonuses {
+		return
+	}
+	for _, b := range w.bots {
+		botR := Rect(b.pos, TankSize, TankSize)
+		if !b.onCreation && botR.Intersect(bonusR) != pixel.ZR {
+			w.botTakeBonus(w.activeBonus.bonusType)
+			w.activeBonus = nil
+			return
+		}
+	}
+}
+
+func (w *World) playerTakeBonus(player *Player, bonusType BonusType) {
+	if effect := bonusEffects[bonusType].Player; effect != nil {
+		if effect.PerPlayer {
+			player.effects.Add(w, effect, player)
+		} else {
+			w.effects.Add(w, effect, player)
+		}
+	}
+	w.events.Publish(BonusTaken{Type: bonusType, Player: player})
+}
+
+func (w *World) botTakeBonus(bonusType BonusType) {
+	if effect := bonusEffects[bonusType].Bot; effect != nil {
+		w.effects.Add(w, effect, nil)
+	}
+	w.events.Publish(BonusTaken{Type: bonusType})
+}
+
+// destroyPlayer hits player, it returns false if the player survived it.
+// The player respawns unless it has no lives left
+func (w *World) destroyPlayer(player *Player) bool {
+	if player.immune || player.IsOut() {
+		return false
+	}
+	if player.effects.IsActive(ShipEffect) { // ship takes the hit
+		player.effects.Remove(w, ShipEffect)
+		return false
+	}
+	player.lives--
+	player.effects.Clear(w)
+	w.events.Publish(TankDestroyed{Tank: player, Pos: player.pos})
+	player.ResetLevel()
+	if !player.IsOut() {
+		player.Respawn()
+	}
+	return true
+}
+
+// destroyBot removes bot from the stage, killer is rewarded for it if not nil
+func (w *World) destroyBot(b *Bot, killer *Player) {
+	i := w.botIndex(b)
+	if i < 0 { // already destroyed
+		return
+	}
+	if killer != nil {
+		w.destroyedBots = append(w.destroyedBots, b.botType)
+	}
+	w.events.Publish(TankDestroyed{Tank: b, Killer: killer, Pos: b.pos})
+	w.bots = append(w.bots[:i], w.bots[i+1:]...)
+}
+
+// annihilateBots destroys all bots, player is the one who took AnnihilationBonus
+func (w *World) annihilateBots(player *Player) {
+	var killer *Player
+	if w.config.Rules.GrenadeCountsAsKills {
+		killer = player
+	}
+	for _, b := range append([]*Bot(nil), w.bots...) {
+		w.destroyBot(b, killer)
+	}
+	w.newBotDuration = 0
+}
+
+func (w *World) botIndex(b *Bot) int {
+	for i, b2 := range w.bots {
+		if b2 == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// canSwim reports whether tank can pass through water block.
+// Tank that's already in water (e.g. lost its ship) can always get out of it
+func (w *World) canSwim(tank Tank, block *Block) bool {
+	if block.kind != WaterBlock {
+		return false
+	}
+	if player, ok := tank.(*Player); ok && player.hasShip {
+		return true
+	}
+	tankRect := Rect(tank.Pos(), TankSize, TankSize)
+	return tankRect.Intersect(Rect(block.pos, BlockSize, BlockSize)) != pixel.ZR
+}
+
+// canAct reports whether tank isn't stopped by TimeStopBonus or the end of the versus round
+func (w *World) canAct(tank Tank) bool {
+	if w.isRoundOver {
+		return false
+	}
+	if tank.Side() == HumanSide {
+		return !w.effects.IsActive(PlayerFrozenEffect)
+	}
+	return !w.effects.IsActive(TimeStopEffect)
+}
+
+// Tanks returns players in the game and then bots in order of creation
+func (w *World) Tanks() []Tank {
+	tanks := make([]Tank, 0, len(w.players)+len(w.bots))
+	for _, player := range w.players {
+		if !player.IsOut() {
+			tanks = append(tanks, player)
+		}
+	}
+	for _, b := range w.bots {
+		tanks = append(tanks, b)
+	}
+	return tanks
+}
+
+// playersPos returns positions of players in the game
+func (w *World) playersPos() []pixel.Vec {
+	var positions []pixel.Vec
+	for _, player := range w.players {
+		if !player.IsOut() {
+			positions = append(positions, player.pos)
+		}
+	}
+	return positions
+}
+
+// Hash returns hash of the simulation state, it's used to detect desyncs
+func (w *World) Hash() uint64 {
+	h := fnv.New64a()
+	write := func(v interface{}) {
+		_ = binary.Write(h, binary.LittleEndian, v)
+	}
+	write(w.tick)
+	for _, player := range w.players {
+		if player.IsOut() {
+			continue
+		}
+		write([]float64{player.pos.X, player.pos.Y})
+		write([]int32{int32(player.direction), int32(player.lives), int32(player.level), int32(player.score)})
+	}
+	for _, b := range w.bots {
+		write([]float64{b.pos.X, b.pos.Y})
+		write([]int32{int32(b.botType), int32(b.direction), int32(b.hp)})
+	}
+	for _, bullet := range w.bullets {
+		write([]float64{bullet.pos.X, bullet.pos.Y})
+	}
+	for _, blocks := range w.stage.Blocks {
+		for _, block := range blocks {
+			_, _ = h.Write([]byte(block.kind))
+			write(block.quadrants)
+		}
+	}
+	write(int32(w.stage.botPoolIndex))
+	if w.activeBonus != nil {
+		write([]float64{w.activeBonus.pos.X, w.activeBonus.pos.Y})
+		write(int32(w.activeBonus.bonusType))
+	}
+	return h.Sum64()
+}
+
+func (w *World) isStageCleared() bool {
+	return w.stage.IsPoolEmpty() && len(w.bots) == 0
+}
+
+// IsOver reports whether the stage is cleared or the versus round is decided
+func (w *World) IsOver() bool {
+	if w.isVersus() {
+		return w.isRoundOver
+	}
+	return w.isStageCleared()
+}
+
+// isGameOver reports whether the co-op game is lost, the game goes on for now
+func (w *World) isGameOver() bool {
+	if w.isVersus() {
+		return false
+	}
+	if w.stage.IsHQDestroyed(0) {
+		return true
+	}
+	for _, player := range w.players {
+		if !player.IsOut() {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *World) isVersus() bool {
+	return w.config.Match != nil
+}
+
+// team returns the team of player, in co-op all players defend the same HQ
+func (w *World) team(player *Player) int {
+	if w.isVersus() {
+		return player.index
+	}
+	return 0
+}
+
+// areEnemies reports whether bullets of tank a hurt tank b.
+// Bots are always enemies of players, in versus players are also enemies of each other
+func (w *World) areEnemies(a, b Tank) bool {
+	if a.Side() != b.Side() {
+		return true
+	}
+	playerA, ok := a.(*Player)
+	playerB, ok2 := b.(*Player)
+	return ok && ok2 && w.isVersus() && playerA != playerB
+}
+
+// checkRound reports whether the versus round is decided and who won it.
+// A team loses when its HQ is destroyed or its player has no lives left
+func (w *World) checkRound() (bool, int) {
+	var lost []bool
+	for _, player := range w.players {
+		team := w.team(player)
+		lost = append(lost, player.IsOut() || w.stage.IsHQDestroyed(team))
+	}
+	switch {
+	case lost[0] && lost[1]:
+		return true, -1
+	case lost[0]:
+		return true, 1
+	case lost[1]:
+		return true, 0
+	}
+	return false, -1
+}
@@ -0,0 +1,239 @@
+package sim
+
+import (
+	"battlecity/assets"
+	"github.com/faiface/pixel"
+	"testing"
+	"time"
+)
+
+// newTestWorld starts the first stage with one player, nothing is ticked yet
+func newTestWorld(t *testing.T, rules Rules) *World {
+	t.Helper()
+	return NewWorld(Config{StagesConfigs: assets.Stages, Rules: rules, Seed: 1, Players: 1}, 1, nil)
+}
+
+// addTestBots puts bots of botTypes on the stage, away from the player
+func addTestBots(w *World, botTypes ...BotType) {
+	for i, botType := range botTypes {
+		b := NewBot(botType, pixel.V(float64(40+i*TankSize*2), 200), false, w.rnd)
+		b.onCreation = false
+		w.bots = append(w.bots, b)
+	}
+}
+
+func TestBonusExpiresAndBlinks(t *testing.T) {
+	tests := []struct {
+		elapsed  time.Duration
+		blinking bool
+		expired  bool
+	}{
+		{0, false, false},
+		{bonusLifetime - bonusBlinkingTime - time.Millisecond, false, false},
+		{bonusLifetime - bonusBlinkingTime, true, false},
+		{bonusLifetime - time.Millisecond, true, false},
+		{bonusLifetime, true, true},
+	}
+	for _, test := range tests {
+		w := newTestWorld(t, Rules{})
+		bonus := newBonus(LifeBonus, pixel.V(150, 150)) // nobody takes it
+		w.activeBonus = bonus
+		w.bonusUpdate(test.elapsed.Seconds())
+		if expired := w.activeBonus == nil; expired != test.expired {
+			t.Errorf("after %v expired = %v, want %v", test.elapsed, expired, test.expired)
+		}
+		if bonus.IsBlinking() != test.blinking {
+			t.Errorf("after %v IsBlinking() = %v, want %v", test.elapsed, bonus.IsBlinking(), test.blinking)
+		}
+	}
+}
+
+func TestBonusScore(t *testing.T) {
+	for bonusType := range bonusEffects {
+		w := newTestWorld(t, Rules{})
+		player := w.players[0]
+		player.onCreation = false
+		for pickup := 1; pickup <= 2; pickup++ {
+			w.activeBonus = newBonus(bonusType, player.pos)
+			w.bonusUpdate(TickDt)
+			if w.activeBonus != nil {
+				t.Fatalf("bonus %d isn't taken", bonusType)
+			}
+			if player.score != pickup*BonusScore {
+				t.Errorf("bonus %d: score after %d pickups = %d, want %d", bonusType, pickup, player.score, pickup*BonusScore)
+			}
+		}
+	}
+}
+
+func TestAnnihilationKills(t *testing.T) {
+	botTypes := []BotType{DefaultBot, RapidMovementBot, ArmoredBot}
+	var botsScore int
+	for _, botType := range botTypes {
+		botsScore += botType.Score()
+	}
+	tests := []struct {
+		rules     Rules
+		score     int
+		destroyed int
+	}{
+		{Rules{}, BonusScore, 0},
+		{Rules{GrenadeCountsAsKills: true}, BonusScore + botsScore, len(botTypes)},
+	}
+	for _, test := range tests {
+		w := newTestWorld(t, test.rules)
+		addTestBots(w, botTypes...)
+		player := w.players[0]
+		w.playerTakeBonus(player, AnnihilationBonus)
+		if len(w.bots) != 0 {
+			t.Errorf("%+v: %d bots survived", test.rules, len(w.bots))
+		}
+		if player.score != test.score {
+			t.Errorf("%+v: score = %d, want %d", test.rules, player.score, test.score)
+		}
+		if len(w.destroyedBots) != test.destroyed {
+			t.Errorf("%+v: %d bots count as destroyed, want %d", test.rules, len(w.destroyedBots), test.destroyed)
+		}
+	}
+}
+
+func TestDestroyBot(t *testing.T) {
+	w := newTestWorld(t, Rules{})
+	addTestBots(w, RapidShootingBot, DefaultBot)
+	player := w.players[0]
+	w.destroyBot(w.bots[0], player)
+	w.destroyBot(w.bots[0], nil)
+	if len(w.bots) != 0 {
+		t.Fatalf("%d bots aren't destroyed", len(w.bots))
+	}
+	if player.score != RapidShootingBot.Score() {
+		t.Errorf("score = %d, want %d", player.score, RapidShootingBot.Score())
+	}
+	if len(w.destroyedBots) != 1 || w.destroyedBots[0] != RapidShootingBot {
+		t.Errorf("destroyed bots = %v, want only the one with a killer", w.destroyedBots)
+	}
+}
+
+func TestBotTakeBonusArmorsBots(t *testing.T) {
+	for _, bonusType := range []BonusType{ImmunityBonus, UpgradeBonus} {
+		w := newTestWorld(t, Rules{BotsTakeBonuses: true})
+		addTestBots(w, DefaultBot, ArmoredBot)
+		w.bots[1].hp = 1
+		w.botTakeBonus(bonusType)
+		for _, b := range w.bots {
+			if b.hp != ArmoredBotHP {
+				t.Errorf("bonus %d: bot %d has %d hp, want %d", bonusType, b.botType, b.hp, ArmoredBotHP)
+			}
+		}
+		if w.players[0].score != 0 {
+			t.Errorf("bonus %d: player scored %d for the bot's bonus", bonusType, w.players[0].score)
+		}
+	}
+}
+
+func TestBotTakeBonusRemovesHQWalls(t *testing.T) {
+	w := newTestWorld(t, Rules{BotsTakeBonuses: true})
+	w.playerTakeBonus(w.players[0], HQArmorBonus)
+	if !w.stage.IsHQArmored(0) {
+		t.Fatal("HQ isn't armored by the player's bonus")
+	}
+	w.botTakeBonus(HQArmorBonus)
+	if w.stage.IsHQArmored(0) || w.effects.IsActive(HQArmorEffect) {
+		t.Error("HQ is still armored")
+	}
+	for _, index := range w.stage.getHQArmorIndexes(0) {
+		if block := w.stage.Blocks[index[0]][index[1]]; block.kind != SpaceBlock {
+			t.Errorf("block %v around HQ is %q, want none", index, block.kind)
+		}
+	}
+	// the armor doesn't come back when the player's effect would have expired
+	w.effects.Update(w, (time.Second * 21).Seconds())
+	if w.stage.IsHQArmored(0) {
+		t.Error("HQ is armored again")
+	}
+}
+
+func TestPlayerOutOfLives(t *testing.T) {
+	w := NewWorld(Config{StagesConfigs: assets.Stages, Seed: 1, Players: 2}, 1, nil)
+	out, other := w.players[0], w.players[1]
+	var gameOver bool
+	w.Events().Subscribe(func(event Event) {
+		if _, ok := event.(GameOver); ok {
+			gameOver = true
+		}
+	})
+	out.lives, out.immune, out.onCreation = 0, false, false
+	if !w.destroyPlayer(out) || !out.IsOut() {
+		t.Fatalf("the player with %d lives is still in the game", out.lives)
+	}
+	if out.onCreation {
+		t.Error("the player respawned without lives")
+	}
+	for _, tank := range w.Tanks() {
+		if tank == out {
+			t.Error("the player is among tanks")
+		}
+	}
+	if w.destroyPlayer(out) || out.lives != -1 {
+		t.Errorf("the player is hit again, it has %d lives", out.lives)
+	}
+	hash := w.Hash()
+	out.pos = other.pos
+	if w.Hash() != hash {
+		t.Error("the player is hashed")
+	}
+
+	w.activeBonus = newBonus(LifeBonus, out.pos)
+	w.bonusUpdate(TickDt)
+	if w.activeBonus == nil {
+		t.Error("the player took the bonus")
+	}
+	for tick := 0; tick < 120; tick++ {
+		w.Tick([]Input{InputUp | InputFire, InputLeft})
+	}
+	if gameOver {
+		t.Fatal("the game is over while the other player plays on")
+	}
+	if other.pos == other.spawnPos {
+		t.Error("the other player doesn't move")
+	}
+
+	other.lives, other.immune = 0, false
+	w.destroyPlayer(other)
+	if !w.isGameOver() {
+		t.Error("the game isn't over without players")
+	}
+}
+
+func TestShipTakesHit(t *testing.T) {
+	tests := []struct {
+		name      string
+		ship      bool
+		hits      int
+		destroyed bool // by the last hit
+		lives     int
+	}{
+		{"no ship", false, 1, true, 1},
+		{"ship", true, 1, false, 2},
+		{"ship, two hits", true, 2, true, 1},
+	}
+	for _, test := range tests {
+		w := newTestWorld(t, Rules{})
+		player := w.players[0]
+		player.immune = false
+		if test.ship {
+			w.playerTakeBonus(player, ShipBonus)
+		}
+		var destroyed bool
+		for hit := 0; hit < test.hits; hit++ {
+			destroyed = w.destroyPlayer(player)
+			player.immune = false
+		}
+		if destroyed != test.destroyed || player.lives != test.lives {
+			t.Errorf("%s: destroyed %v with %d lives, want %v with %d", test.name, destroyed, player.lives, test.destroyed, test.lives)
+		}
+		if player.hasShip || player.effects.IsActive(ShipEffect) {
+			t.Errorf("%s: the player still has a ship", test.name)
+		}
+	}
+}
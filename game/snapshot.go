@@ -0,0 +1,21 @@
+package game
+
+import "battlecity/game/sim"
+
+// Snapshot is a copy of the simulation state and of what's shown of it, see PlaygroundState.Save
+type Snapshot struct {
+	world      sim.Snapshot
+	explosions explosionsSnapshot // not simulated, but explosions of undone ticks must disappear
+}
+
+// Save copies the simulation state to snapshot, its memory is reused
+func (s *PlaygroundState) Save(snapshot *Snapshot) {
+	s.world.Save(&snapshot.world)
+	snapshot.explosions = s.explosions.Save(snapshot.explosions)
+}
+
+// Restore returns the simulation to the saved state
+func (s *PlaygroundState) Restore(snapshot *Snapshot) {
+	s.world.Restore(&snapshot.world)
+	s.explosions.Restore(snapshot.explosions)
+}
@@ -0,0 +1,31 @@
+// Package speaker plays sounds on the sound device. It needs cgo, so it's kept out of sfx,
+// which the tests and the dedicated server use without a sound device
+package speaker
+
+import (
+	"battlecity/game/sfx"
+	"github.com/faiface/beep"
+	beepspeaker "github.com/faiface/beep/speaker"
+	"time"
+)
+
+// Sink is the sfx.AudioSink of the sound device
+type Sink struct{}
+
+func (Sink) Start(sr beep.SampleRate, mixdown beep.Streamer) error {
+	if err := beepspeaker.Init(sr, sr.N(time.Second/10)); err != nil {
+		return err
+	}
+	beepspeaker.Play(mixdown)
+	return nil
+}
+
+func (Sink) Lock() {
+	beepspeaker.Lock()
+}
+
+func (Sink) Unlock() {
+	beepspeaker.Unlock()
+}
+
+func (Sink) Cue(sfx.Sound) {}
@@ -2,6 +2,7 @@ package game
 
 import (
 	"battlecity/game/sfx"
+	"battlecity/game/sim"
 	"fmt"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
@@ -16,46 +17,51 @@ type StageTitleState struct {
 	stageNum       int
 	stateStartTime time.Time
 	stageTxt       *text.Text
-	player         *Player
+	players        []*sim.Player
 }
 
-func NewStageTitleState(config StateConfig, stageNum int, player *Player) *StageTitleState {
+func NewStageTitleState(config StateConfig, stageNum int, players []*sim.Player) *StageTitleState {
 	s := new(StageTitleState)
 	s.config = config
 	s.stageNum = stageNum
-	s.player = player
+	s.players = players
 
 	atlas := text.NewAtlas(s.config.DefaultFont, text.ASCII)
 	s.stageTxt = text.New(pixel.V(0, 0), atlas)
 	s.stageTxt.Color = colornames.Black
 	var txt string
-	if s.stageNum < 10 {
+	switch {
+	case s.config.Match != nil:
+		txt = fmt.Sprintf("ROUND %d  %s", s.config.Match.Round(), s.config.Match.Score())
+	case s.stageNum < 10:
 		txt = fmt.Sprintf("STAGE  %d", s.stageNum)
-	} else {
+	default:
 		txt = fmt.Sprintf("STAGE %d", s.stageNum)
 	}
-	r := s.stageTxt.BoundsOf(txt)
-	s.stageTxt.Orig.X = config.WindowBounds.W()/2 - r.W()/2
-	s.stageTxt.Orig.Y = config.WindowBounds.H()/2 - r.H()/2
 	_, _ = fmt.Fprintln(s.stageTxt, txt)
 
 	sfx.ResetForNewStage()
+	sfx.PlayMusic(sfx.StageMusic)
 
 	return s
 }
 
-func (s *StageTitleState) Update(_ *pixelgl.Window, _ float64) State {
+func (s *StageTitleState) Update(_ *pixelgl.Window, dt float64) State {
+	return s.Step(dt)
+}
+
+func (s *StageTitleState) Step(_ float64) State {
 	now := time.Now()
 	if s.stateStartTime.IsZero() {
 		s.stateStartTime = now
 	}
 	if now.Sub(s.stateStartTime) >= time.Second*3 {
-		return NewPlaygroundState(s.config, s.stageNum, s.player)
+		return NewPlaygroundState(s.config, s.stageNum, s.players)
 	}
 	return nil
 }
 
-func (s *StageTitleState) Draw(win *pixelgl.Window, _ float64) {
-	win.Clear(color.RGBA{R: 99, G: 99, B: 99, A: 1})
-	s.stageTxt.Draw(win, pixel.IM)
+func (s *StageTitleState) Draw(canvas *pixelgl.Canvas, _ float64) {
+	canvas.Clear(color.RGBA{R: 99, G: 99, B: 99, A: 1})
+	s.stageTxt.Draw(canvas, pixel.IM.Moved(canvas.Bounds().Center().Sub(s.stageTxt.Bounds().Center())))
 }
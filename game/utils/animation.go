@@ -50,6 +50,14 @@ func (a *Animation) CurrentFrame(dt float64) *pixel.Sprite {
 	panic("animation: unreachable statement")
 }
 
+// Duration returns duration of all circles, or of one circle for infinite animations
+func (a *Animation) Duration() time.Duration {
+	if a.maxCircleNum > 0 {
+		return a.animationDuration * time.Duration(a.maxCircleNum)
+	}
+	return a.animationDuration
+}
+
 func (a *Animation) Reset() {
 	a.totalDuration = 0
 }
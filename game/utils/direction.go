@@ -58,6 +58,6 @@ func (d Direction) IsPerpendicular(d2 Direction) bool {
 	return math.Mod(float64(d2+d), 2) != 0
 }
 
-func RandomDirection() Direction {
-	return Direction(rand.Intn(4))
+func RandomDirection(rnd *rand.Rand) Direction {
+	return Direction(rnd.Intn(4))
 }
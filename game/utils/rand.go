@@ -0,0 +1,46 @@
+package utils
+
+// Source is a rand.Source64 which state can be saved and restored by copying, unlike the standard one.
+// It's the SplitMix64 generator
+type Source struct {
+	state uint64
+}
+
+func NewSource(seed int64) *Source {
+	s := new(Source)
+	s.Seed(seed)
+	return s
+}
+
+func (s *Source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// State returns the generator state, Seed with it continues the same sequence
+func (s *Source) State() uint64 {
+	return s.state
+}
+
+func (s *Source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *Source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Read fills p with random bytes, unlike rand.Rand.Read it doesn't keep leftover bytes between calls
+func (s *Source) Read(p []byte) (int, error) {
+	for i := 0; i < len(p); i += 8 {
+		v := s.Uint64()
+		for j := i; j < i+8 && j < len(p); j++ {
+			p[j] = byte(v)
+			v >>= 8
+		}
+	}
+	return len(p), nil
+}
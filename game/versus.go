@@ -0,0 +1,56 @@
+package game
+
+import (
+	"battlecity/game/sfx"
+	"fmt"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+)
+
+// VersusResultState shows the winner of the match
+type VersusResultState struct {
+	config StateConfig
+	txt    *text.Text
+}
+
+func NewVersusResultState(config StateConfig) *VersusResultState {
+	s := new(VersusResultState)
+	s.config = config
+	atlas := text.NewAtlas(s.config.DefaultFont, text.ASCII)
+	s.txt = text.New(pixel.V(0, 0), atlas)
+	s.txt.LineHeight = atlas.LineHeight() * 1.5
+	lines := []string{
+		fmt.Sprintf("PLAYER %d WINS", s.config.Match.Winner()+1),
+		s.config.Match.Score(),
+		"",
+		"ENTER - MENU",
+	}
+	// lines are centered around the origin, Draw moves them to the center of the screen
+	s.txt.Orig = pixel.V(0, s.txt.LineHeight*float64(len(lines))/2)
+	s.txt.Dot = s.txt.Orig
+	for i, line := range lines {
+		s.txt.Color = colornames.White
+		if i == 0 {
+			s.txt.Color = colornames.Firebrick
+		}
+		s.txt.Dot.X = -s.txt.BoundsOf(line).W() / 2
+		_, _ = fmt.Fprintln(s.txt, line)
+	}
+	sfx.PlayScoreTally()
+	return s
+}
+
+func (s *VersusResultState) Update(win *pixelgl.Window, _ float64) State {
+	if win.JustPressed(pixelgl.KeyEnter) || win.JustPressed(pixelgl.KeyEscape) {
+		s.config.Match = nil
+		return NewMainMenuState(s.config)
+	}
+	return nil
+}
+
+func (s *VersusResultState) Draw(canvas *pixelgl.Canvas, _ float64) {
+	canvas.Clear(colornames.Black)
+	s.txt.Draw(canvas, pixel.IM.Moved(canvas.Bounds().Center()))
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"battlecity/assets"
 	"battlecity/game"
+	"battlecity/game/atlas"
 	"battlecity/game/explosions"
 	"battlecity/game/sfx"
+	"battlecity/game/speaker"
 	"bytes"
-	"embed"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
@@ -13,74 +17,278 @@ import (
 	"golang.org/x/image/font"
 	"image"
 	_ "image/png"
-	"math/rand"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
 	"time"
 )
 
-//go:embed assets/stages/*
-var stagesConfigs embed.FS
-
-//go:embed assets/sfx/*
-var sfxFiles embed.FS
-
-//go:embed assets/spritesheet.png
-var spritesheetPng []byte
-
-//go:embed assets/PressStart.ttf
-var defaultFontTtf []byte
+func loadSprites() (*atlas.Atlas, error) {
+	picture, err := loadPicture(assets.Spritesheet)
+	if err != nil {
+		return nil, err
+	}
+	return atlas.Load(picture, assets.Atlas)
+}
 
-func loadSpritesheet() (pixel.Picture, error) {
-	reader := bytes.NewReader(spritesheetPng)
-	img, _, err := image.Decode(reader)
+func loadPicture(data []byte) (*pixel.PictureData, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	return pixel.PictureDataFromImage(img), nil
 }
 
-func loadFont() (font.Face, error) {
-	f, err := truetype.Parse(defaultFontTtf)
+func loadFont(data []byte) (font.Face, error) {
+	f, err := truetype.Parse(data)
 	if err != nil {
 		return nil, err
 	}
 
 	return truetype.NewFace(f, &truetype.Options{
-		Size:              36,
+		Size:              8,
 		GlyphCacheEntries: 1,
 	}), nil
 }
 
+// loadTheme replaces sprites and the font by ones of the texture pack in dir. The pack has spritesheet.png
+// with atlas.json naming its sprites like the built-in one, or the built-in layout without it, and font.ttf.
+// Any of them may be missing, sprites missing in the pack are the built-in ones. Sprites of another size are scaled
+// to the size of the built-in ones, the game is drawn at its native resolution whatever the pack is
+func loadTheme(dir string, sprites *atlas.Atlas, face font.Face) (*atlas.Atlas, font.Face, error) {
+	files := os.DirFS(dir)
+	spritesheet, err := readThemeFile(files, "spritesheet.png")
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := readThemeFile(files, "atlas.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	fontData, err := readThemeFile(files, "font.ttf")
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case spritesheet == nil && fontData == nil:
+		return nil, nil, errors.New("no spritesheet.png or font.ttf")
+	case spritesheet == nil && manifest != nil:
+		return nil, nil, errors.New("atlas.json needs spritesheet.png")
+	}
+
+	if spritesheet != nil {
+		picture, err := loadPicture(spritesheet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("spritesheet.png: %w", err)
+		}
+		if sprites, err = sprites.Theme(picture, manifest); err != nil {
+			return nil, nil, fmt.Errorf("sprites: %w", err)
+		}
+	}
+	if fontData != nil {
+		if face, err = loadFont(fontData); err != nil {
+			return nil, nil, fmt.Errorf("font.ttf: %w", err)
+		}
+	}
+	return sprites, face, nil
+}
+
+// readThemeFile returns nil if the file is missing
+func readThemeFile(files fs.FS, name string) ([]byte, error) {
+	data, err := fs.ReadFile(files, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+var (
+	spectateAddr = flag.String("spectate", "", "watch the game on the dedicated server at host:port")
+	connectAddr  = flag.String("connect", "", "play on the dedicated server at host:port")
+	settingsPath = flag.String("settings", "", "settings file (default battlecity/settings.json in the user config dir)")
+	flagSettings = game.DefaultSettings() // flags set on the command line override the settings file
+)
+
+func init() {
+	flag.IntVar(&flagSettings.Width, "width", flagSettings.Width, "window width")
+	flag.IntVar(&flagSettings.Height, "height", flagSettings.Height, "window height")
+	flag.BoolVar(&flagSettings.Fullscreen, "fullscreen", flagSettings.Fullscreen, "start fullscreen, Alt+Enter or F11 toggles it")
+	flag.IntVar(&flagSettings.Monitor, "monitor", flagSettings.Monitor, "monitor of fullscreen from 1, 0 for the primary one")
+	flag.BoolVar(&flagSettings.VSync, "vsync", flagSettings.VSync, "vertical sync")
+	flag.IntVar(&flagSettings.Scale, "scale", flagSettings.Scale, "integer scale of the 256x240 screen, overrides width and height")
+	flag.BoolVar(&flagSettings.IntegerScaling, "integer-scaling", flagSettings.IntegerScaling, "scale the screen by whole multiples only")
+	flag.IntVar(&flagSettings.Stage, "stage", flagSettings.Stage, "the first stage")
+	flag.Var(&flagSettings.Difficulty, "difficulty", "normal, easy or hard")
+	flag.Int64Var(&flagSettings.Seed, "seed", flagSettings.Seed, "game seed, 0 for a random one")
+	flag.BoolVar(&flagSettings.GrenadeCountsAsKills, "grenade-kills", flagSettings.GrenadeCountsAsKills, "bots destroyed by grenade give score and count as kills")
+	flag.BoolVar(&flagSettings.BotsTakeBonuses, "bots-bonuses", flagSettings.BotsTakeBonuses, "bots pick up bonuses with their own effects")
+	flag.BoolVar(&flagSettings.NeutralBots, "neutral-bots", flagSettings.NeutralBots, "bots appear in versus arenas and attack both players")
+	flag.Float64Var(&flagSettings.Volume, "volume", flagSettings.Volume, "master volume from 0 to 1")
+	flag.Float64Var(&flagSettings.MusicVolume, "music-volume", flagSettings.MusicVolume, "music volume from 0 to 1")
+	flag.Float64Var(&flagSettings.SfxVolume, "sfx-volume", flagSettings.SfxVolume, "sound effects volume from 0 to 1")
+	flag.BoolVar(&flagSettings.Muted, "mute", flagSettings.Muted, "start muted, M toggles it")
+	flag.BoolVar(&flagSettings.Music, "music", flagSettings.Music, "play background music")
+	flag.StringVar(&flagSettings.StagesDir, "stages", flagSettings.StagesDir, "directory of stage files instead of built-in stages")
+	flag.StringVar(&flagSettings.Theme, "theme", flagSettings.Theme, "directory of a texture pack with spritesheet.png, atlas.json and font.ttf, sprites are scaled to the native 256x240 resolution")
+}
+
+// loadSettings reads the settings file and applies flags set on the command line, it returns path of the file
+func loadSettings(configDir string) (game.Settings, string, error) {
+	path := *settingsPath
+	if path == "" && configDir != "" {
+		path = filepath.Join(configDir, "battlecity", "settings.json")
+	}
+	settings := game.DefaultSettings()
+	if path != "" {
+		var err error
+		if settings, err = game.LoadSettings(path); err != nil {
+			return settings, path, err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "width":
+			settings.Width = flagSettings.Width
+		case "height":
+			settings.Height = flagSettings.Height
+		case "fullscreen":
+			settings.Fullscreen = flagSettings.Fullscreen
+		case "monitor":
+			settings.Monitor = flagSettings.Monitor
+		case "vsync":
+			settings.VSync = flagSettings.VSync
+		case "scale":
+			settings.Scale = flagSettings.Scale
+		case "integer-scaling":
+			settings.IntegerScaling = flagSettings.IntegerScaling
+		case "stage":
+			settings.Stage = flagSettings.Stage
+		case "difficulty":
+			settings.Difficulty = flagSettings.Difficulty
+		case "seed":
+			settings.Seed = flagSettings.Seed
+		case "grenade-kills":
+			settings.GrenadeCountsAsKills = flagSettings.GrenadeCountsAsKills
+		case "bots-bonuses":
+			settings.BotsTakeBonuses = flagSettings.BotsTakeBonuses
+		case "neutral-bots":
+			settings.NeutralBots = flagSettings.NeutralBots
+		case "volume":
+			settings.Volume = flagSettings.Volume
+		case "music-volume":
+			settings.MusicVolume = flagSettings.MusicVolume
+		case "sfx-volume":
+			settings.SfxVolume = flagSettings.SfxVolume
+		case "mute":
+			settings.Muted = flagSettings.Muted
+		case "music":
+			settings.Music = flagSettings.Music
+		case "stages":
+			settings.StagesDir = flagSettings.StagesDir
+		case "theme":
+			settings.Theme = flagSettings.Theme
+		}
+	})
+	return settings, path, nil
+}
+
 func run() {
-	rand.Seed(time.Now().UnixNano())
-	cfg := pixelgl.WindowConfig{
-		Title:  "Battle City 2022",
-		Bounds: pixel.R(0, 0, 1024, 960),
-		VSync:  true,
+	if err := runGame(); err != nil {
+		log.Fatalf("battlecity: %v", err)
 	}
-	win, err := pixelgl.NewWindow(cfg)
+}
+
+func runGame() error {
+	configDir, _ := os.UserConfigDir()
+	settings, settingsPath, err := loadSettings(configDir)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	stages := assets.Stages
+	if settings.StagesDir != "" {
+		if _, err := os.Stat(settings.StagesDir); err != nil {
+			return fmt.Errorf("stages: %w", err)
+		}
+		stages = os.DirFS(settings.StagesDir)
+	}
+	monitors := pixelgl.Monitors()
+	if err := settings.Validate(stages, len(monitors)); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
 	}
 
-	err = sfx.Init(sfxFiles)
+	seed := settings.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	width, height := settings.WindowSize()
+	cfg := pixelgl.WindowConfig{
+		Title:     "Battle City 2022",
+		Bounds:    pixel.R(0, 0, float64(width), float64(height)),
+		VSync:     settings.VSync,
+		Resizable: true,
+	}
+	var monitor *pixelgl.Monitor
+	if settings.Monitor > 0 {
+		monitor = monitors[settings.Monitor-1]
+	}
+	win, err := pixelgl.NewWindow(cfg)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("window: %w", err)
 	}
-	spritesheet, err := loadSpritesheet()
+	if err := sfx.Init(assets.Sfx, speaker.Sink{}); err != nil {
+		return fmt.Errorf("sounds: %w", err)
+	}
+	if err := sfx.InitMusic(assets.Music); err != nil {
+		return fmt.Errorf("music: %w", err)
+	}
+	settings.ApplyAudio()
+	sprites, err := loadSprites()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("sprites: %w", err)
 	}
-	defaultFont, err := loadFont()
+	defaultFont, err := loadFont(assets.Font)
 	if err != nil {
-		panic(err)
-	}
-	explosions.InnitExplosionFrames(spritesheet, game.Scale)
-	g := game.NewGame(game.StateConfig{
-		Spritesheet:   spritesheet,
-		DefaultFont:   defaultFont,
-		StagesConfigs: stagesConfigs,
-		WindowBounds:  cfg.Bounds,
-	})
+		return fmt.Errorf("font: %w", err)
+	}
+	if settings.Theme != "" {
+		if sprites, defaultFont, err = loadTheme(settings.Theme, sprites, defaultFont); err != nil {
+			return fmt.Errorf("theme %s: %w", settings.Theme, err)
+		}
+	}
+	explosions.InnitExplosionFrames(sprites)
+	config := game.StateConfig{
+		Sprites:        sprites,
+		DefaultFont:    defaultFont,
+		StagesConfigs:  stages,
+		ScreenBounds:   game.ScreenBounds,
+		IntegerScaling: settings.IntegerScaling,
+		Monitor:        monitor,
+		Seed:           seed,
+		Rules:          settings.Rules(),
+		Difficulty:     settings.Difficulty,
+		FirstStage:     settings.Stage,
+		SettingsPath:   settingsPath,
+	}
+	config.Bindings = game.DefaultBindings()
+	if configDir != "" {
+		config.SavePath = filepath.Join(configDir, "battlecity", "save.dat")
+		config.BindingsPath = filepath.Join(configDir, "battlecity", "bindings.json")
+		if config.Bindings, err = game.LoadBindings(config.BindingsPath); err != nil {
+			log.Printf("%v, default bindings are used", err)
+			config.Bindings = game.DefaultBindings()
+		}
+	}
+	var g *game.Game
+	switch {
+	case *spectateAddr != "":
+		g = game.NewRemoteGame(config, *spectateAddr, true)
+	case *connectAddr != "":
+		g = game.NewRemoteGame(config, *connectAddr, false)
+	default:
+		g = game.NewGame(config)
+	}
+	g.SetFullscreen(win, settings.Fullscreen)
 
 	secondTick := time.Tick(time.Second)
 	frames := 0
@@ -100,8 +308,10 @@ func run() {
 		default:
 		}
 	}
+	return nil
 }
 
 func main() {
+	flag.Parse()
 	pixelgl.Run(run)
 }